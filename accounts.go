@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"posadas-sistema/config"
+	"posadas-sistema/database"
+	"posadas-sistema/handlers"
+	"posadas-sistema/store"
+)
+
+// openApp opens and migrates the configured database, for the commands
+// that work on it the way the server does
+func openApp(cfg *config.Config) *handlers.App {
+	database.InitDB(cfg.DBDriver, cfg.DBSource())
+	handlers.Configure(cfg, files)
+	return handlers.NewApp(store.NewSQL(database.DB))
+}
+
+// createAdminCommand implements "create-admin".
+//
+//	server create-admin -username maria [-role coordinator] [-email ...] [-password ...]
+func createAdminCommand(args []string) {
+	fs := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	username := fs.String("username", "", "name of the new account")
+	password := fs.String("password", "", "password (a random one that must be changed is generated if empty)")
+	role := fs.String("role", handlers.RoleViewer, "superadmin, coordinator, attendance or viewer")
+	email := fs.String("email", "", "email address, for password resets")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: server create-admin -username NAME [-role ROLE] [-email EMAIL] [-password PASS] [config flags]")
+		fs.PrintDefaults()
+	}
+
+	cfg, err := config.LoadFlags(fs, args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app := openApp(cfg)
+
+	newPassword, err := app.CreateAdmin(*username, *password, *role, *email)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Account %q created with role %s.\n", *username, *role)
+	if *password == "" {
+		fmt.Printf("Temporary password: %s (it must be changed on the first login)\n", newPassword)
+	}
+}
+
+// resetPasswordCommand implements "reset-password".
+//
+//	server reset-password -username maria [-password ...]
+func resetPasswordCommand(args []string) {
+	fs := flag.NewFlagSet("reset-password", flag.ExitOnError)
+	username := fs.String("username", "", "account to change")
+	password := fs.String("password", "", "new password (a random one that must be changed is generated if empty)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: server reset-password -username NAME [-password PASS] [config flags]")
+		fmt.Fprintln(fs.Output(), "Sets a new password and closes the account's sessions.")
+		fs.PrintDefaults()
+	}
+
+	cfg, err := config.LoadFlags(fs, args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app := openApp(cfg)
+
+	newPassword, err := app.ResetPassword(*username, *password)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("The password of %q was changed and its sessions were closed.\n", *username)
+	if *password == "" {
+		fmt.Printf("Temporary password: %s (it must be changed on the next login)\n", newPassword)
+	}
+}
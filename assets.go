@@ -0,0 +1,12 @@
+package main
+
+import "embed"
+
+// files holds the templates and static files, compiled into the binary so
+// the server runs from any directory. template_dir and static_dir replace
+// them with a directory on disk. The front-end libraries in static/vendor
+// are downloaded by go generate and committed.
+//
+//go:generate go run ./tools/fetch-assets
+//go:embed templates static
+var files embed.FS
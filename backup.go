@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"posadas-sistema/backup"
+	"posadas-sistema/config"
+	"posadas-sistema/database"
+)
+
+// backupManager returns the snapshot manager of the configuration
+func backupManager(cfg *config.Config) *backup.Manager {
+	return &backup.Manager{Dir: cfg.BackupDir, Keep: cfg.BackupKeep, Passphrase: cfg.BackupPassphrase}
+}
+
+// backupCommand implements "backup": it takes a snapshot like the
+// scheduled ones. It is safe to run while the server is running.
+//
+//	server backup [-backup-dir ./backups]
+func backupCommand(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: server backup [config flags]")
+		fmt.Fprintln(fs.Output(), "Takes a snapshot of the database into the backup directory.")
+		fs.PrintDefaults()
+	}
+
+	cfg, err := config.LoadFlags(fs, args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := database.Open(cfg.DBDriver, cfg.DBSource()); err != nil {
+		log.Fatal(err)
+	}
+
+	snapshot, err := backupManager(cfg).Create()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Created %s/%s (%d KB).\n", cfg.BackupDir, snapshot.Name, snapshot.SizeKB())
+}
@@ -0,0 +1,268 @@
+// Package backup takes consistent copies of the SQLite database while the
+// server keeps running, using SQLite's online backup API. Manager keeps a
+// rotation of snapshots in a directory, optionally encrypted with a
+// passphrase, and Restore puts one back.
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	"posadas-sistema/database"
+)
+
+// Snapshot file names are posadas-YYYYMMDD-HHMMSS.db, with .enc appended
+// when encrypted
+const (
+	snapshotPrefix = "posadas-"
+	snapshotExt    = ".db"
+	encryptedExt   = ".enc"
+	nameLayout     = "20060102-150405"
+)
+
+// ErrUnsupported is returned when the database is not SQLite
+var ErrUnsupported = errors.New("backups are only supported for SQLite databases")
+
+// Snapshot is a backup file in the backup directory
+type Snapshot struct {
+	Name      string
+	Size      int64
+	CreatedAt time.Time
+	Encrypted bool
+}
+
+// SizeKB returns the size of the file in kilobytes, rounded up
+func (s Snapshot) SizeKB() int64 {
+	return (s.Size + 1023) / 1024
+}
+
+// Manager creates and rotates the snapshots of database.DB
+type Manager struct {
+	Dir  string
+	Keep int // newest snapshots kept, 0 = all
+	// Passphrase encrypts the snapshots when not empty
+	Passphrase string
+}
+
+// Copy writes a consistent copy of db to the file dest, which must not
+// exist yet. Writers are not blocked while it runs.
+func Copy(db *sql.DB, dest string) error {
+	ctx := context.Background()
+	destDB, err := sql.Open("sqlite3", dest)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+
+	destConn, err := destDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriver interface{}) error {
+		return srcConn.Raw(func(srcDriver interface{}) error {
+			return copyConn(destDriver, srcDriver)
+		})
+	})
+}
+
+// sqliteConn returns the go-sqlite3 connection of a driver connection,
+// which may be wrapped, like those of the database package that time the
+// queries
+func sqliteConn(driverConn interface{}) (*sqlite3.SQLiteConn, bool) {
+	if wrapped, ok := driverConn.(interface{ Unwrap() driver.Conn }); ok {
+		driverConn = wrapped.Unwrap()
+	}
+	conn, ok := driverConn.(*sqlite3.SQLiteConn)
+	return conn, ok
+}
+
+// copyConn runs the online backup between two go-sqlite3 connections
+func copyConn(destDriver, srcDriver interface{}) error {
+	dest, ok := sqliteConn(destDriver)
+	if !ok {
+		return ErrUnsupported
+	}
+	src, ok := sqliteConn(srcDriver)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	b, err := dest.Backup("main", src, "main")
+	if err != nil {
+		return err
+	}
+	// One step copies every page under a single read transaction, so the
+	// copy is a consistent view of the database
+	if _, err := b.Step(-1); err != nil {
+		b.Finish()
+		return err
+	}
+	return b.Finish()
+}
+
+// Create writes a new snapshot and removes the ones beyond Keep
+func (m *Manager) Create() (Snapshot, error) {
+	if database.IsPostgres() {
+		return Snapshot{}, ErrUnsupported
+	}
+	if err := os.MkdirAll(m.Dir, 0o700); err != nil {
+		return Snapshot{}, err
+	}
+
+	now := time.Now()
+	name := snapshotPrefix + now.Format(nameLayout) + snapshotExt
+	if m.Passphrase != "" {
+		name += encryptedExt
+	}
+	path := filepath.Join(m.Dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return Snapshot{}, fmt.Errorf("snapshot %s already exists", name)
+	}
+
+	// The copy is made next to the snapshot and renamed once complete, so
+	// the directory never holds a half-written snapshot
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := Copy(database.DB, tmp); err != nil {
+		os.Remove(tmp)
+		return Snapshot{}, err
+	}
+	if m.Passphrase != "" {
+		if err := encryptFile(tmp, m.Passphrase); err != nil {
+			os.Remove(tmp)
+			return Snapshot{}, err
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return Snapshot{}, err
+	}
+
+	if err := m.rotate(); err != nil {
+		slog.Warn("rotating backups", "err", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{Name: name, Size: info.Size(), CreatedAt: now, Encrypted: m.Passphrase != ""}, nil
+}
+
+// List returns the snapshots in the backup directory, newest first
+func (m *Manager) List() ([]Snapshot, error) {
+	entries, err := os.ReadDir(m.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		createdAt, ok := parseName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, Snapshot{
+			Name:      entry.Name(),
+			Size:      info.Size(),
+			CreatedAt: createdAt,
+			Encrypted: strings.HasSuffix(entry.Name(), encryptedExt),
+		})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt) })
+	return snapshots, nil
+}
+
+// Path returns the path of the snapshot called name. Names that are not
+// snapshots of the directory, such as ../posadas.db, are rejected.
+func (m *Manager) Path(name string) (string, error) {
+	if _, ok := parseName(name); !ok || filepath.Base(name) != name {
+		return "", fmt.Errorf("invalid snapshot name %q", name)
+	}
+	path := filepath.Join(m.Dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// rotate removes the oldest snapshots beyond Keep
+func (m *Manager) rotate() error {
+	if m.Keep <= 0 {
+		return nil
+	}
+	snapshots, err := m.List()
+	if err != nil {
+		return err
+	}
+	for i := m.Keep; i < len(snapshots); i++ {
+		if err := os.Remove(filepath.Join(m.Dir, snapshots[i].Name)); err != nil {
+			return err
+		}
+		slog.Info("removed old backup", "file", snapshots[i].Name)
+	}
+	return nil
+}
+
+// Schedule creates a snapshot every interval, in the background, until ctx
+// is done. The returned channel is closed once it has stopped, so the
+// database isn't closed under a snapshot in progress.
+func (m *Manager) Schedule(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			snapshot, err := m.Create()
+			if err != nil {
+				slog.ErrorContext(ctx, "scheduled backup", "err", err)
+				continue
+			}
+			slog.InfoContext(ctx, "created backup", "file", snapshot.Name)
+		}
+	}()
+	return done
+}
+
+// parseName returns when the snapshot called name was taken, and whether
+// name is a snapshot name at all
+func parseName(name string) (time.Time, bool) {
+	stamp := strings.TrimSuffix(name, encryptedExt)
+	if !strings.HasPrefix(stamp, snapshotPrefix) || !strings.HasSuffix(stamp, snapshotExt) {
+		return time.Time{}, false
+	}
+	stamp = strings.TrimSuffix(strings.TrimPrefix(stamp, snapshotPrefix), snapshotExt)
+	createdAt, err := time.ParseInLocation(nameLayout, stamp, time.Local)
+	return createdAt, err == nil
+}
@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// An encrypted snapshot is the magic header, the scrypt salt, the AES-GCM
+// nonce and the sealed database file. The header is also the additional
+// data of the seal, so it can't be swapped either.
+var encryptedMagic = []byte("POSADAS-BACKUP-1\n")
+
+const (
+	saltSize = 16
+	keySize  = 32 // AES-256
+)
+
+// ErrPassphrase is returned when an encrypted snapshot can't be opened
+// with the given passphrase, or was modified
+var ErrPassphrase = errors.New("wrong passphrase or damaged backup")
+
+// deriveKey stretches the passphrase into an AES key
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, keySize)
+}
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt seals a database file
+func encrypt(plain []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, encryptedMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plain, encryptedMagic), nil
+}
+
+// decrypt opens what encrypt sealed
+func decrypt(data []byte, passphrase string) ([]byte, error) {
+	if !isEncrypted(data) {
+		return nil, errors.New("not an encrypted backup")
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < saltSize {
+		return nil, ErrPassphrase
+	}
+	salt, data := data[:saltSize], data[saltSize:]
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrPassphrase
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, sealed, encryptedMagic)
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	return plain, nil
+}
+
+// isEncrypted reports whether data starts like an encrypted snapshot
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// encryptFile replaces a database file by its encrypted version
+func encryptFile(path, passphrase string) error {
+	plain, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sealed, err := encrypt(plain, passphrase)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, sealed, 0o600)
+}
@@ -0,0 +1,156 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"posadas-sistema/database"
+)
+
+// Validate checks that a snapshot can be restored: it must open with the
+// passphrase if encrypted, pass SQLite's integrity check, hold the tables
+// of the system and not come from a newer version. It returns the schema
+// version of the snapshot.
+func Validate(path, passphrase string) (int, error) {
+	plain, err := plainCopy(path, passphrase)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(plain)
+	return validatePlain(plain)
+}
+
+// Restore replaces the database at dbPath by a snapshot, after validating
+// it. The current database is first copied next to it; Restore returns the
+// path of that copy, or "" if there was no database yet. The server must
+// be stopped.
+func Restore(dbPath, snapshotPath, passphrase string) (string, error) {
+	plain, err := plainCopy(snapshotPath, passphrase)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(plain)
+	if _, err := validatePlain(plain); err != nil {
+		return "", err
+	}
+
+	target, err := sql.Open("sqlite3", dbPath+"?_busy_timeout=5000")
+	if err != nil {
+		return "", err
+	}
+	defer target.Close()
+
+	var saved string
+	if info, err := os.Stat(dbPath); err == nil && info.Size() > 0 {
+		saved = dbPath + ".before-restore-" + time.Now().Format(nameLayout)
+		if err := Copy(target, saved); err != nil {
+			return "", fmt.Errorf("saving the current database: %w", err)
+		}
+	}
+
+	source, err := sql.Open("sqlite3", plain)
+	if err != nil {
+		return saved, err
+	}
+	defer source.Close()
+
+	ctx := context.Background()
+	targetConn, err := target.Conn(ctx)
+	if err != nil {
+		return saved, err
+	}
+	defer targetConn.Close()
+	sourceConn, err := source.Conn(ctx)
+	if err != nil {
+		return saved, err
+	}
+	defer sourceConn.Close()
+
+	// The backup API writes the pages through SQLite, so the WAL of the
+	// target stays consistent, unlike copying the file over it
+	err = targetConn.Raw(func(targetDriver interface{}) error {
+		return sourceConn.Raw(func(sourceDriver interface{}) error {
+			return copyConn(targetDriver, sourceDriver)
+		})
+	})
+	return saved, err
+}
+
+// plainCopy writes the database of a snapshot to a temporary file,
+// decrypting it if needed, so checking it never touches the snapshot
+func plainCopy(path, passphrase string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if isEncrypted(data) {
+		if passphrase == "" {
+			return "", fmt.Errorf("%s is encrypted: set the backup passphrase", path)
+		}
+		if data, err = decrypt(data, passphrase); err != nil {
+			return "", err
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "posadas-restore-*.db")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// validatePlain checks an unencrypted database file
+func validatePlain(path string) (int, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return 0, fmt.Errorf("not a SQLite database: %w", err)
+	}
+	if result != "ok" {
+		return 0, fmt.Errorf("integrity check failed: %s", result)
+	}
+
+	var tables int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('users', 'registrations', 'events')").Scan(&tables)
+	if err != nil {
+		return 0, err
+	}
+	if tables != 3 {
+		return 0, errors.New("not a database of this system")
+	}
+
+	// Databases from before migrations have no schema_migrations; they are
+	// adopted on the next start
+	var version int
+	err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil && !strings.Contains(err.Error(), "no such table") {
+		return 0, err
+	}
+
+	migrations, err := database.Migrations()
+	if err != nil {
+		return 0, err
+	}
+	if latest := migrations[len(migrations)-1].Version; version > latest {
+		return 0, fmt.Errorf("the backup has migration %d, which this binary doesn't know; restore it with a newer version", version)
+	}
+	return version, nil
+}
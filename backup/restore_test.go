@@ -0,0 +1,97 @@
+package backup
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"posadas-sistema/database"
+)
+
+// openTestDB opens a migrated SQLite database at path as database.DB
+func openTestDB(t *testing.T, path string) {
+	t.Helper()
+	if err := database.Open(database.SQLite, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Migrate(); err != nil {
+		database.DB.Close()
+		t.Fatal(err)
+	}
+}
+
+// usernames returns the usernames stored in the database at path
+func usernames(t *testing.T, path string) []string {
+	t.Helper()
+	openTestDB(t, path)
+	defer database.DB.Close()
+
+	rows, err := database.DB.Query("SELECT username FROM users ORDER BY username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestRestoreEncryptedSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "posadas.db")
+	manager := &Manager{Dir: filepath.Join(dir, "backups"), Passphrase: "villancico"}
+
+	openTestDB(t, dbPath)
+	addUser := func(username string) {
+		t.Helper()
+		if _, err := database.DB.Exec("INSERT INTO users (username, password, is_active) VALUES (?, '', TRUE)", username); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addUser("ana")
+	snapshot, err := manager.Create()
+	if err != nil {
+		database.DB.Close()
+		t.Fatal(err)
+	}
+	addUser("beto")
+	database.DB.Close()
+
+	if !snapshot.Encrypted {
+		t.Fatalf("snapshot %s isn't encrypted", snapshot.Name)
+	}
+	snapshotPath, err := manager.Path(snapshot.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Restore(dbPath, snapshotPath, "pastorela"); !errors.Is(err, ErrPassphrase) {
+		t.Fatalf("restore with the wrong passphrase: got %v, want ErrPassphrase", err)
+	}
+	if got := usernames(t, dbPath); len(got) != 2 {
+		t.Fatalf("a failed restore changed the database: users %v", got)
+	}
+
+	saved, err := Restore(dbPath, snapshotPath, "villancico")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := usernames(t, dbPath); len(got) != 1 || got[0] != "ana" {
+		t.Errorf("restored users %v, want [ana]", got)
+	}
+	if saved == "" {
+		t.Fatal("the database before the restore wasn't saved")
+	}
+	if got := usernames(t, saved); len(got) != 2 {
+		t.Errorf("saved users %v, want [ana beto]", got)
+	}
+}
@@ -0,0 +1,526 @@
+// Package config loads the server settings from defaults, an optional JSON
+// config file, environment variables and command line flags, in that order
+// of precedence (flags win).
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultJWTSecret is the development secret. The server refuses to start
+// in production while it is still in use.
+const DefaultJWTSecret = "mi_clave_secreta_super_larga_y_compleja_para_jwt"
+
+// MinJWTSecretLength is the shortest JWT secret accepted in production
+const MinJWTSecretLength = 32
+
+// publishedSecrets are secrets that appear in this repository, and so are
+// not secret
+var publishedSecrets = []string{
+	DefaultJWTSecret,
+	"cambiar-por-una-clave-larga-y-aleatoria",
+}
+
+// Duration is a time.Duration read from strings such as "24h"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText parses a duration from the config file
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+type Config struct {
+	Env                 string   `json:"env"` // "development" o "production"
+	ListenAddr          string   `json:"listen_addr"`
+	TLSCertFile         string   `json:"tls_cert_file"` // with tls_key_file, serves HTTPS
+	TLSKeyFile          string   `json:"tls_key_file"`
+	DBDriver            string   `json:"db_driver"` // "sqlite" o "postgres"
+	DBPath              string   `json:"db_path"`
+	DBURL               string   `json:"db_url"` // PostgreSQL connection string
+	JWTSecret           string   `json:"jwt_secret"`
+	CookieSecure        bool     `json:"cookie_secure"` // required in production, implied by TLS
+	CookieDomain        string   `json:"cookie_domain"`
+	SessionTTL          Duration `json:"session_ttl"`
+	Timezone            string   `json:"timezone"`
+	TemplateDir         string   `json:"template_dir"` // empty = the embedded copy
+	StaticDir           string   `json:"static_dir"`   // empty = the embedded copy
+	SeasonYear          int      `json:"season_year"`  // 0 = año en curso
+	AttendanceThreshold int      `json:"attendance_threshold"`
+	PasswordMinLength   int      `json:"password_min_length"`
+	PasswordCheckCommon bool     `json:"password_check_common"`
+
+	// BaseURL is the public address used in emailed links, e.g.
+	// https://posadas.example.org. It is required by the smtp mail driver;
+	// without it links point at localhost and the listen port.
+	BaseURL      string `json:"base_url"`
+	MailDriver   string `json:"mail_driver"` // "log", "file" o "smtp"
+	MailDir      string `json:"mail_dir"`    // for the file driver
+	MailFrom     string `json:"mail_from"`
+	SMTPHost     string `json:"smtp_host"`
+	SMTPPort     int    `json:"smtp_port"`
+	SMTPUsername string `json:"smtp_username"`
+	SMTPPassword string `json:"smtp_password"`
+
+	// Snapshots of the SQLite database are written to BackupDir every
+	// BackupInterval (0 = only on demand), keeping the newest BackupKeep
+	BackupDir        string   `json:"backup_dir"`
+	BackupInterval   Duration `json:"backup_interval"`
+	BackupKeep       int      `json:"backup_keep"`
+	BackupPassphrase string   `json:"backup_passphrase"` // encrypts the snapshots if set
+
+	LogFormat string `json:"log_format"` // "text" o "json"
+	LogLevel  string `json:"log_level"`  // "debug", "info", "warn" o "error"
+
+	// MetricsToken, if set, must be sent as a bearer token to read /metrics.
+	// It is required in production.
+	MetricsToken string `json:"metrics_token"`
+
+	// HTTP server limits. ShutdownTimeout is how long a stopping server
+	// waits for the requests in progress.
+	ReadTimeout     Duration `json:"read_timeout"`
+	WriteTimeout    Duration `json:"write_timeout"`
+	IdleTimeout     Duration `json:"idle_timeout"`
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	MaxHeaderBytes  int      `json:"max_header_bytes"`
+
+	// Location is the loaded Timezone
+	Location *time.Location `json:"-"`
+}
+
+// Default returns the settings used when nothing else is configured
+func Default() *Config {
+	return &Config{
+		Env:                 "development",
+		ListenAddr:          ":8080",
+		DBDriver:            "sqlite",
+		DBPath:              "./posadas.db",
+		JWTSecret:           DefaultJWTSecret,
+		SessionTTL:          Duration{24 * time.Hour},
+		Timezone:            "Local",
+		AttendanceThreshold: 75,
+		PasswordMinLength:   10,
+		PasswordCheckCommon: true,
+		MailDriver:          "log",
+		LogFormat:           "text",
+		LogLevel:            "info",
+		MailDir:             "mail",
+		MailFrom:            "Posadas <no-reply@localhost>",
+		SMTPPort:            587,
+		BackupDir:           "backups",
+		BackupInterval:      Duration{24 * time.Hour},
+		BackupKeep:          7,
+		ReadTimeout:         Duration{15 * time.Second},
+		WriteTimeout:        Duration{60 * time.Second},
+		IdleTimeout:         Duration{2 * time.Minute},
+		ShutdownTimeout:     Duration{30 * time.Second},
+		MaxHeaderBytes:      64 << 10,
+	}
+}
+
+// IsProduction reports whether the server runs in production mode
+func (c *Config) IsProduction() bool {
+	return c.Env == "production"
+}
+
+// DBSource returns the path or connection string of the configured database
+func (c *Config) DBSource() string {
+	if c.DBDriver == "postgres" {
+		return c.DBURL
+	}
+	return c.DBPath
+}
+
+// TLS reports whether the server serves HTTPS itself
+func (c *Config) TLS() bool {
+	return c.TLSCertFile != ""
+}
+
+// localURL is the address of the server as seen from the machine it runs on
+func (c *Config) localURL() string {
+	scheme := "http"
+	if c.TLS() {
+		scheme = "https"
+	}
+	host, port, err := net.SplitHostPort(c.ListenAddr)
+	if err != nil {
+		return scheme + "://localhost"
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// Season returns the default season year
+func (c *Config) Season() int {
+	if c.SeasonYear != 0 {
+		return c.SeasonYear
+	}
+	return time.Now().In(c.Location).Year()
+}
+
+// Load builds the configuration from the command line arguments (without the
+// program name), the environment and the config file named by -config or
+// POSADAS_CONFIG.
+func Load(args []string) (*Config, error) {
+	return LoadFlags(flag.NewFlagSet("posadas", flag.ContinueOnError), args)
+}
+
+// LoadFlags is Load with a flag set that may already define flags of its
+// own, for commands that take extra options.
+func LoadFlags(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := Default()
+	flags := Default()
+
+	configPath := fs.String("config", os.Getenv("POSADAS_CONFIG"), "path to a JSON config file")
+	fs.StringVar(&flags.Env, "env", flags.Env, "environment: development or production")
+	fs.StringVar(&flags.ListenAddr, "addr", flags.ListenAddr, "HTTP listen address")
+	fs.StringVar(&flags.TLSCertFile, "tls-cert", flags.TLSCertFile, "TLS certificate file (PEM); serves HTTPS together with -tls-key")
+	fs.StringVar(&flags.TLSKeyFile, "tls-key", flags.TLSKeyFile, "TLS private key file (PEM)")
+	fs.StringVar(&flags.DBDriver, "db-driver", flags.DBDriver, "database driver: sqlite or postgres")
+	fs.StringVar(&flags.DBPath, "db", flags.DBPath, "SQLite database path")
+	fs.StringVar(&flags.DBURL, "db-url", flags.DBURL, "PostgreSQL connection string")
+	fs.StringVar(&flags.JWTSecret, "jwt-secret", flags.JWTSecret, "secret used to sign session tokens")
+	fs.BoolVar(&flags.CookieSecure, "cookie-secure", flags.CookieSecure, "only send the session cookie over HTTPS")
+	fs.StringVar(&flags.CookieDomain, "cookie-domain", flags.CookieDomain, "domain of the session cookie")
+	fs.DurationVar(&flags.SessionTTL.Duration, "session-ttl", flags.SessionTTL.Duration, "how long a login lasts")
+	fs.StringVar(&flags.Timezone, "timezone", flags.Timezone, "IANA timezone, e.g. America/Lima")
+	fs.StringVar(&flags.TemplateDir, "templates", flags.TemplateDir, "templates directory (the embedded templates if empty; reloaded on every request in development)")
+	fs.StringVar(&flags.StaticDir, "static", flags.StaticDir, "static files directory (the embedded files if empty)")
+	fs.IntVar(&flags.SeasonYear, "season", flags.SeasonYear, "default season year (0 = current year)")
+	fs.IntVar(&flags.AttendanceThreshold, "attendance-threshold", flags.AttendanceThreshold, "minimum rehearsal attendance (%) expected from cast members")
+	fs.IntVar(&flags.PasswordMinLength, "password-min-length", flags.PasswordMinLength, "minimum password length")
+	fs.BoolVar(&flags.PasswordCheckCommon, "password-check-common", flags.PasswordCheckCommon, "reject passwords found in the common password list")
+	fs.StringVar(&flags.BaseURL, "base-url", flags.BaseURL, "public URL used in emailed links")
+	fs.StringVar(&flags.LogFormat, "log-format", flags.LogFormat, "log format: text or json")
+	fs.StringVar(&flags.LogLevel, "log-level", flags.LogLevel, "minimum log level: debug, info, warn or error")
+	fs.StringVar(&flags.MailDriver, "mail-driver", flags.MailDriver, "how emails are sent: log, file or smtp")
+	fs.StringVar(&flags.MailDir, "mail-dir", flags.MailDir, "directory the file mail driver writes to")
+	fs.StringVar(&flags.MailFrom, "mail-from", flags.MailFrom, "sender address of emails")
+	fs.StringVar(&flags.SMTPHost, "smtp-host", flags.SMTPHost, "SMTP server host")
+	fs.IntVar(&flags.SMTPPort, "smtp-port", flags.SMTPPort, "SMTP server port")
+	fs.StringVar(&flags.SMTPUsername, "smtp-username", flags.SMTPUsername, "SMTP username (the password comes from POSADAS_SMTP_PASSWORD)")
+	fs.StringVar(&flags.BackupDir, "backup-dir", flags.BackupDir, "directory of the database snapshots")
+	fs.DurationVar(&flags.BackupInterval.Duration, "backup-interval", flags.BackupInterval.Duration, "how often a snapshot is taken (0 = only on demand; the passphrase comes from POSADAS_BACKUP_PASSPHRASE)")
+	fs.IntVar(&flags.BackupKeep, "backup-keep", flags.BackupKeep, "number of snapshots kept (0 = all)")
+	fs.DurationVar(&flags.ReadTimeout.Duration, "read-timeout", flags.ReadTimeout.Duration, "maximum time to read a request")
+	fs.DurationVar(&flags.WriteTimeout.Duration, "write-timeout", flags.WriteTimeout.Duration, "maximum time to write a response")
+	fs.DurationVar(&flags.IdleTimeout.Duration, "idle-timeout", flags.IdleTimeout.Duration, "how long an idle keep-alive connection stays open")
+	fs.DurationVar(&flags.ShutdownTimeout.Duration, "shutdown-timeout", flags.ShutdownTimeout.Duration, "how long a stopping server waits for requests in progress")
+	fs.IntVar(&flags.MaxHeaderBytes, "max-header-bytes", flags.MaxHeaderBytes, "maximum size of the request headers")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	// Only the flags given explicitly override the file and environment
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "env":
+			cfg.Env = flags.Env
+		case "addr":
+			cfg.ListenAddr = flags.ListenAddr
+		case "tls-cert":
+			cfg.TLSCertFile = flags.TLSCertFile
+		case "tls-key":
+			cfg.TLSKeyFile = flags.TLSKeyFile
+		case "db-driver":
+			cfg.DBDriver = flags.DBDriver
+		case "db":
+			cfg.DBPath = flags.DBPath
+		case "db-url":
+			cfg.DBURL = flags.DBURL
+		case "jwt-secret":
+			cfg.JWTSecret = flags.JWTSecret
+		case "cookie-secure":
+			cfg.CookieSecure = flags.CookieSecure
+		case "cookie-domain":
+			cfg.CookieDomain = flags.CookieDomain
+		case "session-ttl":
+			cfg.SessionTTL = flags.SessionTTL
+		case "timezone":
+			cfg.Timezone = flags.Timezone
+		case "templates":
+			cfg.TemplateDir = flags.TemplateDir
+		case "static":
+			cfg.StaticDir = flags.StaticDir
+		case "season":
+			cfg.SeasonYear = flags.SeasonYear
+		case "attendance-threshold":
+			cfg.AttendanceThreshold = flags.AttendanceThreshold
+		case "password-min-length":
+			cfg.PasswordMinLength = flags.PasswordMinLength
+		case "password-check-common":
+			cfg.PasswordCheckCommon = flags.PasswordCheckCommon
+		case "base-url":
+			cfg.BaseURL = flags.BaseURL
+		case "log-format":
+			cfg.LogFormat = flags.LogFormat
+		case "log-level":
+			cfg.LogLevel = flags.LogLevel
+		case "mail-driver":
+			cfg.MailDriver = flags.MailDriver
+		case "mail-dir":
+			cfg.MailDir = flags.MailDir
+		case "mail-from":
+			cfg.MailFrom = flags.MailFrom
+		case "smtp-host":
+			cfg.SMTPHost = flags.SMTPHost
+		case "smtp-port":
+			cfg.SMTPPort = flags.SMTPPort
+		case "smtp-username":
+			cfg.SMTPUsername = flags.SMTPUsername
+		case "backup-dir":
+			cfg.BackupDir = flags.BackupDir
+		case "backup-interval":
+			cfg.BackupInterval = flags.BackupInterval
+		case "backup-keep":
+			cfg.BackupKeep = flags.BackupKeep
+		case "read-timeout":
+			cfg.ReadTimeout = flags.ReadTimeout
+		case "write-timeout":
+			cfg.WriteTimeout = flags.WriteTimeout
+		case "idle-timeout":
+			cfg.IdleTimeout = flags.IdleTimeout
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = flags.ShutdownTimeout
+		case "max-header-bytes":
+			cfg.MaxHeaderBytes = flags.MaxHeaderBytes
+		}
+	})
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile reads settings from a JSON file. Missing keys keep their value.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv reads settings from POSADAS_* environment variables
+func (c *Config) loadEnv() error {
+	texts := map[string]*string{
+		"POSADAS_ENV":               &c.Env,
+		"POSADAS_ADDR":              &c.ListenAddr,
+		"POSADAS_TLS_CERT":          &c.TLSCertFile,
+		"POSADAS_TLS_KEY":           &c.TLSKeyFile,
+		"POSADAS_DB_DRIVER":         &c.DBDriver,
+		"POSADAS_DB":                &c.DBPath,
+		"POSADAS_DB_URL":            &c.DBURL,
+		"POSADAS_JWT_SECRET":        &c.JWTSecret,
+		"POSADAS_COOKIE_DOMAIN":     &c.CookieDomain,
+		"POSADAS_TIMEZONE":          &c.Timezone,
+		"POSADAS_TEMPLATES":         &c.TemplateDir,
+		"POSADAS_STATIC":            &c.StaticDir,
+		"POSADAS_BASE_URL":          &c.BaseURL,
+		"POSADAS_MAIL_DRIVER":       &c.MailDriver,
+		"POSADAS_LOG_FORMAT":        &c.LogFormat,
+		"POSADAS_LOG_LEVEL":         &c.LogLevel,
+		"POSADAS_MAIL_DIR":          &c.MailDir,
+		"POSADAS_MAIL_FROM":         &c.MailFrom,
+		"POSADAS_SMTP_HOST":         &c.SMTPHost,
+		"POSADAS_SMTP_USERNAME":     &c.SMTPUsername,
+		"POSADAS_SMTP_PASSWORD":     &c.SMTPPassword,
+		"POSADAS_BACKUP_DIR":        &c.BackupDir,
+		"POSADAS_BACKUP_PASSPHRASE": &c.BackupPassphrase,
+		"POSADAS_METRICS_TOKEN":     &c.MetricsToken,
+	}
+	for name, field := range texts {
+		if value, ok := os.LookupEnv(name); ok {
+			*field = value
+		}
+	}
+
+	ints := map[string]*int{
+		"POSADAS_SEASON":               &c.SeasonYear,
+		"POSADAS_ATTENDANCE_THRESHOLD": &c.AttendanceThreshold,
+		"POSADAS_PASSWORD_MIN_LENGTH":  &c.PasswordMinLength,
+		"POSADAS_SMTP_PORT":            &c.SMTPPort,
+		"POSADAS_BACKUP_KEEP":          &c.BackupKeep,
+		"POSADAS_MAX_HEADER_BYTES":     &c.MaxHeaderBytes,
+	}
+	for name, field := range ints {
+		if value, ok := os.LookupEnv(name); ok {
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*field = v
+		}
+	}
+
+	bools := map[string]*bool{
+		"POSADAS_COOKIE_SECURE":         &c.CookieSecure,
+		"POSADAS_PASSWORD_CHECK_COMMON": &c.PasswordCheckCommon,
+	}
+	for name, field := range bools {
+		if value, ok := os.LookupEnv(name); ok {
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*field = v
+		}
+	}
+
+	durations := map[string]*Duration{
+		"POSADAS_SESSION_TTL":      &c.SessionTTL,
+		"POSADAS_BACKUP_INTERVAL":  &c.BackupInterval,
+		"POSADAS_READ_TIMEOUT":     &c.ReadTimeout,
+		"POSADAS_WRITE_TIMEOUT":    &c.WriteTimeout,
+		"POSADAS_IDLE_TIMEOUT":     &c.IdleTimeout,
+		"POSADAS_SHUTDOWN_TIMEOUT": &c.ShutdownTimeout,
+	}
+	for name, field := range durations {
+		if value, ok := os.LookupEnv(name); ok {
+			if err := field.UnmarshalText([]byte(value)); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks the settings and loads the timezone
+func (c *Config) validate() error {
+	if c.Env != "development" && c.Env != "production" {
+		return fmt.Errorf("unknown env %q (use development or production)", c.Env)
+	}
+	if c.IsProduction() {
+		if c.JWTSecret == "" || slices.Contains(publishedSecrets, c.JWTSecret) {
+			return errors.New("refusing to start in production with the default JWT secret: set POSADAS_JWT_SECRET or -jwt-secret")
+		}
+		if len(c.JWTSecret) < MinJWTSecretLength {
+			return fmt.Errorf("the JWT secret must be at least %d bytes long in production", MinJWTSecretLength)
+		}
+		if !c.CookieSecure {
+			return errors.New("refusing to start in production without cookie_secure: set POSADAS_COOKIE_SECURE=true or -cookie-secure")
+		}
+		if c.MetricsToken == "" {
+			return errors.New("refusing to start in production without metrics_token: set POSADAS_METRICS_TOKEN")
+		}
+	}
+	if c.JWTSecret == "" {
+		return errors.New("the JWT secret cannot be empty")
+	}
+	if c.SessionTTL.Duration <= 0 {
+		return errors.New("session_ttl must be positive")
+	}
+	if c.AttendanceThreshold < 0 || c.AttendanceThreshold > 100 {
+		return errors.New("attendance_threshold must be between 0 and 100")
+	}
+	if c.PasswordMinLength < 1 {
+		return errors.New("password_min_length must be at least 1")
+	}
+
+	switch c.DBDriver {
+	case "sqlite":
+		if c.DBPath == "" {
+			return errors.New("the sqlite driver needs db_path")
+		}
+	case "postgres":
+		if c.DBURL == "" {
+			return errors.New("the postgres driver needs db_url")
+		}
+	default:
+		return fmt.Errorf("unknown db_driver %q (use sqlite or postgres)", c.DBDriver)
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("tls_cert_file and tls_key_file must be set together")
+	}
+	// A server that only speaks HTTPS never needs cookies sent over HTTP
+	if c.TLS() {
+		c.CookieSecure = true
+	}
+	for name, d := range map[string]Duration{"read_timeout": c.ReadTimeout, "write_timeout": c.WriteTimeout, "idle_timeout": c.IdleTimeout, "shutdown_timeout": c.ShutdownTimeout} {
+		if d.Duration <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+	if c.MaxHeaderBytes < 1024 {
+		return errors.New("max_header_bytes must be at least 1024")
+	}
+
+	if c.BackupInterval.Duration < 0 || c.BackupKeep < 0 {
+		return errors.New("backup_interval and backup_keep cannot be negative")
+	}
+	if c.BackupDir == "" {
+		return errors.New("backup_dir cannot be empty")
+	}
+
+	c.BaseURL = strings.TrimRight(c.BaseURL, "/")
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		return fmt.Errorf("unknown log_format %q (use text or json)", c.LogFormat)
+	}
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("unknown log_level %q (use debug, info, warn or error)", c.LogLevel)
+	}
+
+	switch c.MailDriver {
+	case "log":
+	case "file":
+		if c.MailDir == "" {
+			return errors.New("the file mail driver needs mail_dir")
+		}
+	case "smtp":
+		if c.SMTPHost == "" {
+			return errors.New("the smtp mail driver needs smtp_host")
+		}
+		if c.BaseURL == "" {
+			return errors.New("the smtp mail driver needs base_url")
+		}
+	default:
+		return fmt.Errorf("unknown mail_driver %q (use log, file or smtp)", c.MailDriver)
+	}
+
+	// Links are never built from the Host header of a request, which the
+	// client chooses
+	if c.BaseURL == "" {
+		c.BaseURL = c.localURL()
+	}
+
+	location, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return fmt.Errorf("timezone: %w", err)
+	}
+	c.Location = location
+	return nil
+}
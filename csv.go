@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"posadas-sistema/config"
+	"posadas-sistema/handlers"
+	"posadas-sistema/models"
+)
+
+// importCSVCommand implements "import-csv": it adds the registrations of a
+// CSV file with a header row. The columns are those of "export
+// registrations"; name, age and dni are required, year defaults to the
+// season. Rows whose DNI is already registered for the year are skipped,
+// so a file can be imported again after fixing it.
+//
+//	server import-csv [-dry-run] FILE
+func importCSVCommand(args []string) {
+	fs := flag.NewFlagSet("import-csv", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only check the file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: server import-csv FILE [-dry-run] [config flags]")
+		fmt.Fprintln(fs.Output(), "Columns: name, age, dni, guardian_name, guardian_contact, year")
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	file := args[0]
+
+	cfg, err := config.LoadFlags(fs, args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	registrations, err := readRegistrations(f, cfg.Season())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app := openApp(cfg)
+	existing, err := app.Registrations.List()
+	if err != nil {
+		log.Fatal(err)
+	}
+	registered := map[string]bool{}
+	for _, reg := range existing {
+		registered[fmt.Sprint(reg.DNI, "/", reg.Year)] = true
+	}
+
+	imported, skipped := 0, 0
+	for _, reg := range registrations {
+		key := fmt.Sprint(reg.DNI, "/", reg.Year)
+		if registered[key] {
+			skipped++
+			continue
+		}
+		registered[key] = true
+		if !*dryRun {
+			if err := app.Registrations.Create(&reg); err != nil {
+				log.Fatal(err)
+			}
+		}
+		imported++
+	}
+
+	if *dryRun {
+		fmt.Printf("%s is valid: %d registrations would be imported, %d are already registered.\n", file, imported, skipped)
+		return
+	}
+	fmt.Printf("Imported %d registrations, skipped %d already registered.\n", imported, skipped)
+}
+
+// readRegistrations parses and checks every row before anything is saved,
+// so a bad row doesn't leave the file half imported
+func readRegistrations(r io.Reader, season int) ([]models.Registration, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading the header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"name", "age", "dni"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("the file has no %q column", name)
+		}
+	}
+
+	var registrations []models.Registration
+	var problems []string
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		reg := models.Registration{
+			Name:            value("name"),
+			DNI:             value("dni"),
+			GuardianName:    value("guardian_name"),
+			GuardianContact: value("guardian_contact"),
+			Year:            season,
+		}
+		if reg.Name == "" || reg.DNI == "" {
+			problems = append(problems, fmt.Sprintf("line %d: name and dni are required", line))
+			continue
+		}
+		if reg.Age, err = strconv.Atoi(value("age")); err != nil || reg.Age < 0 {
+			problems = append(problems, fmt.Sprintf("line %d: invalid age %q", line, value("age")))
+			continue
+		}
+		if year := value("year"); year != "" {
+			if reg.Year, err = strconv.Atoi(year); err != nil {
+				problems = append(problems, fmt.Sprintf("line %d: invalid year %q", line, year))
+				continue
+			}
+		}
+		registrations = append(registrations, reg)
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("nothing was imported:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return registrations, nil
+}
+
+// table is exported data, written as CSV or as JSON objects
+type table struct {
+	columns []string
+	rows    [][]interface{}
+}
+
+// exportCommand implements "export".
+//
+//	server export registrations|events|attendance|users [-format csv|json] [-o FILE] [-year N]
+func exportCommand(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "csv", "csv or json")
+	output := fs.String("o", "", "file to write (standard output if empty)")
+	year := fs.Int("year", 0, "only registrations, events and attendance of this season (0 = all)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: server export registrations|events|attendance|users [-format csv|json] [-o FILE] [-year N] [config flags]")
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	what := args[0]
+
+	cfg, err := config.LoadFlags(fs, args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *format != "csv" && *format != "json" {
+		log.Fatalf("unknown format %q (use csv or json)", *format)
+	}
+	app := openApp(cfg)
+
+	var data table
+	switch what {
+	case "registrations":
+		data, err = exportRegistrations(app, *year)
+	case "events":
+		data, err = exportEvents(app, *year)
+	case "attendance":
+		data, err = exportAttendance(app, *year)
+	case "users":
+		data, err = exportUsers(app)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			log.Fatal(err)
+		}
+		defer out.Close()
+	}
+	if *format == "json" {
+		err = data.writeJSON(out)
+	} else {
+		err = data.writeCSV(out)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d %s to %s.\n", len(data.rows), what, *output)
+	}
+}
+
+func exportRegistrations(app *handlers.App, year int) (table, error) {
+	registrations, err := app.Registrations.ListByName(0)
+	if err != nil {
+		return table{}, err
+	}
+	data := table{columns: []string{"id", "name", "age", "dni", "guardian_name", "guardian_contact", "year", "created_at"}}
+	for _, reg := range registrations {
+		if year != 0 && reg.Year != year {
+			continue
+		}
+		data.rows = append(data.rows, []interface{}{reg.ID, reg.Name, reg.Age, reg.DNI, reg.GuardianName, reg.GuardianContact, reg.Year, reg.CreatedAt})
+	}
+	return data, nil
+}
+
+func exportEvents(app *handlers.App, year int) (table, error) {
+	events, err := app.Events.List()
+	if err != nil {
+		return table{}, err
+	}
+	data := table{columns: []string{"id", "name", "type", "date", "time", "location", "description"}}
+	for _, event := range events {
+		if year != 0 && event.Date.Year() != year {
+			continue
+		}
+		data.rows = append(data.rows, []interface{}{event.ID, event.Name, event.Type, event.Date.Format("2006-01-02"), event.Time, event.Location, event.Description})
+	}
+	return data, nil
+}
+
+func exportAttendance(app *handlers.App, year int) (table, error) {
+	events, err := app.Events.List()
+	if err != nil {
+		return table{}, err
+	}
+	registrations, err := app.Registrations.List()
+	if err != nil {
+		return table{}, err
+	}
+	data := table{columns: []string{"event_id", "event", "date", "registration_id", "name", "present"}}
+	for _, event := range events {
+		if year != 0 && event.Date.Year() != year {
+			continue
+		}
+		marks, err := app.Attendance.ForEvent(event.ID)
+		if err != nil {
+			return table{}, err
+		}
+		for _, reg := range registrations {
+			if present, ok := marks[reg.ID]; ok {
+				data.rows = append(data.rows, []interface{}{event.ID, event.Name, event.Date.Format("2006-01-02"), reg.ID, reg.Name, present})
+			}
+		}
+	}
+	return data, nil
+}
+
+// exportUsers leaves out the passwords and 2FA secrets
+func exportUsers(app *handlers.App) (table, error) {
+	users, err := app.Users.List()
+	if err != nil {
+		return table{}, err
+	}
+	data := table{columns: []string{"id", "username", "email", "role", "is_active", "totp_enabled"}}
+	for _, user := range users {
+		data.rows = append(data.rows, []interface{}{user.ID, user.Username, user.Email, user.Role, user.IsActive, user.TOTPEnabled})
+	}
+	return data, nil
+}
+
+func (t table) writeCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write(t.columns)
+	for _, row := range t.rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			if at, ok := value.(time.Time); ok {
+				record[i] = at.Format(time.RFC3339)
+			} else {
+				record[i] = fmt.Sprint(value)
+			}
+		}
+		out.Write(record)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func (t table) writeJSON(w io.Writer) error {
+	objects := make([]map[string]interface{}, 0, len(t.rows))
+	for _, row := range t.rows {
+		object := map[string]interface{}{}
+		for i, value := range row {
+			object[t.columns[i]] = value
+		}
+		objects = append(objects, object)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(objects)
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"time"
+
+	"posadas-sistema/metrics"
+)
+
+var queryDuration = metrics.NewHistogram("posadas_db_query_duration_seconds",
+	"Time taken by database queries, by statement kind.", metrics.DefaultBuckets, "op")
+
+// dsnConnector opens connections of a driver that has no connector of its
+// own
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// timedConnector wraps the connections of a driver to time their queries,
+// rewriting each query first if rewrite is set
+type timedConnector struct {
+	driver.Connector
+	rewrite func(string) string
+}
+
+func (c timedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return timedConn{conn, c.rewrite}, nil
+}
+
+// timedConn passes everything to the driver's connection
+type timedConn struct {
+	driver.Conn
+	rewrite func(string) string
+}
+
+// Unwrap returns the driver's connection, for code that needs the driver's
+// own methods through sql.Conn.Raw
+func (c timedConn) Unwrap() driver.Conn {
+	return c.Conn
+}
+
+func (c timedConn) query(query string) string {
+	if c.rewrite == nil {
+		return query
+	}
+	return c.rewrite(query)
+}
+
+// observe records how long a query took since start
+func observe(query string, start time.Time) {
+	queryDuration.Observe(time.Since(start).Seconds(), queryKind(query))
+}
+
+// queryKind returns the first keyword of query, for the op label
+func queryKind(query string) string {
+	word, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	switch word = strings.ToLower(strings.TrimSpace(word)); word {
+	case "select", "insert", "update", "delete", "with":
+		return word
+	}
+	return "other"
+}
+
+func (c timedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(c.query(query))
+}
+
+func (c timedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, c.query(query))
+}
+
+func (c timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	defer observe(query, time.Now())
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, c.query(query), args)
+}
+
+func (c timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	defer observe(query, time.Now())
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, c.query(query), args)
+}
+
+func (c timedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c timedConn) Ping(ctx context.Context) error {
+	return c.Conn.(driver.Pinger).Ping(ctx)
+}
+
+// ResetSession and IsValid are optional; the SQLite driver has neither
+func (c timedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c timedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
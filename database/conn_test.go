@@ -0,0 +1,163 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+
+	"posadas-sistema/metrics"
+)
+
+// queryCount returns how many queries of kind op were timed so far
+func queryCount(t *testing.T, op string) int {
+	t.Helper()
+	var out bytes.Buffer
+	if err := metrics.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	prefix := `posadas_db_query_duration_seconds_count{op="` + op + `"} `
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), prefix); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return n
+		}
+	}
+	return 0
+}
+
+// openTimed opens a SQLite database through timedConnector with rewrite
+func openTimed(t *testing.T, rewrite func(string) string) *sql.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "timed.db")
+	db := sql.OpenDB(timedConnector{Connector: dsnConnector{dsn, &sqlite3.SQLiteDriver{}}, rewrite: rewrite})
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestTimedConnectorRewritesQueries(t *testing.T) {
+	var seen []string
+	db := openTimed(t, func(query string) string {
+		seen = append(seen, query)
+		return strings.ReplaceAll(query, "$answer", "42")
+	})
+
+	if _, err := db.Exec("CREATE TABLE answers (value INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO answers (value) VALUES ($answer)"); err != nil {
+		t.Fatal(err)
+	}
+	var value int
+	if err := db.QueryRow("SELECT value FROM answers WHERE value = $answer").Scan(&value); err != nil {
+		t.Fatal(err)
+	}
+	if value != 42 {
+		t.Errorf("got %d, want 42", value)
+	}
+
+	stmt, err := db.Prepare("SELECT $answer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if err := stmt.QueryRow().Scan(&value); err != nil {
+		t.Fatal(err)
+	}
+	if value != 42 {
+		t.Errorf("prepared: got %d, want 42", value)
+	}
+
+	if len(seen) < 4 {
+		t.Errorf("rewrite saw %d queries, want at least 4", len(seen))
+	}
+}
+
+func TestTimedConnectorObservesQueries(t *testing.T) {
+	db := openTimed(t, nil)
+	if _, err := db.Exec("CREATE TABLE t (v INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+
+	selects, inserts := queryCount(t, "select"), queryCount(t, "insert")
+	for i := 0; i < 3; i++ {
+		if _, err := db.Exec("INSERT INTO t (v) VALUES (?)", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM t").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	// Queries in a transaction go through the same connection
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO t (v) VALUES (3)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := queryCount(t, "insert") - inserts; got != 4 {
+		t.Errorf("observed %d inserts, want 4", got)
+	}
+	if got := queryCount(t, "select") - selects; got != 1 {
+		t.Errorf("observed %d selects, want 1", got)
+	}
+}
+
+func TestTimedConnUnwrap(t *testing.T) {
+	db := openTimed(t, nil)
+	conn, err := db.Conn(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	err = conn.Raw(func(driverConn interface{}) error {
+		wrapped, ok := driverConn.(interface{ Unwrap() driver.Conn })
+		if !ok {
+			t.Fatalf("%T has no Unwrap", driverConn)
+		}
+		if _, ok := wrapped.Unwrap().(*sqlite3.SQLiteConn); !ok {
+			t.Errorf("Unwrap returned %T, want *sqlite3.SQLiteConn", wrapped.Unwrap())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryKind(t *testing.T) {
+	tests := map[string]string{
+		"SELECT 1":                             "select",
+		"  select * from t":                    "select",
+		"\n\t\tINSERT INTO t VALUES (1)":       "insert",
+		"UPDATE t SET v = 1":                   "update",
+		"DELETE FROM t":                        "delete",
+		"WITH x AS (SELECT 1) SELECT * FROM x": "with",
+		"CREATE TABLE t (v INTEGER)":           "other",
+		"PRAGMA foreign_keys":                  "other",
+		"":                                     "other",
+	}
+	for query, want := range tests {
+		if got := queryKind(query); got != want {
+			t.Errorf("queryKind(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
@@ -2,91 +2,86 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
+	"strings"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var DB *sql.DB
 
-func InitDB() {
+// Connection settings, applied by the driver to every new connection.
+// Foreign keys make the ON DELETE clauses of the schema work; WAL lets pages
+// be read while a write is in progress; the busy timeout makes a writer
+// wait for the lock instead of failing right away; and immediate
+// transactions take the write lock up front, so two transactions can't
+// deadlock upgrading their read locks.
+const connectionParams = "_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL&_txlock=immediate"
+
+// Open opens the database without touching its schema. source is the file
+// path for SQLite and the connection string for PostgreSQL.
+func Open(driver, source string) error {
 	var err error
-	DB, err = sql.Open("sqlite3", "./posadas.db")
+	switch driver {
+	case SQLite:
+		dsn := source
+		if strings.Contains(dsn, "?") {
+			dsn += "&" + connectionParams
+		} else {
+			dsn += "?" + connectionParams
+		}
+		DB = sql.OpenDB(timedConnector{Connector: dsnConnector{dsn, &sqlite3.SQLiteDriver{}}})
+	case Postgres:
+		DB, err = openPostgres(source)
+	default:
+		return fmt.Errorf("unknown database driver %q", driver)
+	}
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-
-	createTables()
+	Driver = driver
+	return DB.Ping()
 }
 
-func createTables() {
-	createRegistrationsTable := `
-	CREATE TABLE IF NOT EXISTS registrations (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT NOT NULL,
-		age INTEGER NOT NULL,
-		dni TEXT NOT NULL,
-		guardian_name TEXT,
-		guardian_contact TEXT,
-		year INTEGER NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	createUsersTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT NOT NULL UNIQUE,
-		password TEXT NOT NULL,
-		is_active BOOLEAN NOT NULL
-	);`
-
-	createEventsTable := `
-	CREATE TABLE IF NOT EXISTS events (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT NOT NULL,
-		type TEXT NOT NULL CHECK(type IN ('ensayo', 'salida')),
-		date DATE NOT NULL,
-		time TEXT NOT NULL,
-		location TEXT NOT NULL,
-		description TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	createAttendanceTable := `
-	CREATE TABLE IF NOT EXISTS attendance (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		event_id INTEGER NOT NULL,
-		registration_id INTEGER NOT NULL,
-		present BOOLEAN NOT NULL DEFAULT 0,
-		notes TEXT,
-		marked_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY(event_id) REFERENCES events(id) ON DELETE CASCADE,
-		FOREIGN KEY(registration_id) REFERENCES registrations(id) ON DELETE CASCADE,
-		UNIQUE(event_id, registration_id)
-	);`
-
-	_, err := DB.Exec(createRegistrationsTable)
-	if err != nil {
+// InitDB opens the database, applies any pending migrations and seeds the
+// first admin
+func InitDB(driver, source string) {
+	if err := Open(driver, source); err != nil {
 		log.Fatal(err)
 	}
-
-	_, err = DB.Exec(createUsersTable)
-	if err != nil {
+	if err := Migrate(); err != nil {
 		log.Fatal(err)
 	}
+	// PostgreSQL always enforced the foreign keys
+	if !IsPostgres() {
+		repairOrphansOnce()
+	}
+
+	seedAdmin()
+}
 
-	_, err = DB.Exec(createEventsTable)
+// repairOrphansOnce fixes, on the first start with foreign keys enforced,
+// the orphan rows older versions left behind. Later deletes cascade, so it
+// doesn't need to run again; admins can still check from the panel.
+func repairOrphansOnce() {
+	var done int
+	err := DB.QueryRow("SELECT COUNT(*) FROM app_settings WHERE key = 'orphans_repaired'").Scan(&done)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if done > 0 {
+		return
+	}
 
-	_, err = DB.Exec(createAttendanceTable)
+	if _, err := RepairOrphans(); err != nil {
+		log.Fatal(err)
+	}
+	_, err = DB.Exec("INSERT INTO app_settings (key, value) VALUES ('orphans_repaired', '1')")
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	seedAdmin()
 }
 
 func seedAdmin() {
@@ -104,11 +99,12 @@ func seedAdmin() {
 			return
 		}
 
-		_, err = DB.Exec("INSERT INTO users (username, password, is_active) VALUES (?, ?, ?)", "admin", hashedPassword, true)
+		// The default password is public, so it has to be changed on first login
+		_, err = DB.Exec("INSERT INTO users (username, password, is_active, role, must_change_password) VALUES (?, ?, ?, ?, ?)", "admin", hashedPassword, true, "superadmin", true)
 		if err != nil {
 			log.Println("Error seeding admin:", err)
 		} else {
-			log.Println("Default admin user created (admin/admin)")
+			log.Println("Default admin user created (admin/admin), the password must be changed on first login")
 		}
 	}
 }
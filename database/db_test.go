@@ -0,0 +1,93 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testDSNEnv names the environment variable with a PostgreSQL connection
+// string to also run the tests there. The tests drop every table of that
+// database, so it must be a throwaway one.
+const testDSNEnv = "POSADAS_TEST_DB_URL"
+
+// openTest opens a migrated test database of driver as DB for the length
+// of t. SQLite gets a new file; PostgreSQL is skipped unless testDSNEnv is
+// set, and its tables are dropped before and after.
+func openTest(t *testing.T, driver string) {
+	t.Helper()
+	var source string
+	switch driver {
+	case SQLite:
+		source = filepath.Join(t.TempDir(), "test.db")
+	case Postgres:
+		source = os.Getenv(testDSNEnv)
+		if source == "" {
+			t.Skip(testDSNEnv + " is not set")
+		}
+	}
+
+	if err := Open(driver, source); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if IsPostgres() {
+			dropAll(t)
+		}
+		DB.Close()
+		Driver = SQLite
+	})
+	if IsPostgres() {
+		dropAll(t)
+	}
+	if err := Migrate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// dropAll rolls back every migration, leaving an empty database
+func dropAll(t *testing.T) {
+	t.Helper()
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Rollback(len(migrations), true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// eachDriver runs test once per database driver, on a fresh database
+func eachDriver(t *testing.T, test func(t *testing.T)) {
+	for _, driver := range []string{SQLite, Postgres} {
+		t.Run(driver, func(t *testing.T) {
+			openTest(t, driver)
+			test(t)
+		})
+	}
+}
+
+func TestOpenUnknownDriver(t *testing.T) {
+	if err := Open("mysql", ""); err == nil {
+		t.Error("unknown driver accepted")
+	}
+}
+
+func TestOpenSQLiteSettings(t *testing.T) {
+	openTest(t, SQLite)
+
+	var foreignKeys int
+	if err := DB.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		t.Fatal(err)
+	}
+	if foreignKeys != 1 {
+		t.Error("foreign keys are off")
+	}
+	var journal string
+	if err := DB.QueryRow("PRAGMA journal_mode").Scan(&journal); err != nil {
+		t.Fatal(err)
+	}
+	if journal != "wal" {
+		t.Errorf("journal mode %q, want wal", journal)
+	}
+}
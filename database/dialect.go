@@ -0,0 +1,85 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// The supported database drivers
+const (
+	SQLite   = "sqlite"
+	Postgres = "postgres"
+)
+
+// Driver is the driver DB was opened with. Queries are written with ?
+// placeholders and SQL both databases understand; the few spots that
+// differ go through the helpers below.
+var Driver = SQLite
+
+// IsPostgres reports whether DB is a PostgreSQL database
+func IsPostgres() bool {
+	return Driver == Postgres
+}
+
+// YearMonth returns the SQL for the "2006-01" month of a date column
+func YearMonth(column string) string {
+	if IsPostgres() {
+		return fmt.Sprintf("to_char(%s, 'YYYY-MM')", column)
+	}
+	return fmt.Sprintf("strftime('%%Y-%%m', %s)", column)
+}
+
+// Year returns the SQL for the year of a date column, as text
+func Year(column string) string {
+	if IsPostgres() {
+		return fmt.Sprintf("to_char(%s, 'YYYY')", column)
+	}
+	return fmt.Sprintf("strftime('%%Y', %s)", column)
+}
+
+// GroupConcat returns the SQL joining the values of expr with sep
+func GroupConcat(expr, sep string) string {
+	if IsPostgres() {
+		return fmt.Sprintf("string_agg(%s, '%s')", expr, sep)
+	}
+	return fmt.Sprintf("GROUP_CONCAT(%s, '%s')", expr, sep)
+}
+
+// ForUpdate returns the clause that locks the rows a SELECT reads until
+// the transaction ends. SQLite transactions take the write lock when they
+// begin (_txlock=immediate), so there it is empty.
+func ForUpdate() string {
+	if IsPostgres() {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// Inserter is a *sql.DB or *sql.Tx
+type Inserter interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// InsertID runs an INSERT and returns the id of the new row. PostgreSQL
+// has no LastInsertId, so there it asks for the id with RETURNING. With
+// either driver it returns sql.ErrNoRows when nothing was inserted, as with
+// ON CONFLICT DO NOTHING.
+func InsertID(db Inserter, query string, args ...interface{}) (int64, error) {
+	if IsPostgres() {
+		var id int64
+		err := db.QueryRow(query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	// LastInsertId would still be the previous row of the connection
+	if n, err := result.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	return result.LastInsertId()
+}
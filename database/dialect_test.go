@@ -0,0 +1,167 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestIsPostgres(t *testing.T) {
+	eachDriver(t, func(t *testing.T) {
+		if IsPostgres() != (Driver == Postgres) {
+			t.Errorf("IsPostgres() = %v with driver %s", IsPostgres(), Driver)
+		}
+	})
+}
+
+func TestDateHelpers(t *testing.T) {
+	eachDriver(t, func(t *testing.T) {
+		_, err := DB.Exec("INSERT INTO events (name, type, date, time, location) VALUES ('Posada', 'salida', '2025-12-16', '19:00', 'Centro')")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var month, year string
+		var sameYear bool
+		err = DB.QueryRow("SELECT "+YearMonth("date")+", "+Year("date")+", "+Year("date")+" = CAST(? AS TEXT) FROM events", 2025).
+			Scan(&month, &year, &sameYear)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if month != "2025-12" {
+			t.Errorf("YearMonth: got %q, want 2025-12", month)
+		}
+		if year != "2025" {
+			t.Errorf("Year: got %q, want 2025", year)
+		}
+		if !sameYear {
+			t.Error("Year doesn't compare equal to the year as text")
+		}
+	})
+}
+
+func TestGroupConcat(t *testing.T) {
+	eachDriver(t, func(t *testing.T) {
+		for _, name := range []string{"Ana", "Beto", "Carmen"} {
+			if _, err := DB.Exec("INSERT INTO registrations (name, age, dni, year) VALUES (?, 8, ?, 2025)", name, "dni-"+name); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var joined string
+		if err := DB.QueryRow("SELECT " + GroupConcat("name", ", ") + " FROM registrations").Scan(&joined); err != nil {
+			t.Fatal(err)
+		}
+		// The order of the values isn't defined
+		names := strings.Split(joined, ", ")
+		if len(names) != 3 || !strings.Contains(joined, "Ana") || !strings.Contains(joined, "Beto") || !strings.Contains(joined, "Carmen") {
+			t.Errorf("got %q, want Ana, Beto and Carmen", joined)
+		}
+
+		var empty *string
+		if err := DB.QueryRow("SELECT " + GroupConcat("name", ", ") + " FROM registrations WHERE year = 1900").Scan(&empty); err != nil {
+			t.Fatal(err)
+		}
+		if empty != nil {
+			t.Errorf("no rows: got %q, want NULL", *empty)
+		}
+	})
+}
+
+func TestForUpdate(t *testing.T) {
+	defer func() { Driver = SQLite }()
+	for driver, want := range map[string]string{SQLite: "", Postgres: " FOR UPDATE"} {
+		Driver = driver
+		if got := ForUpdate(); got != want {
+			t.Errorf("%s: got %q, want %q", driver, got, want)
+		}
+	}
+}
+
+func TestForUpdateLocks(t *testing.T) {
+	eachDriver(t, func(t *testing.T) {
+		id, err := InsertID(DB, "INSERT INTO users (username, password, is_active) VALUES ('ana', '', TRUE)")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tx, err := DB.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		var username string
+		if err := tx.QueryRow("SELECT username FROM users WHERE id = ?"+ForUpdate(), id).Scan(&username); err != nil {
+			t.Fatal(err)
+		}
+		if username != "ana" {
+			t.Errorf("got %q, want ana", username)
+		}
+
+		// A second transaction can't read the row for update until the
+		// first one ends. SQLite already locked the whole database at BEGIN.
+		if IsPostgres() {
+			other, err := DB.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer other.Rollback()
+			if _, err := other.Exec("SET LOCAL lock_timeout = '100ms'"); err != nil {
+				t.Fatal(err)
+			}
+			if err := other.QueryRow("SELECT username FROM users WHERE id = ?"+ForUpdate(), id).Scan(&username); err == nil {
+				t.Error("row locked by another transaction read for update")
+			}
+		}
+
+		if _, err := tx.Exec("UPDATE users SET email = 'ana@example.com' WHERE id = ?", id); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestInsertID(t *testing.T) {
+	eachDriver(t, func(t *testing.T) {
+		first, err := InsertID(DB, "INSERT INTO users (username, password, is_active) VALUES (?, '', TRUE)", "ana")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tx, err := DB.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		second, err := InsertID(tx, "INSERT INTO users (username, password, is_active) VALUES (?, '', TRUE)", "beto")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		if first <= 0 || second <= first {
+			t.Fatalf("got ids %d and %d", first, second)
+		}
+		for id, want := range map[int64]string{first: "ana", second: "beto"} {
+			var username string
+			if err := DB.QueryRow("SELECT username FROM users WHERE id = ?", id).Scan(&username); err != nil {
+				t.Fatal(err)
+			}
+			if username != want {
+				t.Errorf("id %d is %q, want %q", id, username, want)
+			}
+		}
+
+		if _, err := InsertID(DB, "INSERT INTO users (username, password, is_active) VALUES (?, '', TRUE)", "ana"); err == nil {
+			t.Error("duplicate username inserted")
+		}
+		_, err = InsertID(DB, "INSERT INTO users (username, password, is_active) VALUES (?, '', TRUE) ON CONFLICT DO NOTHING", "ana")
+		if err != sql.ErrNoRows {
+			t.Errorf("nothing inserted: got %v, want sql.ErrNoRows", err)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// ForeignKeyViolation is a row pointing to a parent row that doesn't exist
+type ForeignKeyViolation struct {
+	Table  string
+	RowID  int64
+	Parent string
+}
+
+// These checks are SQLite's. PostgreSQL always enforces the foreign keys,
+// so there they find nothing.
+
+// IntegrityCheck runs PRAGMA integrity_check. A healthy database returns
+// the single line "ok".
+func IntegrityCheck() ([]string, error) {
+	if IsPostgres() {
+		return []string{"ok"}, nil
+	}
+	rows, err := DB.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// ForeignKeyCheck runs PRAGMA foreign_key_check and returns the orphan rows
+func ForeignKeyCheck() ([]ForeignKeyViolation, error) {
+	if IsPostgres() {
+		return nil, nil
+	}
+	rows, err := DB.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var violations []ForeignKeyViolation
+	for rows.Next() {
+		var v ForeignKeyViolation
+		var rowID sql.NullInt64
+		var fkID int
+		if err := rows.Scan(&v.Table, &rowID, &v.Parent, &fkID); err != nil {
+			return nil, err
+		}
+		v.RowID = rowID.Int64
+		violations = append(violations, v)
+	}
+	return violations, rows.Err()
+}
+
+// RepairOrphans fixes the rows left behind while foreign keys were not
+// enforced, doing what their ON DELETE clause would have done: rows of
+// SET NULL references lose the reference, the rest are deleted. It returns
+// how many rows it fixed per table.
+func RepairOrphans() (map[string]int, error) {
+	if IsPostgres() {
+		return nil, nil
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	repaired := map[string]int{}
+	// The cascades take the children of deleted orphans along, so one pass
+	// is normally enough; check again anyway before committing
+	for pass := 0; pass < 5; pass++ {
+		rows, err := tx.Query("PRAGMA foreign_key_check")
+		if err != nil {
+			return nil, err
+		}
+		type orphan struct {
+			table string
+			rowID sql.NullInt64
+			fkID  int
+		}
+		var orphans []orphan
+		for rows.Next() {
+			var o orphan
+			var parent string
+			if err := rows.Scan(&o.table, &o.rowID, &parent, &o.fkID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			orphans = append(orphans, o)
+		}
+		rows.Close()
+		if len(orphans) == 0 {
+			break
+		}
+
+		for _, o := range orphans {
+			if !o.rowID.Valid {
+				return nil, fmt.Errorf("%s has an orphan row without rowid", o.table)
+			}
+			var column, onDelete string
+			err := tx.QueryRow(`SELECT "from", on_delete FROM pragma_foreign_key_list(?) WHERE id = ?`, o.table, o.fkID).Scan(&column, &onDelete)
+			if err != nil {
+				return nil, err
+			}
+
+			var result sql.Result
+			if onDelete == "SET NULL" {
+				result, err = tx.Exec(fmt.Sprintf(`UPDATE "%s" SET "%s" = NULL WHERE rowid = ?`, o.table, column), o.rowID.Int64)
+			} else {
+				result, err = tx.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE rowid = ?`, o.table), o.rowID.Int64)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if n, _ := result.RowsAffected(); n > 0 {
+				repaired[o.table]++
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for table, count := range repaired {
+		log.Printf("Repaired %d orphan rows in %s", count, table)
+	}
+	return repaired, nil
+}
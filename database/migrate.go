@@ -0,0 +1,398 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in migrations/<driver>/ as NNNN_name.up.sql and
+// NNNN_name.down.sql and are compiled into the binary. Applied versions are
+// recorded in schema_migrations. A schema change is a new pair of files with
+// the next number in each driver's directory; applied migrations are never
+// edited.
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// Migration is one numbered schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState is a migration and when it was applied, if it was
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrationLockTimeout is how long a process waits for another one that is
+// migrating the same database
+const migrationLockTimeout = 30 * time.Second
+
+// ErrBaselineRollback is returned by Rollback when it would revert the
+// first migration without being allowed to
+var ErrBaselineRollback = errors.New("rolling back the first migration drops every table")
+
+// Migrations returns the embedded migrations of Driver ordered by version
+func Migrations() ([]Migration, error) {
+	dir := path.Join("migrations", Driver)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		file := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: name must be NNNN_name.up.sql or NNNN_name.down.sql", file)
+		}
+		number, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version %q", file, number)
+		}
+
+		data, err := migrationFiles.ReadFile(path.Join(dir, file))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// migrationLockKey identifies the PostgreSQL advisory lock taken while
+// migrating
+const migrationLockKey = 7215001
+
+// withMigrationLock runs fn in a write transaction on a single connection.
+// On SQLite BEGIN IMMEDIATE takes the write lock up front; on PostgreSQL the
+// transaction takes an advisory lock. Either way two processes starting at
+// once don't both apply the same migration: the second waits and then
+// finds nothing left to do.
+func withMigrationLock(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	release := func() {}
+	if IsPostgres() {
+		err = lockPostgres(ctx, conn)
+	} else {
+		release, err = lockSQLite(ctx, conn)
+	}
+	if err != nil {
+		return fmt.Errorf("taking the migration lock: %w", err)
+	}
+	defer release()
+	if err := fn(ctx, conn); err != nil {
+		if _, rbErr := conn.ExecContext(ctx, "ROLLBACK"); rbErr != nil {
+			log.Println(rbErr)
+		}
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "COMMIT")
+	return err
+}
+
+// lockSQLite waits up to migrationLockTimeout for the write lock. The
+// returned func puts back the busy timeout the connection had, so it goes
+// back to the pool waiting as long as the others.
+func lockSQLite(ctx context.Context, conn *sql.Conn) (func(), error) {
+	var busyTimeout int
+	if err := conn.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&busyTimeout); err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d", migrationLockTimeout.Milliseconds())); err != nil {
+		return nil, err
+	}
+	release := func() {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d", busyTimeout)); err != nil {
+			log.Println(err)
+		}
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+func lockPostgres(ctx context.Context, conn *sql.Conn) error {
+	if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+		return err
+	}
+	_, err := conn.ExecContext(ctx, fmt.Sprintf("SET LOCAL lock_timeout = '%dms'", migrationLockTimeout.Milliseconds()))
+	if err == nil {
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_xact_lock(?)", migrationLockKey)
+	}
+	if err != nil {
+		conn.ExecContext(ctx, "ROLLBACK")
+	}
+	return err
+}
+
+// tableExists reports whether the database has the table
+func tableExists(ctx context.Context, conn *sql.Conn, table string) (bool, error) {
+	query := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	if IsPostgres() {
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?"
+	}
+	var count int
+	err := conn.QueryRowContext(ctx, query, table).Scan(&count)
+	return count > 0, err
+}
+
+// appliedMigrations returns when each applied version was applied. It
+// creates schema_migrations if needed and reports whether it already
+// existed.
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, bool, error) {
+	existing, err := tableExists(ctx, conn, "schema_migrations")
+	if err != nil {
+		return nil, false, err
+	}
+	timestamp := "DATETIME"
+	if IsPostgres() {
+		timestamp = "TIMESTAMPTZ"
+	}
+	_, err = conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at `+timestamp+` NOT NULL
+	)`)
+	if err != nil {
+		return nil, false, err
+	}
+
+	applied, err := readApplied(ctx, conn)
+	return applied, existing, err
+}
+
+// readApplied returns when each version recorded in schema_migrations was
+// applied
+func readApplied(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// Migrate applies every pending migration, in order, in one transaction
+func Migrate() error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		applied, tracked, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		// Only SQLite databases predate migrations
+		if !tracked && !IsPostgres() {
+			if err := adoptLegacySchema(ctx, conn); err != nil {
+				return err
+			}
+		}
+
+		known := map[int]bool{}
+		for _, m := range migrations {
+			known[m.Version] = true
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if _, err := conn.ExecContext(ctx, m.Up); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			_, err := conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				m.Version, m.Name, time.Now().UTC())
+			if err != nil {
+				return err
+			}
+			log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		}
+
+		for version := range applied {
+			if !known[version] {
+				log.Printf("Warning: the database has migration %d, which this binary doesn't know; it was made by a newer version", version)
+			}
+		}
+		return nil
+	})
+}
+
+// Rollback reverts the last steps applied migrations, newest first, and
+// returns the ones it reverted. Reverting the first migration leaves an
+// empty database, so unless baseline is set Rollback then fails with
+// ErrBaselineRollback and reverts nothing.
+func Rollback(steps int, baseline bool) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	err = withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		applied, _, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down file and can't be rolled back", m.Version, m.Name)
+			}
+			if i == 0 && !baseline {
+				return ErrBaselineRollback
+			}
+			if _, err := conn.ExecContext(ctx, m.Down); err != nil {
+				return fmt.Errorf("rolling back %d_%s: %w", m.Version, m.Name, err)
+			}
+			if _, err := conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", m.Version); err != nil {
+				return err
+			}
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reverted, nil
+}
+
+// MigrationStatus lists the embedded migrations and which are applied. It
+// only reads, so it neither waits for a migration in progress nor creates
+// schema_migrations.
+func MigrationStatus() ([]MigrationState, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied := map[int]time.Time{}
+	tracked, err := tableExists(ctx, conn, "schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	if tracked {
+		if applied, err = readApplied(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	var states []MigrationState
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		states = append(states, MigrationState{Migration: m, Applied: ok, AppliedAt: appliedAt})
+	}
+	return states, nil
+}
+
+// adoptLegacySchema prepares a database created before migrations existed.
+// Those databases got their tables from CREATE TABLE IF NOT EXISTS and
+// their later columns from ALTER TABLE, so any of these columns may be
+// missing. Once they are added, the baseline migration only creates the
+// tables that don't exist yet.
+func adoptLegacySchema(ctx context.Context, conn *sql.Conn) error {
+	users, err := tableExists(ctx, conn, "users")
+	if err != nil || !users {
+		return err
+	}
+
+	log.Println("Adopting a database created before schema migrations")
+	// Existing accounts keep the full access they had before roles existed.
+	for _, column := range []struct{ table, name, definition string }{
+		{"users", "role", "TEXT NOT NULL DEFAULT 'superadmin'"},
+		{"users", "must_change_password", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"users", "totp_secret", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "totp_enabled", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"users", "totp_last_counter", "INTEGER NOT NULL DEFAULT 0"},
+		{"users", "email", "TEXT NOT NULL DEFAULT ''"},
+	} {
+		if err := addColumnIfMissing(ctx, conn, column.table, column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column to a table created by an older version
+func addColumnIfMissing(ctx context.Context, conn *sql.Conn, table, column, definition string) error {
+	var count int
+	err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	if _, err := conn.ExecContext(ctx, "ALTER TABLE "+table+" ADD COLUMN "+column+" "+definition); err != nil {
+		return err
+	}
+	log.Printf("Added column %s.%s", table, column)
+	return nil
+}
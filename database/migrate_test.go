@@ -0,0 +1,209 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// baselineTables are the tables created by 0001_initial
+var baselineTables = []string{
+	"registrations", "users", "events", "attendance", "hosts", "posada_nights", "route_stops",
+	"participant_groups", "group_members", "event_groups", "cast_roles", "cast_assignments",
+	"volunteers", "shift_slots", "shift_signups", "login_attempts", "recovery_codes", "sessions",
+	"audit_log", "password_resets", "app_settings",
+}
+
+// missingTables returns the tables of baselineTables that don't exist
+func missingTables(t *testing.T) []string {
+	t.Helper()
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var missing []string
+	for _, table := range baselineTables {
+		exists, err := tableExists(ctx, conn, table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			missing = append(missing, table)
+		}
+	}
+	return missing
+}
+
+func TestMigrationsMatchAcrossDrivers(t *testing.T) {
+	defer func() { Driver = SQLite }()
+	byDriver := map[string][]Migration{}
+	for _, driver := range []string{SQLite, Postgres} {
+		Driver = driver
+		migrations, err := Migrations()
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		for _, m := range migrations {
+			if m.Down == "" {
+				t.Errorf("%s: %04d_%s has no down file", driver, m.Version, m.Name)
+			}
+		}
+		byDriver[driver] = migrations
+	}
+
+	sqlite, postgres := byDriver[SQLite], byDriver[Postgres]
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("%d SQLite migrations and %d PostgreSQL ones", len(sqlite), len(postgres))
+	}
+	for i := range sqlite {
+		if sqlite[i].Version != postgres[i].Version || sqlite[i].Name != postgres[i].Name {
+			t.Errorf("SQLite has %04d_%s where PostgreSQL has %04d_%s",
+				sqlite[i].Version, sqlite[i].Name, postgres[i].Version, postgres[i].Name)
+		}
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	eachDriver(t, func(t *testing.T) {
+		if missing := missingTables(t); len(missing) > 0 {
+			t.Fatalf("missing after migrating: %v", missing)
+		}
+		states, err := MigrationStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, state := range states {
+			if !state.Applied {
+				t.Errorf("%04d_%s not applied", state.Version, state.Name)
+			}
+		}
+
+		// Migrating again has nothing to do
+		if err := Migrate(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The first migration is only rolled back when asked for
+		if _, err := Rollback(len(states), false); !errors.Is(err, ErrBaselineRollback) {
+			t.Fatalf("rolling back everything without baseline: got %v, want ErrBaselineRollback", err)
+		}
+		if missing := missingTables(t); len(missing) > 0 {
+			t.Fatalf("missing after a refused rollback: %v", missing)
+		}
+
+		reverted, err := Rollback(len(states), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reverted) != len(states) {
+			t.Errorf("reverted %d migrations, want %d", len(reverted), len(states))
+		}
+		if missing := missingTables(t); len(missing) != len(baselineTables) {
+			t.Errorf("tables left after rolling back: %d of %d are gone", len(missing), len(baselineTables))
+		}
+
+		if err := Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		if missing := missingTables(t); len(missing) > 0 {
+			t.Errorf("missing after migrating again: %v", missing)
+		}
+	})
+}
+
+func TestMigrationStatusOnlyReads(t *testing.T) {
+	if err := Open(SQLite, filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer DB.Close()
+
+	states, err := MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range states {
+		if state.Applied {
+			t.Errorf("%04d_%s applied in an empty database", state.Version, state.Name)
+		}
+	}
+	var tables int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'").Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Errorf("MigrationStatus created %d tables", tables)
+	}
+}
+
+func TestMigrateRestoresTheBusyTimeout(t *testing.T) {
+	openTest(t, SQLite)
+	// A single connection, so the one that migrated is the one checked
+	DB.SetMaxOpenConns(1)
+	if err := Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var busyTimeout int
+	if err := DB.QueryRow("PRAGMA busy_timeout").Scan(&busyTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if busyTimeout != 5000 {
+		t.Errorf("busy_timeout is %d after migrating, want the 5000 of the DSN", busyTimeout)
+	}
+}
+
+func TestAuditLogIsAppendOnly(t *testing.T) {
+	eachDriver(t, func(t *testing.T) {
+		_, err := DB.Exec("INSERT INTO audit_log (user_id, username, action, entity, entity_id) VALUES (1, 'ana', 'create', 'events', '1')")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DB.Exec("UPDATE audit_log SET username = 'beto'"); err == nil {
+			t.Error("audit entry updated")
+		}
+		if _, err := DB.Exec("DELETE FROM audit_log"); err == nil {
+			t.Error("audit entry deleted")
+		}
+
+		var username string
+		if err := DB.QueryRow("SELECT username FROM audit_log").Scan(&username); err != nil {
+			t.Fatal(err)
+		}
+		if username != "ana" {
+			t.Errorf("entry now says %q, want ana", username)
+		}
+	})
+}
+
+func TestDeletingAUserCascades(t *testing.T) {
+	eachDriver(t, func(t *testing.T) {
+		id, err := InsertID(DB, "INSERT INTO users (username, password, is_active) VALUES ('ana', '', TRUE)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = DB.Exec("INSERT INTO sessions (id, user_id, created_at, last_seen_at, expires_at) VALUES ('s1', ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DB.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, 'x')", id); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := DB.Exec("DELETE FROM users WHERE id = ?", id); err != nil {
+			t.Fatal(err)
+		}
+		for _, table := range []string{"sessions", "recovery_codes"} {
+			var count int
+			if err := DB.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+			if count != 0 {
+				t.Errorf("%s: %d rows of the deleted user left", table, count)
+			}
+		}
+	})
+}
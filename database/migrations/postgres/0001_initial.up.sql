@@ -0,0 +1,234 @@
+-- Baseline schema, the PostgreSQL version of sqlite/0001_initial.up.sql.
+-- Keep both in step: every migration exists once per dialect.
+
+CREATE TABLE registrations (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	age INTEGER NOT NULL,
+	dni TEXT NOT NULL,
+	guardian_name TEXT,
+	guardian_contact TEXT,
+	year INTEGER NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE users (
+	id SERIAL PRIMARY KEY,
+	username TEXT NOT NULL UNIQUE,
+	password TEXT NOT NULL,
+	is_active BOOLEAN NOT NULL,
+	role TEXT NOT NULL DEFAULT 'superadmin',
+	must_change_password BOOLEAN NOT NULL DEFAULT FALSE,
+	totp_secret TEXT NOT NULL DEFAULT '',
+	totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+	totp_last_counter INTEGER NOT NULL DEFAULT 0,
+	email TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE events (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	type TEXT NOT NULL CHECK(type IN ('ensayo', 'salida')),
+	date DATE NOT NULL,
+	time TEXT NOT NULL,
+	location TEXT NOT NULL,
+	description TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE attendance (
+	id SERIAL PRIMARY KEY,
+	event_id INTEGER NOT NULL,
+	registration_id INTEGER NOT NULL,
+	present BOOLEAN NOT NULL DEFAULT FALSE,
+	notes TEXT,
+	marked_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(event_id) REFERENCES events(id) ON DELETE CASCADE,
+	FOREIGN KEY(registration_id) REFERENCES registrations(id) ON DELETE CASCADE,
+	UNIQUE(event_id, registration_id)
+);
+
+CREATE TABLE hosts (
+	id SERIAL PRIMARY KEY,
+	family_name TEXT NOT NULL,
+	contact_name TEXT,
+	phone TEXT,
+	address TEXT NOT NULL,
+	notes TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE posada_nights (
+	id SERIAL PRIMARY KEY,
+	year INTEGER NOT NULL,
+	night INTEGER NOT NULL CHECK(night BETWEEN 1 AND 9),
+	date DATE NOT NULL,
+	host_id INTEGER,
+	event_id INTEGER,
+	notes TEXT,
+	FOREIGN KEY(host_id) REFERENCES hosts(id) ON DELETE SET NULL,
+	FOREIGN KEY(event_id) REFERENCES events(id) ON DELETE SET NULL,
+	UNIQUE(year, night)
+);
+
+CREATE TABLE route_stops (
+	id SERIAL PRIMARY KEY,
+	night_id INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	address TEXT,
+	time TEXT,
+	meeting_point BOOLEAN NOT NULL DEFAULT FALSE,
+	FOREIGN KEY(night_id) REFERENCES posada_nights(id) ON DELETE CASCADE
+);
+
+CREATE TABLE participant_groups (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	year INTEGER NOT NULL,
+	description TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(name, year)
+);
+
+CREATE TABLE group_members (
+	id SERIAL PRIMARY KEY,
+	group_id INTEGER NOT NULL,
+	registration_id INTEGER NOT NULL,
+	is_leader BOOLEAN NOT NULL DEFAULT FALSE,
+	FOREIGN KEY(group_id) REFERENCES participant_groups(id) ON DELETE CASCADE,
+	FOREIGN KEY(registration_id) REFERENCES registrations(id) ON DELETE CASCADE,
+	UNIQUE(group_id, registration_id)
+);
+
+CREATE TABLE event_groups (
+	event_id INTEGER NOT NULL,
+	group_id INTEGER NOT NULL,
+	FOREIGN KEY(event_id) REFERENCES events(id) ON DELETE CASCADE,
+	FOREIGN KEY(group_id) REFERENCES participant_groups(id) ON DELETE CASCADE,
+	PRIMARY KEY(event_id, group_id)
+);
+
+CREATE TABLE cast_roles (
+	id SERIAL PRIMARY KEY,
+	year INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	slots INTEGER NOT NULL DEFAULT 1,
+	min_age INTEGER NOT NULL DEFAULT 0,
+	max_age INTEGER NOT NULL DEFAULT 100,
+	description TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(year, name)
+);
+
+CREATE TABLE cast_assignments (
+	id SERIAL PRIMARY KEY,
+	role_id INTEGER NOT NULL,
+	registration_id INTEGER NOT NULL,
+	is_understudy BOOLEAN NOT NULL DEFAULT FALSE,
+	FOREIGN KEY(role_id) REFERENCES cast_roles(id) ON DELETE CASCADE,
+	FOREIGN KEY(registration_id) REFERENCES registrations(id) ON DELETE CASCADE,
+	UNIQUE(role_id, registration_id)
+);
+
+CREATE TABLE volunteers (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	phone TEXT,
+	email TEXT,
+	skills TEXT,
+	availability TEXT,
+	is_active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE shift_slots (
+	id SERIAL PRIMARY KEY,
+	event_id INTEGER NOT NULL,
+	skill TEXT NOT NULL,
+	start_time TEXT,
+	end_time TEXT,
+	needed INTEGER NOT NULL DEFAULT 1,
+	critical BOOLEAN NOT NULL DEFAULT FALSE,
+	notes TEXT,
+	FOREIGN KEY(event_id) REFERENCES events(id) ON DELETE CASCADE
+);
+
+CREATE TABLE shift_signups (
+	id SERIAL PRIMARY KEY,
+	slot_id INTEGER NOT NULL,
+	volunteer_id INTEGER NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(slot_id) REFERENCES shift_slots(id) ON DELETE CASCADE,
+	FOREIGN KEY(volunteer_id) REFERENCES volunteers(id) ON DELETE CASCADE,
+	UNIQUE(slot_id, volunteer_id)
+);
+
+CREATE TABLE login_attempts (
+	id SERIAL PRIMARY KEY,
+	username TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	user_agent TEXT,
+	reason TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE recovery_codes (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	code_hash TEXT NOT NULL,
+	used_at TIMESTAMPTZ,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE sessions (
+	id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	ip TEXT,
+	user_agent TEXT,
+	created_at TIMESTAMPTZ NOT NULL,
+	last_seen_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	revoked_at TIMESTAMPTZ,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- The audit log is append-only: the triggers reject any change to
+-- recorded entries
+CREATE TABLE audit_log (
+	id SERIAL PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	user_id INTEGER NOT NULL,
+	username TEXT NOT NULL,
+	action TEXT NOT NULL,
+	entity TEXT NOT NULL,
+	entity_id TEXT,
+	before_json TEXT,
+	after_json TEXT,
+	ip TEXT
+);
+CREATE INDEX idx_audit_log_entity ON audit_log(entity, entity_id);
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+	FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+	FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+CREATE TABLE password_resets (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE app_settings (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
@@ -0,0 +1,23 @@
+-- Rolling back the baseline drops every table and all of its data.
+
+DROP TABLE IF EXISTS app_settings;
+DROP TABLE IF EXISTS password_resets;
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS shift_signups;
+DROP TABLE IF EXISTS shift_slots;
+DROP TABLE IF EXISTS volunteers;
+DROP TABLE IF EXISTS cast_assignments;
+DROP TABLE IF EXISTS cast_roles;
+DROP TABLE IF EXISTS event_groups;
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS participant_groups;
+DROP TABLE IF EXISTS route_stops;
+DROP TABLE IF EXISTS posada_nights;
+DROP TABLE IF EXISTS hosts;
+DROP TABLE IF EXISTS attendance;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS registrations;
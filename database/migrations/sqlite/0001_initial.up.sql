@@ -0,0 +1,233 @@
+-- Baseline schema. Tables use IF NOT EXISTS so databases created before
+-- migrations existed can adopt it; see adoptLegacySchema.
+
+CREATE TABLE IF NOT EXISTS registrations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	age INTEGER NOT NULL,
+	dni TEXT NOT NULL,
+	guardian_name TEXT,
+	guardian_contact TEXT,
+	year INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL UNIQUE,
+	password TEXT NOT NULL,
+	is_active BOOLEAN NOT NULL,
+	role TEXT NOT NULL DEFAULT 'superadmin',
+	must_change_password BOOLEAN NOT NULL DEFAULT 0,
+	totp_secret TEXT NOT NULL DEFAULT '',
+	totp_enabled BOOLEAN NOT NULL DEFAULT 0,
+	totp_last_counter INTEGER NOT NULL DEFAULT 0,
+	email TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	type TEXT NOT NULL CHECK(type IN ('ensayo', 'salida')),
+	date DATE NOT NULL,
+	time TEXT NOT NULL,
+	location TEXT NOT NULL,
+	description TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS attendance (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_id INTEGER NOT NULL,
+	registration_id INTEGER NOT NULL,
+	present BOOLEAN NOT NULL DEFAULT 0,
+	notes TEXT,
+	marked_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(event_id) REFERENCES events(id) ON DELETE CASCADE,
+	FOREIGN KEY(registration_id) REFERENCES registrations(id) ON DELETE CASCADE,
+	UNIQUE(event_id, registration_id)
+);
+
+CREATE TABLE IF NOT EXISTS hosts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	family_name TEXT NOT NULL,
+	contact_name TEXT,
+	phone TEXT,
+	address TEXT NOT NULL,
+	notes TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS posada_nights (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	year INTEGER NOT NULL,
+	night INTEGER NOT NULL CHECK(night BETWEEN 1 AND 9),
+	date DATE NOT NULL,
+	host_id INTEGER,
+	event_id INTEGER,
+	notes TEXT,
+	FOREIGN KEY(host_id) REFERENCES hosts(id) ON DELETE SET NULL,
+	FOREIGN KEY(event_id) REFERENCES events(id) ON DELETE SET NULL,
+	UNIQUE(year, night)
+);
+
+CREATE TABLE IF NOT EXISTS route_stops (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	night_id INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	address TEXT,
+	time TEXT,
+	meeting_point BOOLEAN NOT NULL DEFAULT 0,
+	FOREIGN KEY(night_id) REFERENCES posada_nights(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS participant_groups (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	year INTEGER NOT NULL,
+	description TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(name, year)
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	group_id INTEGER NOT NULL,
+	registration_id INTEGER NOT NULL,
+	is_leader BOOLEAN NOT NULL DEFAULT 0,
+	FOREIGN KEY(group_id) REFERENCES participant_groups(id) ON DELETE CASCADE,
+	FOREIGN KEY(registration_id) REFERENCES registrations(id) ON DELETE CASCADE,
+	UNIQUE(group_id, registration_id)
+);
+
+CREATE TABLE IF NOT EXISTS event_groups (
+	event_id INTEGER NOT NULL,
+	group_id INTEGER NOT NULL,
+	FOREIGN KEY(event_id) REFERENCES events(id) ON DELETE CASCADE,
+	FOREIGN KEY(group_id) REFERENCES participant_groups(id) ON DELETE CASCADE,
+	PRIMARY KEY(event_id, group_id)
+);
+
+CREATE TABLE IF NOT EXISTS cast_roles (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	year INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	slots INTEGER NOT NULL DEFAULT 1,
+	min_age INTEGER NOT NULL DEFAULT 0,
+	max_age INTEGER NOT NULL DEFAULT 100,
+	description TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(year, name)
+);
+
+CREATE TABLE IF NOT EXISTS cast_assignments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	role_id INTEGER NOT NULL,
+	registration_id INTEGER NOT NULL,
+	is_understudy BOOLEAN NOT NULL DEFAULT 0,
+	FOREIGN KEY(role_id) REFERENCES cast_roles(id) ON DELETE CASCADE,
+	FOREIGN KEY(registration_id) REFERENCES registrations(id) ON DELETE CASCADE,
+	UNIQUE(role_id, registration_id)
+);
+
+CREATE TABLE IF NOT EXISTS volunteers (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	phone TEXT,
+	email TEXT,
+	skills TEXT,
+	availability TEXT,
+	is_active BOOLEAN NOT NULL DEFAULT 1,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS shift_slots (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_id INTEGER NOT NULL,
+	skill TEXT NOT NULL,
+	start_time TEXT,
+	end_time TEXT,
+	needed INTEGER NOT NULL DEFAULT 1,
+	critical BOOLEAN NOT NULL DEFAULT 0,
+	notes TEXT,
+	FOREIGN KEY(event_id) REFERENCES events(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS shift_signups (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	slot_id INTEGER NOT NULL,
+	volunteer_id INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(slot_id) REFERENCES shift_slots(id) ON DELETE CASCADE,
+	FOREIGN KEY(volunteer_id) REFERENCES volunteers(id) ON DELETE CASCADE,
+	UNIQUE(slot_id, volunteer_id)
+);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	user_agent TEXT,
+	reason TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	code_hash TEXT NOT NULL,
+	used_at DATETIME,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	ip TEXT,
+	user_agent TEXT,
+	created_at DATETIME NOT NULL,
+	last_seen_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	revoked_at DATETIME,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- The audit log is append-only: the triggers reject any change to
+-- recorded entries
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	user_id INTEGER NOT NULL,
+	username TEXT NOT NULL,
+	action TEXT NOT NULL,
+	entity TEXT NOT NULL,
+	entity_id TEXT,
+	before_json TEXT,
+	after_json TEXT,
+	ip TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log(entity, entity_id);
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TABLE IF NOT EXISTS password_resets (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	used_at DATETIME,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS app_settings (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"posadas-sistema/models"
+	"posadas-sistema/store"
+)
+
+// Account operations of the command line. They apply the same rules as
+// the admin pages and are recorded in the audit log as "(cli)".
+
+// CreateAdmin creates an account with the given role, with password or a
+// generated one that must be changed on the first login. It returns the
+// password that was set.
+func (a *App) CreateAdmin(username, password, role, email string) (string, error) {
+	if username == "" {
+		return "", errors.New("a username is required")
+	}
+	if !ValidRole(role) {
+		return "", fmt.Errorf("unknown role %q", role)
+	}
+	if err := checkEmail(email); err != nil {
+		return "", err
+	}
+	if _, err := a.findUser(username); err == nil {
+		return "", fmt.Errorf("there is already an account called %q", username)
+	}
+	password, generated, err := passwordOrRandom(username, password)
+	if err != nil {
+		return "", err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	user := models.User{Username: username, Password: string(hashedPassword), Email: email, IsActive: true, Role: role, MustChangePassword: generated}
+	if err := a.Users.Create(&user); err != nil {
+		return "", err
+	}
+
+	appendAudit(context.Background(), 0, "(cli)", "", "create", "users", fmt.Sprint(user.ID), "", "")
+	return password, nil
+}
+
+// ResetPassword sets a new password on an account, or a generated one that
+// must be changed on the next login, and closes its sessions. It returns
+// the password that was set.
+func (a *App) ResetPassword(username, password string) (string, error) {
+	user, err := a.findUser(username)
+	if err != nil {
+		return "", err
+	}
+	password, generated, err := passwordOrRandom(username, password)
+	if err != nil {
+		return "", err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	user.Password = string(hashedPassword)
+	user.MustChangePassword = generated
+	if err := a.Users.Update(user); err != nil {
+		return "", err
+	}
+	if err := a.Sessions.RevokeUser(user.ID, ""); err != nil {
+		return "", err
+	}
+
+	appendAudit(context.Background(), 0, "(cli)", "", "reset_password", "users", fmt.Sprint(user.ID), "", "")
+	return password, nil
+}
+
+// findUser returns the account called username
+func (a *App) findUser(username string) (models.User, error) {
+	user, err := a.Users.FindByUsername(username)
+	if err == store.ErrNotFound {
+		return models.User{}, fmt.Errorf("there is no account called %q", username)
+	}
+	return user, err
+}
+
+// passwordOrRandom checks password, or generates one if it is empty
+func passwordOrRandom(username, password string) (string, bool, error) {
+	if password == "" {
+		password, err := randomPassword(16)
+		return password, true, err
+	}
+	return password, false, checkPassword(username, password)
+}
@@ -3,116 +3,141 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
-	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
-	"posadas-sistema/database"
 	"posadas-sistema/models"
+	"strconv"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-func DashboardHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := database.DB.Query("SELECT id, name, age, dni, guardian_name, guardian_contact, year, created_at FROM registrations ORDER BY created_at DESC")
+func (a *App) DashboardHandler(w http.ResponseWriter, r *http.Request) {
+	registrations, err := a.Registrations.List()
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
-	defer rows.Close()
 
-	var registrations []models.Registration
-	for rows.Next() {
-		var reg models.Registration
-		if err := rows.Scan(&reg.ID, &reg.Name, &reg.Age, &reg.DNI, &reg.GuardianName, &reg.GuardianContact, &reg.Year, &reg.CreatedAt); err != nil {
-			log.Println(err)
-			continue
-		}
-		registrations = append(registrations, reg)
-	}
-
-	tmpl, err := template.ParseFiles("templates/base.html", "templates/dashboard.html")
+	tmpl, err := parseTemplates(r, "dashboard.html")
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
 
+	claims := currentClaims(r)
 	data := struct {
 		Registrations []models.Registration
 		User          string
+		Role          string
 	}{
 		Registrations: registrations,
-		User:          "Admin", // You could get this from the session
+		User:          claims.Username,
+		Role:          RoleLabel(claims.Role),
 	}
 
 	tmpl.Execute(w, data)
 }
 
 // AdminListHandler lists all admin users
-func AdminListHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := database.DB.Query("SELECT id, username, is_active FROM users")
+func (a *App) AdminListHandler(w http.ResponseWriter, r *http.Request) {
+	accounts, err := a.Users.List()
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
-	defer rows.Close()
 
-	var users []models.User
-	for rows.Next() {
-		var u models.User
-		var isActive bool
-		if err := rows.Scan(&u.ID, &u.Username, &isActive); err != nil {
-			log.Println(err)
-			continue
-		}
-		u.IsActive = isActive
-		users = append(users, u)
+	type userRow struct {
+		models.User
+		RoleLabel string
+	}
+
+	var users []userRow
+	for _, user := range accounts {
+		users = append(users, userRow{User: user, RoleLabel: RoleLabel(user.Role)})
 	}
 
-	tmpl, err := template.ParseFiles("templates/base.html", "templates/admin_list.html")
+	tmpl, err := parseTemplates(r, "admin_list.html")
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
-	tmpl.Execute(w, users)
+	data := struct {
+		Users         []userRow
+		Require2FA    bool
+		CurrentUserID int
+	}{
+		Users:         users,
+		Require2FA:    require2FA(r.Context()),
+		CurrentUserID: currentClaims(r).UserID,
+	}
+	tmpl.Execute(w, data)
 }
 
-// AdminCreateHandler shows the form to create a new admin
-func AdminCreateHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.ParseFiles("templates/base.html", "templates/admin_form.html")
+// adminForm is the data rendered by admin_form.html
+type adminForm struct {
+	User      models.User
+	Roles     []RoleOption
+	MinLength int
+	Error     string
+}
+
+// renderAdminForm renders admin_form.html, with a validation error if any
+func renderAdminForm(w http.ResponseWriter, r *http.Request, user models.User, formError string) {
+	tmpl, err := parseTemplates(r, "admin_form.html")
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
-	tmpl.Execute(w, nil)
+	if formError != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	tmpl.Execute(w, adminForm{User: user, Roles: Roles, MinLength: passwordMinLength, Error: formError})
+}
+
+// AdminCreateHandler shows the form to create a new admin
+func AdminCreateHandler(w http.ResponseWriter, r *http.Request) {
+	renderAdminForm(w, r, models.User{Role: RoleViewer, MustChangePassword: true}, "")
 }
 
 // AdminStoreHandler saves the new admin
-func AdminStoreHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) AdminStoreHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	username := r.FormValue("username")
 	password := r.FormValue("password")
+	role := r.FormValue("role")
+	email := strings.TrimSpace(r.FormValue("email"))
+	mustChange := r.FormValue("must_change_password") == "on"
+	if !ValidRole(role) {
+		httpError(w, r, http.StatusBadRequest, "Invalid role")
+		return
+	}
+	if err := checkEmail(email); err != nil {
+		renderAdminForm(w, r, models.User{Username: username, Email: email, Role: role, MustChangePassword: mustChange}, err.Error())
+		return
+	}
+	if err := checkPassword(username, password); err != nil {
+		renderAdminForm(w, r, models.User{Username: username, Email: email, Role: role, MustChangePassword: mustChange}, err.Error())
+		return
+	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
 
-	_, err = database.DB.Exec("INSERT INTO users (username, password, is_active) VALUES (?, ?, ?)", username, hashedPassword, true)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	user := models.User{Username: username, Password: string(hashedPassword), Email: email, IsActive: true, Role: role, MustChangePassword: mustChange}
+	if err := a.Users.Create(&user); err != nil {
+		serverError(w, r, err)
 		return
 	}
+	reportCreated(r, int64(user.ID))
 
 	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
@@ -120,110 +145,144 @@ func AdminStoreHandler(w http.ResponseWriter, r *http.Request) {
 // ===== EVENT MANAGEMENT HANDLERS =====
 
 // EventListHandler lists all events
-func EventListHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := database.DB.Query("SELECT id, name, type, date, time, location, description, created_at FROM events ORDER BY date DESC")
+func (a *App) EventListHandler(w http.ResponseWriter, r *http.Request) {
+	events, err := a.Events.List()
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
-	defer rows.Close()
-
-	var events []models.Event
-	for rows.Next() {
-		var event models.Event
-		if err := rows.Scan(&event.ID, &event.Name, &event.Type, &event.Date, &event.Time, &event.Location, &event.Description, &event.CreatedAt); err != nil {
-			log.Println(err)
-			continue
-		}
-		events = append(events, event)
-	}
 
-	tmpl, err := template.ParseFiles("templates/base.html", "templates/events_list.html")
+	tmpl, err := parseTemplates(r, "events_list.html")
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
 	tmpl.Execute(w, events)
 }
 
+// eventForm is the data rendered by events_form.html
+type eventForm struct {
+	Event          models.Event
+	Groups         []models.Group
+	SelectedGroups map[int]bool
+}
+
 // EventCreateHandler shows the form to create a new event
-func EventCreateHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.ParseFiles("templates/base.html", "templates/events_form.html")
+func (a *App) EventCreateHandler(w http.ResponseWriter, r *http.Request) {
+	groups, err := a.Groups.List()
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
-	tmpl.Execute(w, nil)
+
+	tmpl, err := parseTemplates(r, "events_form.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	tmpl.Execute(w, eventForm{Groups: groups})
+}
+
+// eventFromForm reads the fields of events_form.html
+func eventFromForm(r *http.Request) (models.Event, error) {
+	date, err := time.Parse("2006-01-02", r.FormValue("date"))
+	if err != nil {
+		return models.Event{}, err
+	}
+	return models.Event{
+		Name:        r.FormValue("name"),
+		Type:        r.FormValue("type"),
+		Date:        date,
+		Time:        r.FormValue("time"),
+		Location:    r.FormValue("location"),
+		Description: r.FormValue("description"),
+	}, nil
+}
+
+// groupIDsFromForm reads the groups checked in events_form.html
+func groupIDsFromForm(r *http.Request) []int {
+	var ids []int
+	for _, value := range r.Form["group_id"] {
+		if id, err := strconv.Atoi(value); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
 }
 
 // EventStoreHandler saves the new event
-func EventStoreHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) EventStoreHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	name := r.FormValue("name")
-	eventType := r.FormValue("type")
-	date := r.FormValue("date")
-	time := r.FormValue("time")
-	location := r.FormValue("location")
-	description := r.FormValue("description")
+	event, err := eventFromForm(r)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "Invalid date")
+		return
+	}
 
-	_, err := database.DB.Exec("INSERT INTO events (name, type, date, time, location, description) VALUES (?, ?, ?, ?, ?, ?)",
-		name, eventType, date, time, location, description)
+	err = a.Events.Create(&event, groupIDsFromForm(r))
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
+	reportCreated(r, int64(event.ID))
 
 	http.Redirect(w, r, "/admin/events", http.StatusSeeOther)
 }
 
 // EventEditHandler shows the form to edit an event
-func EventEditHandler(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	var event models.Event
-	err := database.DB.QueryRow("SELECT id, name, type, date, time, location, description FROM events WHERE id = ?", id).
-		Scan(&event.ID, &event.Name, &event.Type, &event.Date, &event.Time, &event.Location, &event.Description)
+func (a *App) EventEditHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	event, err := a.Events.Get(id)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	groups, err := a.Groups.List()
 	if err != nil {
-		http.Error(w, "Event not found", http.StatusNotFound)
+		serverError(w, r, err)
 		return
 	}
 
-	tmpl, err := template.ParseFiles("templates/base.html", "templates/events_form.html")
+	selectedGroups, err := a.Groups.ForEvent(event.ID)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
-	tmpl.Execute(w, event)
+
+	tmpl, err := parseTemplates(r, "events_form.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	tmpl.Execute(w, eventForm{Event: event, Groups: groups, SelectedGroups: selectedGroups})
 }
 
 // EventUpdateHandler updates the event
-func EventUpdateHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) EventUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	id := r.FormValue("id")
-	name := r.FormValue("name")
-	eventType := r.FormValue("type")
-	date := r.FormValue("date")
-	time := r.FormValue("time")
-	location := r.FormValue("location")
-	description := r.FormValue("description")
+	event, err := eventFromForm(r)
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "Invalid date")
+		return
+	}
+	event.ID, err = strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "Invalid event ID")
+		return
+	}
 
-	_, err := database.DB.Exec("UPDATE events SET name = ?, type = ?, date = ?, time = ?, location = ?, description = ? WHERE id = ?",
-		name, eventType, date, time, location, description, id)
+	err = a.Events.Update(event, groupIDsFromForm(r))
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
 
@@ -231,12 +290,11 @@ func EventUpdateHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // EventDeleteHandler deletes an event
-func EventDeleteHandler(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	_, err := database.DB.Exec("DELETE FROM events WHERE id = ?", id)
+func (a *App) EventDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	err := a.Events.Delete(id)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
 	http.Redirect(w, r, "/admin/events", http.StatusSeeOther)
@@ -245,33 +303,45 @@ func EventDeleteHandler(w http.ResponseWriter, r *http.Request) {
 // ===== ATTENDANCE HANDLERS =====
 
 // AttendanceHandler shows the form to mark attendance for an event
-func AttendanceHandler(w http.ResponseWriter, r *http.Request) {
-	eventID := r.URL.Query().Get("event_id")
-	if eventID == "" {
-		http.Error(w, "Event ID required", http.StatusBadRequest)
+func (a *App) AttendanceHandler(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.Atoi(r.URL.Query().Get("event_id"))
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "Event ID required")
 		return
 	}
 
 	// Get event details
-	var event models.Event
-	err := database.DB.QueryRow("SELECT id, name, type, date, time, location FROM events WHERE id = ?", eventID).
-		Scan(&event.ID, &event.Name, &event.Type, &event.Date, &event.Time, &event.Location)
+	event, err := a.Events.Get(eventID)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	groups, err := a.Groups.List()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	// Get all registrations, or only the members of the selected group
+	groupID, _ := strconv.Atoi(r.URL.Query().Get("group_id"))
+	registrations, err := a.Registrations.ListByName(groupID)
 	if err != nil {
-		http.Error(w, "Event not found", http.StatusNotFound)
+		serverError(w, r, err)
 		return
 	}
 
-	// Get all registrations
-	rows, err := database.DB.Query("SELECT id, name, age, dni FROM registrations ORDER BY name")
+	// Attendance already marked for the event
+	marks, err := a.Attendance.ForEvent(event.ID)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
-	defer rows.Close()
 
 	type AttendanceForm struct {
 		Event         models.Event
+		Groups        []models.Group
+		GroupID       int
 		Registrations []struct {
 			ID              int    `json:"id"`
 			Name            string `json:"name"`
@@ -284,8 +354,10 @@ func AttendanceHandler(w http.ResponseWriter, r *http.Request) {
 
 	var formData AttendanceForm
 	formData.Event = event
+	formData.Groups = groups
+	formData.GroupID = groupID
 
-	for rows.Next() {
+	for _, registration := range registrations {
 		var reg struct {
 			ID              int    `json:"id"`
 			Name            string `json:"name"`
@@ -294,64 +366,46 @@ func AttendanceHandler(w http.ResponseWriter, r *http.Request) {
 			Present         bool   `json:"present"`
 			HasAttendance   bool   `json:"has_attendance"`
 		}
-
-		if err := rows.Scan(&reg.ID, &reg.Name, &reg.Age, &reg.DNI); err != nil {
-			log.Println(err)
-			continue
-		}
-
-		// Check if attendance already exists for this registration and event
-		var present bool
-		err := database.DB.QueryRow("SELECT present FROM attendance WHERE event_id = ? AND registration_id = ?", eventID, reg.ID).
-			Scan(&present)
-		if err == nil {
-			reg.Present = present
-			reg.HasAttendance = true
-		} else {
-			reg.Present = false
-			reg.HasAttendance = false
-		}
+		reg.ID = registration.ID
+		reg.Name = registration.Name
+		reg.Age = registration.Age
+		reg.DNI = registration.DNI
+		reg.Present, reg.HasAttendance = marks[reg.ID]
 
 		formData.Registrations = append(formData.Registrations, reg)
 	}
 
-	tmpl, err := template.ParseFiles("templates/base.html", "templates/attendance_form.html")
+	tmpl, err := parseTemplates(r, "attendance_form.html")
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
 	tmpl.Execute(w, formData)
 }
 
 // AttendanceStoreHandler saves the attendance data
-func AttendanceStoreHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) AttendanceStoreHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	eventID := r.FormValue("event_id")
-	presentRegistrations := r.Form["present"] // This gets all values for "present" field
-	notes := r.FormValue("notes")
-
-	// First, get all current registrations to know who was present
-	var allRegistrations []int
-	rows, err := database.DB.Query("SELECT id FROM registrations")
+	eventID, err := strconv.Atoi(r.FormValue("event_id"))
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		httpError(w, r, http.StatusBadRequest, "Event ID required")
 		return
 	}
-	defer rows.Close()
+	presentRegistrations := r.Form["present"] // This gets all values for "present" field
+	notes := r.FormValue("notes")
 
-	for rows.Next() {
+	// Only the registrations listed in the form are replaced, so saving a
+	// list filtered by group keeps the attendance of everybody else
+	var allRegistrations []int
+	for _, regStr := range r.Form["registration_id"] {
 		var regID int
-		if err := rows.Scan(&regID); err != nil {
-			log.Println(err)
-			continue
+		if _, err := fmt.Sscanf(regStr, "%d", &regID); err == nil {
+			allRegistrations = append(allRegistrations, regID)
 		}
-		allRegistrations = append(allRegistrations, regID)
 	}
 
 	// Create a map of present registrations
@@ -363,62 +417,46 @@ func AttendanceStoreHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Delete existing attendance for this event (to replace with new data)
-	_, err = database.DB.Exec("DELETE FROM attendance WHERE event_id = ?", eventID)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
-	}
-
-	// Insert attendance records
+	// Replace the attendance of these registrations with the new data
+	var marks []models.Attendance
 	for _, regID := range allRegistrations {
-		present := presentMap[regID]
-		_, err := database.DB.Exec("INSERT INTO attendance (event_id, registration_id, present, notes) VALUES (?, ?, ?, ?)",
-			eventID, regID, present, notes)
-		if err != nil {
-			log.Println(err)
-			// Continue with other registrations even if one fails
-		}
+		marks = append(marks, models.Attendance{RegistrationID: regID, Present: presentMap[regID], Notes: notes})
+	}
+	if err := a.Attendance.Replace(eventID, marks); err != nil {
+		serverError(w, r, err)
+		return
 	}
 
 	http.Redirect(w, r, "/admin/events", http.StatusSeeOther)
 }
 
 // DashboardDataHandler returns JSON data for the dashboard
-func DashboardDataHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) DashboardDataHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Get attendance statistics
-	var totalEvents int
-	var totalAttendances int
-	var ensayosCount int
-	var salidasCount int
+	counts, err := a.Events.CountByType()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	totalEvents := 0
+	for _, count := range counts {
+		totalEvents += count
+	}
 
-	database.DB.QueryRow("SELECT COUNT(*) FROM events").Scan(&totalEvents)
-	database.DB.QueryRow("SELECT COUNT(*) FROM attendance WHERE present = 1").Scan(&totalAttendances)
-	database.DB.QueryRow("SELECT COUNT(*) FROM events WHERE type = 'ensayo'").Scan(&ensayosCount)
-	database.DB.QueryRow("SELECT COUNT(*) FROM events WHERE type = 'salida'").Scan(&salidasCount)
+	totalAttendances, err := a.Attendance.CountPresent()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
 
 	// Get monthly attendance data
-	monthlyQuery := `
-		SELECT
-			strftime('%Y-%m', e.date) as month,
-			e.type,
-			COUNT(CASE WHEN a.present = 1 THEN 1 END) as attendances,
-			COUNT(*) as total_registrations
-		FROM events e
-		LEFT JOIN attendance a ON e.id = a.event_id
-		GROUP BY strftime('%Y-%m', e.date), e.type
-		ORDER BY month`
-
-	rows, err := database.DB.Query(monthlyQuery)
+	months, err := a.Attendance.Monthly()
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
-	defer rows.Close()
 
 	type MonthlyData struct {
 		Month           string `json:"month"`
@@ -428,13 +466,8 @@ func DashboardDataHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var monthlyData []MonthlyData
-	for rows.Next() {
-		var data MonthlyData
-		if err := rows.Scan(&data.Month, &data.Type, &data.Attendances, &data.TotalRegistrations); err != nil {
-			log.Println(err)
-			continue
-		}
-		monthlyData = append(monthlyData, data)
+	for _, month := range months {
+		monthlyData = append(monthlyData, MonthlyData{Month: month.Month, Type: month.Type, Attendances: month.Attendances, TotalRegistrations: month.Total})
 	}
 
 	// Calculate percentage for monthly data
@@ -444,70 +477,108 @@ func DashboardDataHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	groups, err := a.Groups.Attendance()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	type GroupData struct {
+		Name        string `json:"name"`
+		Year        int    `json:"year"`
+		Members     int    `json:"members"`
+		Attendances int    `json:"attendances"`
+		Marked      int    `json:"marked"`
+	}
+
+	var groupData []GroupData
+	for _, group := range groups {
+		groupData = append(groupData, GroupData{Name: group.Name, Year: group.Year, Members: group.Members, Attendances: group.Attendances, Marked: group.Marked})
+	}
+
 	response := struct {
 		TotalEvents        int             `json:"total_events"`
 		TotalAttendances   int             `json:"total_attendances"`
 		EnsayosCount       int             `json:"ensayos_count"`
 		SalidasCount       int             `json:"salidas_count"`
 		MonthlyData        []MonthlyData   `json:"monthly_data"`
+		GroupData          []GroupData     `json:"group_data"`
 	}{
 		TotalEvents:      totalEvents,
 		TotalAttendances: totalAttendances,
-		EnsayosCount:     ensayosCount,
-		SalidasCount:     salidasCount,
+		EnsayosCount:     counts["ensayo"],
+		SalidasCount:     counts["salida"],
 		MonthlyData:      monthlyData,
+		GroupData:        groupData,
 	}
 
 	json.NewEncoder(w).Encode(response)
 }
 
 // AdminEditHandler shows the form to edit an admin
-func AdminEditHandler(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	var user models.User
-	var isActive bool
-	err := database.DB.QueryRow("SELECT id, username, is_active FROM users WHERE id = ?", id).Scan(&user.ID, &user.Username, &isActive)
+func (a *App) AdminEditHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	user, err := a.Users.Get(id)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		httpError(w, r, http.StatusNotFound, "User not found")
 		return
 	}
-	user.IsActive = isActive
 
-	tmpl, err := template.ParseFiles("templates/base.html", "templates/admin_form.html")
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-	tmpl.Execute(w, user)
+	renderAdminForm(w, r, user, "")
 }
 
 // AdminUpdateHandler updates the admin
-func AdminUpdateHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) AdminUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	id := r.FormValue("id")
 	username := r.FormValue("username")
 	password := r.FormValue("password")
+	role := r.FormValue("role")
+	email := strings.TrimSpace(r.FormValue("email"))
+	mustChange := r.FormValue("must_change_password") == "on"
+	if !ValidRole(role) {
+		httpError(w, r, http.StatusBadRequest, "Invalid role")
+		return
+	}
 
-	var err error
+	userID, _ := strconv.Atoi(id)
+	if err := checkEmail(email); err != nil {
+		renderAdminForm(w, r, models.User{ID: userID, Username: username, Email: email, Role: role, MustChangePassword: mustChange}, err.Error())
+		return
+	}
+
+	user := models.User{ID: userID, Username: username, Email: email, Role: role, MustChangePassword: mustChange}
 	if password != "" {
+		if policyErr := checkPassword(username, password); policyErr != nil {
+			renderAdminForm(w, r, user, policyErr.Error())
+			return
+		}
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 		if err != nil {
-			http.Error(w, "Server error", http.StatusInternalServerError)
+			serverError(w, r, err)
 			return
 		}
-		_, err = database.DB.Exec("UPDATE users SET username = ?, password = ? WHERE id = ?", username, hashedPassword, id)
-	} else {
-		_, err = database.DB.Exec("UPDATE users SET username = ? WHERE id = ?", username, id)
+		user.Password = string(hashedPassword)
+	}
+
+	// The store refuses to demote the last active superadmin
+	err := a.Users.Update(user)
+	if ruleErr := accountRuleError(err); ruleErr != nil {
+		user.Password = ""
+		renderAdminForm(w, r, user, ruleErr.Error())
+		return
+	}
+	if err == nil && password != "" {
+		// A new password logs the user out everywhere
+		err = a.Sessions.RevokeUser(userID, "")
 	}
 
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
 
@@ -515,37 +586,82 @@ func AdminUpdateHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // AdminDeleteHandler deletes an admin
-func AdminDeleteHandler(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	_, err := database.DB.Exec("DELETE FROM users WHERE id = ?", id)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+func (a *App) AdminDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	if err := checkAccountChange(currentClaims(r).UserID, userID, accountChange{Delete: true}); err != nil {
+		accountChangeError(w, r, err)
+		return
+	}
+
+	if err := a.Users.Delete(userID); err != nil {
+		accountChangeError(w, r, err)
 		return
 	}
 	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
 
 // AdminToggleStatusHandler toggles the active status of an admin
-func AdminToggleStatusHandler(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
+func (a *App) AdminToggleStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.URL.Query().Get("id"))
 
-	var currentStatus bool
-	err := database.DB.QueryRow("SELECT is_active FROM users WHERE id = ?", id).Scan(&currentStatus)
+	user, err := a.Users.Get(userID)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "User not found", http.StatusNotFound)
+		slog.WarnContext(r.Context(), "loading user", "err", err)
+		httpError(w, r, http.StatusNotFound, "User not found")
 		return
 	}
 
-	newStatus := !currentStatus // Toggle the status
+	newStatus := !user.IsActive // Toggle the status
 
-	_, err = database.DB.Exec("UPDATE users SET is_active = ? WHERE id = ?", newStatus, id)
+	if err := checkAccountChange(currentClaims(r).UserID, userID, accountChange{Active: newStatus}); err != nil {
+		accountChangeError(w, r, err)
+		return
+	}
+
+	err = a.Users.SetActive(userID, newStatus)
+	if err == nil && !newStatus {
+		// Deactivated accounts lose their sessions right away
+		err = a.Sessions.RevokeUser(userID, "")
+	}
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		accountChangeError(w, r, err)
 		return
 	}
 
 	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
+
+// LoginAttemptsHandler lists the latest failed login attempts
+func (a *App) LoginAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("username")
+	latest, err := a.LoginAttempts.List(username, 200)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	type attemptRow struct {
+		models.LoginAttempt
+		ReasonLabel string
+	}
+
+	var attempts []attemptRow
+	for _, attempt := range latest {
+		attempts = append(attempts, attemptRow{LoginAttempt: attempt, ReasonLabel: loginReasonLabels[attempt.Reason]})
+	}
+
+	tmpl, err := parseTemplates(r, "login_attempts.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	data := struct {
+		Username string
+		Attempts []attemptRow
+	}{
+		Username: username,
+		Attempts: attempts,
+	}
+	tmpl.Execute(w, data)
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import "posadas-sistema/store"
+
+// App carries the stores of registrations, events, attendance, groups,
+// users, sessions and login attempts. Its handlers and AuthMiddleware read
+// and write those through the stores, so they can run against store.Memory.
+type App struct {
+	store.Stores
+}
+
+// NewApp returns the handlers backed by stores
+func NewApp(stores store.Stores) *App {
+	return &App{Stores: stores}
+}
@@ -0,0 +1,575 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"posadas-sistema/models"
+	"posadas-sistema/store"
+)
+
+// newTestApp returns handlers backed by an empty store.Memory, with the
+// templates and static files read from the repository
+func newTestApp(t *testing.T) (*App, *store.Memory) {
+	t.Helper()
+	oldPages, oldAssets := pages, assets
+	pages = &templateSet{files: os.DirFS("../templates")}
+	assets = &assetSet{files: os.DirFS("../static")}
+	t.Cleanup(func() { pages, assets = oldPages, oldAssets })
+
+	mem := store.NewMemory()
+	return NewApp(mem.Stores()), mem
+}
+
+// addUser creates an active account with password
+func addUser(t *testing.T, a *App, username, password, role string) models.User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Username: username, Password: string(hash), IsActive: true, Role: role}
+	if err := a.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// addSession opens a session of user that expires in an hour
+func addSession(t *testing.T, a *App, user models.User, id string) {
+	t.Helper()
+	now := time.Now()
+	err := a.Sessions.Create(models.Session{ID: id, UserID: user.ID, CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// activeSessions returns the IDs of the open sessions of user
+func activeSessions(t *testing.T, a *App, user models.User) []string {
+	t.Helper()
+	sessions, err := a.Sessions.Active(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+	}
+	return ids
+}
+
+// as returns r made by user, in session sessionID
+func as(r *http.Request, user models.User, sessionID string) *http.Request {
+	claims := &Claims{UserID: user.ID, Username: user.Username, Role: user.Role}
+	claims.ID = sessionID
+	return withClaims(r, claims)
+}
+
+func postForm(target string, form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestEventGroupsAreSaved(t *testing.T) {
+	a, mem := newTestApp(t)
+	coro := models.Group{Name: "Coro", Year: 2025}
+	musicos := models.Group{Name: "Músicos", Year: 2025}
+	mem.AddGroup(&coro)
+	mem.AddGroup(&musicos)
+
+	form := url.Values{
+		"name":     {"Ensayo general"},
+		"type":     {"ensayo"},
+		"date":     {"2025-12-10"},
+		"group_id": {strconv.Itoa(coro.ID), "not-a-number"},
+	}
+	w := httptest.NewRecorder()
+	a.EventStoreHandler(w, postForm("/admin/events/store", form))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("store: status %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	events, err := a.Events.List()
+	if err != nil || len(events) != 1 {
+		t.Fatalf("got %d events (%v), want 1", len(events), err)
+	}
+	event := events[0]
+	selected, err := a.Groups.ForEvent(event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 1 || !selected[coro.ID] {
+		t.Errorf("groups after store: %v, want only %d", selected, coro.ID)
+	}
+
+	form.Set("id", strconv.Itoa(event.ID))
+	form["group_id"] = []string{strconv.Itoa(musicos.ID)}
+	w = httptest.NewRecorder()
+	a.EventUpdateHandler(w, postForm("/admin/events/update", form))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("update: status %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	selected, err = a.Groups.ForEvent(event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 1 || !selected[musicos.ID] {
+		t.Errorf("groups after update: %v, want only %d", selected, musicos.ID)
+	}
+}
+
+func TestAttendanceHandlerFiltersByGroup(t *testing.T) {
+	a, mem := newTestApp(t)
+	event := models.Event{Name: "Salida", Type: "salida", Date: time.Date(2025, 12, 16, 0, 0, 0, 0, time.UTC)}
+	if err := a.Events.Create(&event, nil); err != nil {
+		t.Fatal(err)
+	}
+	coro := models.Group{Name: "Coro", Year: 2025}
+	mem.AddGroup(&coro)
+	ana := models.Registration{Name: "Ana Torres", Year: 2025}
+	beto := models.Registration{Name: "Beto Ruiz", Year: 2025}
+	for _, reg := range []*models.Registration{&ana, &beto} {
+		if err := a.Registrations.Create(reg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mem.GroupMembers[coro.ID] = []int{ana.ID}
+
+	target := "/admin/attendance?event_id=" + strconv.Itoa(event.ID) + "&group_id=" + strconv.Itoa(coro.ID)
+	w := httptest.NewRecorder()
+	a.AttendanceHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Ana Torres") {
+		t.Error("group member missing")
+	}
+	if strings.Contains(body, "Beto Ruiz") {
+		t.Error("registration outside the group listed")
+	}
+}
+
+func TestDashboardDataGroupAttendance(t *testing.T) {
+	a, mem := newTestApp(t)
+	coro := models.Group{Name: "Coro", Year: 2025}
+	mem.AddGroup(&coro)
+
+	var members []int
+	for _, name := range []string{"Ana", "Beto"} {
+		reg := models.Registration{Name: name, Year: 2025}
+		if err := a.Registrations.Create(&reg); err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, reg.ID)
+	}
+	mem.GroupMembers[coro.ID] = members
+
+	// Only the first event is of the group's season and targeted to it
+	var events []int
+	for _, date := range []time.Time{
+		time.Date(2025, 12, 16, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 12, 17, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 12, 16, 0, 0, 0, 0, time.UTC),
+	} {
+		event := models.Event{Name: "Posada", Type: "salida", Date: date}
+		var groups []int
+		if len(events) != 1 {
+			groups = []int{coro.ID}
+		}
+		if err := a.Events.Create(&event, groups); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event.ID)
+		marks := []models.Attendance{{RegistrationID: members[0], Present: true}, {RegistrationID: members[1], Present: false}}
+		if err := a.Attendance.Replace(event.ID, marks); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	a.DashboardDataHandler(w, httptest.NewRequest(http.MethodGet, "/admin/dashboard-data", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusOK)
+	}
+	var data struct {
+		TotalEvents      int `json:"total_events"`
+		TotalAttendances int `json:"total_attendances"`
+		GroupData        []struct {
+			Name        string `json:"name"`
+			Members     int    `json:"members"`
+			Attendances int    `json:"attendances"`
+			Marked      int    `json:"marked"`
+		} `json:"group_data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	if data.TotalEvents != 3 || data.TotalAttendances != 3 {
+		t.Errorf("totals: %d events, %d attendances, want 3 and 3", data.TotalEvents, data.TotalAttendances)
+	}
+	if len(data.GroupData) != 1 {
+		t.Fatalf("got %d groups, want 1", len(data.GroupData))
+	}
+	group := data.GroupData[0]
+	if group.Name != "Coro" || group.Members != 2 || group.Attendances != 1 || group.Marked != 2 {
+		t.Errorf("got %+v, want Coro with 2 members, 1 attendance and 2 marked", group)
+	}
+}
+
+func TestAdminChangesKeepASuperadmin(t *testing.T) {
+	a, _ := newTestApp(t)
+	root := addUser(t, a, "root", "Estrella-de-Belen-7", RoleSuperadmin)
+	other := addUser(t, a, "maria", "Estrella-de-Belen-7", RoleCoordinator)
+	id := "?id=" + strconv.Itoa(root.ID)
+
+	w := httptest.NewRecorder()
+	a.AdminDeleteHandler(w, as(httptest.NewRequest(http.MethodPost, "/admin/users/delete"+id, nil), root, ""))
+	if w.Code != http.StatusConflict {
+		t.Errorf("self delete: status %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	w = httptest.NewRecorder()
+	a.AdminToggleStatusHandler(w, as(httptest.NewRequest(http.MethodPost, "/admin/users/toggle-status"+id, nil), other, ""))
+	if w.Code != http.StatusConflict {
+		t.Errorf("deactivating the last superadmin: status %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	form := url.Values{"id": {strconv.Itoa(root.ID)}, "username": {"root"}, "role": {RoleViewer}}
+	w = httptest.NewRecorder()
+	a.AdminUpdateHandler(w, as(postForm("/admin/users/update", form), other, ""))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("demoting the last superadmin: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if user, _ := a.Users.Get(root.ID); user.Role != RoleSuperadmin || !user.IsActive {
+		t.Fatalf("last superadmin changed: %+v", user)
+	}
+
+	// With a second superadmin the first one can go
+	other.Role = RoleSuperadmin
+	if err := a.Users.Update(other); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	a.AdminDeleteHandler(w, as(httptest.NewRequest(http.MethodPost, "/admin/users/delete"+id, nil), other, ""))
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("delete: status %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if _, err := a.Users.Get(root.ID); err != store.ErrNotFound {
+		t.Errorf("deleted account still there: %v", err)
+	}
+}
+
+func TestAdminChangesRevokeSessions(t *testing.T) {
+	a, _ := newTestApp(t)
+	root := addUser(t, a, "root", "Estrella-de-Belen-7", RoleSuperadmin)
+	user := addUser(t, a, "maria", "Estrella-de-Belen-7", RoleCoordinator)
+
+	// Editing without a new password keeps the sessions
+	addSession(t, a, user, "s1")
+	form := url.Values{"id": {strconv.Itoa(user.ID)}, "username": {"maria"}, "role": {RoleAttendance}}
+	w := httptest.NewRecorder()
+	a.AdminUpdateHandler(w, as(postForm("/admin/users/update", form), root, ""))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("update: status %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if got := activeSessions(t, a, user); len(got) != 1 {
+		t.Errorf("sessions after editing the role: %v, want [s1]", got)
+	}
+
+	form.Set("password", "Pastorela-en-la-Plaza-9")
+	w = httptest.NewRecorder()
+	a.AdminUpdateHandler(w, as(postForm("/admin/users/update", form), root, ""))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("update with password: status %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if got := activeSessions(t, a, user); len(got) != 0 {
+		t.Errorf("sessions after a new password: %v, want none", got)
+	}
+
+	addSession(t, a, user, "s2")
+	w = httptest.NewRecorder()
+	a.AdminToggleStatusHandler(w, as(httptest.NewRequest(http.MethodPost, "/admin/users/toggle-status?id="+strconv.Itoa(user.ID), nil), root, ""))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("toggle: status %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if got := activeSessions(t, a, user); len(got) != 0 {
+		t.Errorf("sessions after deactivating: %v, want none", got)
+	}
+}
+
+func TestLoginHandler(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := addUser(t, a, "login-test", "Estrella-de-Belen-7", RoleCoordinator)
+	inactive := addUser(t, a, "login-inactive", "Estrella-de-Belen-7", RoleViewer)
+	if err := a.Users.SetActive(inactive.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	weak := addUser(t, a, "login-weak", "posadas", RoleViewer)
+
+	login := func(username, password string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		a.LoginHandler(w, postForm("/login", url.Values{"username": {username}, "password": {password}}))
+		return w
+	}
+
+	failures := []struct {
+		username, password, reason string
+	}{
+		{"login-test", "wrong password", loginReasonBadPassword},
+		{"login-nobody", "Estrella-de-Belen-7", loginReasonUnknownUser},
+		{"login-inactive", "Estrella-de-Belen-7", loginReasonInactive},
+	}
+	for _, tt := range failures {
+		if w := login(tt.username, tt.password); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want %d", tt.reason, w.Code, http.StatusUnauthorized)
+		}
+		attempts, err := a.LoginAttempts.List(tt.username, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(attempts) != 1 || attempts[0].Reason != tt.reason {
+			t.Errorf("%s: recorded %+v", tt.reason, attempts)
+		}
+	}
+
+	w := login("login-test", "Estrella-de-Belen-7")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/dashboard" {
+		t.Fatalf("login: status %d to %q, want %d to the dashboard", w.Code, w.Header().Get("Location"), http.StatusSeeOther)
+	}
+	if len(w.Result().Cookies()) == 0 {
+		t.Error("no session cookie")
+	}
+	if got := activeSessions(t, a, user); len(got) != 1 {
+		t.Errorf("sessions after login: %v, want one", got)
+	}
+
+	// A password from before the policy logs in but must be changed
+	if w := login("login-weak", "posadas"); w.Code != http.StatusSeeOther {
+		t.Fatalf("weak password login: status %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if got, _ := a.Users.Get(weak.ID); !got.MustChangePassword {
+		t.Error("weak password not flagged for change")
+	}
+}
+
+func TestLoginAttemptsHandlerFiltersByUsername(t *testing.T) {
+	a, _ := newTestApp(t)
+	for _, username := range []string{"ana", "beto", "ana"} {
+		if err := a.LoginAttempts.Record(models.LoginAttempt{Username: username, IP: "192.0.2.1", Reason: loginReasonBadPassword}, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	a.LoginAttemptsHandler(w, httptest.NewRequest(http.MethodGet, "/admin/users/login-attempts?username=ana", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+	if n := strings.Count(body, loginReasonLabels[loginReasonBadPassword]); n != 2 {
+		t.Errorf("%d attempts listed, want 2", n)
+	}
+	if strings.Contains(body, "beto") {
+		t.Error("attempt of another username listed")
+	}
+}
+
+func TestChangePasswordHandler(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := addUser(t, a, "maria", "Estrella-de-Belen-7", RoleCoordinator)
+	user.MustChangePassword = true
+	if err := a.Users.Update(user); err != nil {
+		t.Fatal(err)
+	}
+	addSession(t, a, user, "here")
+	addSession(t, a, user, "elsewhere")
+
+	change := func(current, password string) *httptest.ResponseRecorder {
+		t.Helper()
+		form := url.Values{"current_password": {current}, "password": {password}, "password_confirm": {password}}
+		w := httptest.NewRecorder()
+		a.ChangePasswordHandler(w, as(postForm(changePasswordPath, form), user, "here"))
+		return w
+	}
+
+	if w := change("not my password", "Pastorela-en-la-Plaza-9"); w.Code != http.StatusBadRequest {
+		t.Errorf("wrong current password: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := change("Estrella-de-Belen-7", "password123"); w.Code != http.StatusBadRequest {
+		t.Errorf("common password: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w := change("Estrella-de-Belen-7", "Pastorela-en-la-Plaza-9")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/dashboard" {
+		t.Fatalf("change: status %d to %q, want %d to the dashboard", w.Code, w.Header().Get("Location"), http.StatusSeeOther)
+	}
+	hash, err := a.Users.PasswordHash(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte("Pastorela-en-la-Plaza-9")) != nil {
+		t.Error("new password not saved")
+	}
+	if got, _ := a.Users.Get(user.ID); got.MustChangePassword {
+		t.Error("must-change flag not cleared")
+	}
+	if got := activeSessions(t, a, user); len(got) != 1 || got[0] != "here" {
+		t.Errorf("sessions after the change: %v, want [here]", got)
+	}
+}
+
+// loginCookie opens a session of user and returns its cookie
+func loginCookie(t *testing.T, a *App, user models.User) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	a.issueSession(w, httptest.NewRequest(http.MethodPost, "/login", nil), user)
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "jwt_token" {
+			return c
+		}
+	}
+	t.Fatalf("no session cookie, status %d", w.Code)
+	return nil
+}
+
+// withCookie returns a GET request of target carrying c
+func withCookie(target string, c *http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.AddCookie(c)
+	return r
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	a, _ := newTestApp(t)
+	// With 2FA already enabled the middleware doesn't look up whether it's
+	// required
+	user := models.User{Username: "maria", IsActive: true, Role: RoleCoordinator, TOTPEnabled: true}
+	if err := a.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	cookie := loginCookie(t, a, user)
+
+	var seen *Claims
+	next := func(w http.ResponseWriter, r *http.Request) { seen = currentClaims(r) }
+	serve := func(h http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+		seen = nil
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	if w := serve(a.AuthMiddleware(next), httptest.NewRequest(http.MethodGet, "/admin/dashboard", nil)); w.Code != http.StatusSeeOther || seen != nil {
+		t.Errorf("no cookie: status %d, want a redirect to /login", w.Code)
+	}
+	if w := serve(a.Require(PermManage, next), withCookie("/admin/events", cookie)); seen == nil || seen.Role != RoleCoordinator {
+		t.Fatalf("valid session: status %d, claims %+v", w.Code, seen)
+	}
+
+	// The stored role wins over the one in the token
+	user.Role = RoleViewer
+	if err := a.Users.Update(user); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(a.Require(PermManage, next), withCookie("/admin/events", cookie)); w.Code != http.StatusForbidden || seen != nil {
+		t.Errorf("demoted user: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	// A session unused for a while is touched
+	sessions, err := a.Sessions.Active(user.ID)
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("got %d sessions (%v), want 1", len(sessions), err)
+	}
+	id := sessions[0].ID
+	if err := a.Sessions.Touch(id, "192.0.2.1", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	serve(a.AuthMiddleware(next), withCookie("/admin/dashboard", cookie))
+	if state, err := a.Sessions.Lookup(id); err != nil || time.Since(state.LastSeenAt) > time.Minute {
+		t.Errorf("session not touched: %+v (%v)", state, err)
+	}
+
+	// Deactivating the account locks it out right away
+	if err := a.Users.SetActive(user.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(a.AuthMiddleware(next), withCookie("/admin/dashboard", cookie)); w.Code != http.StatusSeeOther || seen != nil {
+		t.Errorf("inactive user: status %d, want a redirect to /login", w.Code)
+	}
+}
+
+func TestLogoutHandlerRevokesTheSession(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := models.User{Username: "maria", IsActive: true, Role: RoleViewer, TOTPEnabled: true}
+	if err := a.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	cookie := loginCookie(t, a, user)
+
+	w := httptest.NewRecorder()
+	a.LogoutHandler(w, withCookie("/logout", cookie))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("logout: status %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if got := activeSessions(t, a, user); len(got) != 0 {
+		t.Errorf("sessions after logging out: %v, want none", got)
+	}
+
+	called := false
+	w = httptest.NewRecorder()
+	a.AuthMiddleware(func(http.ResponseWriter, *http.Request) { called = true })(w, withCookie("/admin/dashboard", cookie))
+	if called {
+		t.Error("the token of a logged-out session still works")
+	}
+}
+
+func TestSessionRevokeHandler(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := addUser(t, a, "maria", "Estrella-de-Belen-7", RoleViewer)
+	other := addUser(t, a, "jose", "Estrella-de-Belen-7", RoleViewer)
+	addSession(t, a, user, "current")
+	addSession(t, a, user, "laptop")
+	addSession(t, a, other, "theirs")
+
+	revoke := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		a.SessionRevokeHandler(w, as(httptest.NewRequest(http.MethodPost, "/admin/sessions/revoke?id="+id, nil), user, "current"))
+		return w
+	}
+
+	if w := revoke("theirs"); w.Code != http.StatusNotFound {
+		t.Errorf("someone else's session: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if got := activeSessions(t, a, other); len(got) != 1 {
+		t.Errorf("the other user's sessions: %v, want [theirs]", got)
+	}
+	if w := revoke("missing"); w.Code != http.StatusNotFound {
+		t.Errorf("missing session: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if w := revoke("laptop"); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/sessions" {
+		t.Errorf("other session: status %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if got := activeSessions(t, a, user); len(got) != 1 || got[0] != "current" {
+		t.Errorf("sessions left: %v, want [current]", got)
+	}
+
+	if w := revoke("current"); w.Header().Get("Location") != "/login" {
+		t.Errorf("current session: redirected to %q, want /login", w.Header().Get("Location"))
+	}
+	if got := activeSessions(t, a, user); len(got) != 0 {
+		t.Errorf("sessions left: %v, want none", got)
+	}
+}
@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"posadas-sistema/database"
+	"posadas-sistema/models"
+)
+
+// auditRedacted lists the columns never copied into the audit log. When
+// one of them changes the entry says so without showing the values.
+var auditRedacted = map[string]bool{
+	"password":    true,
+	"totp_secret": true,
+	"code_hash":   true,
+}
+
+const createdContextKey contextKey = "created"
+
+// createdRows collects the IDs a create handler wrapped in Audit reports
+type createdRows struct {
+	ids []int64
+}
+
+// reportCreated tells Audit, when the request goes through it, the id of a
+// row the handler just inserted
+func reportCreated(r *http.Request, id int64) {
+	if created, ok := r.Context().Value(createdContextKey).(*createdRows); ok {
+		created.ids = append(created.ids, id)
+	}
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// auditRow is a row of an audited table, column by column
+type auditRow map[string]interface{}
+
+// snapshotRows returns the rows of table where column equals value. table
+// and column always come from the code.
+func snapshotRows(table, column string, value interface{}) ([]auditRow, error) {
+	rows, err := database.DB.Query("SELECT * FROM "+table+" WHERE "+column+" = ? ORDER BY 1", value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []auditRow
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		row := auditRow{}
+		for i, name := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[name] = values[i]
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// redactRows removes the secret columns from the snapshots. A secret that
+// changed, or was set on a new row, stays in after as "(changed)".
+func redactRows(before, after []auditRow) {
+	previous := map[string]auditRow{}
+	for _, row := range before {
+		previous[toString(row["id"])] = row
+	}
+	for _, row := range after {
+		old := previous[toString(row["id"])]
+		for name := range auditRedacted {
+			value, ok := row[name]
+			if !ok {
+				continue
+			}
+			unset := value == nil || value == ""
+			if reflect.DeepEqual(old[name], value) || old == nil && unset {
+				delete(row, name)
+			} else {
+				row[name] = "(changed)"
+			}
+		}
+	}
+	for _, row := range before {
+		for name := range auditRedacted {
+			delete(row, name)
+		}
+	}
+}
+
+// auditJSON encodes snapshot rows for the audit log, or "" if there are none
+func auditJSON(rows []auditRow) string {
+	if len(rows) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// recordAudit appends an entry to the audit log on behalf of the logged-in
+// user
+func recordAudit(r *http.Request, action, entity, entityID, before, after string) {
+	var userID int
+	username := ""
+	if claims := currentClaims(r); claims != nil {
+		userID = claims.UserID
+		username = claims.Username
+	}
+	appendAudit(r.Context(), userID, username, clientIP(r), action, entity, entityID, before, after)
+}
+
+// appendAudit appends an entry to the audit log
+func appendAudit(ctx context.Context, userID int, username, ip, action, entity, entityID, before, after string) {
+	_, err := database.DB.Exec("INSERT INTO audit_log (user_id, username, action, entity, entity_id, before_json, after_json, ip) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, username, action, entity, entityID, before, after, ip)
+	if err != nil {
+		slog.ErrorContext(ctx, "writing audit entry", "err", err)
+	}
+}
+
+// Audit records what a mutating handler changed in table. The affected rows
+// are those whose column key equals the request's form value of the same
+// name. With an empty key the handler creates rows and reports their IDs
+// with reportCreated. Nothing is recorded when the handler fails or changes
+// nothing.
+func Audit(action, table, key string, next http.HandlerFunc) http.HandlerFunc {
+	if key == "" {
+		return auditCreate(action, table, next)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		auditChange(w, r, action, table, key, r.FormValue(key), next)
+	}
+}
+
+// AuditSelf records what a handler changed in the rows of table whose
+// column equals the logged-in user's ID
+func AuditSelf(action, table, column string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auditChange(w, r, action, table, column, currentClaims(r).UserID, next)
+	}
+}
+
+func auditChange(w http.ResponseWriter, r *http.Request, action, table, column string, value interface{}, next http.HandlerFunc) {
+	before, err := snapshotRows(table, column, value)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "audit: snapshot before", "table", table, "err", err)
+	}
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next(rec, r)
+	if rec.status >= 400 {
+		return
+	}
+
+	after, err := snapshotRows(table, column, value)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "audit: snapshot after", "table", table, "err", err)
+	}
+	// Compared before redacting, so a change to a secret alone still counts
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+	redactRows(before, after)
+	recordAudit(r, action, table, toString(value), auditJSON(before), auditJSON(after))
+}
+
+// auditCreate records the rows of table that next reports with
+// reportCreated
+func auditCreate(action, table string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		created := &createdRows{}
+		r = r.WithContext(context.WithValue(r.Context(), createdContextKey, created))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		if rec.status >= 400 || len(created.ids) == 0 {
+			return
+		}
+
+		var after []auditRow
+		for _, id := range created.ids {
+			rows, err := snapshotRows(table, "id", id)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "audit: snapshot after", "table", table, "err", err)
+			}
+			after = append(after, rows...)
+		}
+		redactRows(nil, after)
+		recordAudit(r, action, table, toString(created.ids[0]), "", auditJSON(after))
+	}
+}
+
+// toString formats an ID value for the entity_id column
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// auditFilter is the filter of the audit viewer and CSV export
+type auditFilter struct {
+	Username string
+	Entity   string
+	Action   string
+	From     string // YYYY-MM-DD
+	To       string // YYYY-MM-DD
+}
+
+func auditFilterParams(r *http.Request) auditFilter {
+	q := r.URL.Query()
+	return auditFilter{
+		Username: q.Get("username"),
+		Entity:   q.Get("entity"),
+		Action:   q.Get("action"),
+		From:     q.Get("from"),
+		To:       q.Get("to"),
+	}
+}
+
+// queryAudit returns the audit entries matching the filter, newest first
+func queryAudit(f auditFilter, limit int) ([]models.AuditEntry, error) {
+	query := "SELECT id, created_at, user_id, username, action, entity, entity_id, COALESCE(before_json, ''), COALESCE(after_json, ''), COALESCE(ip, '') FROM audit_log WHERE 1 = 1"
+	var args []interface{}
+	if f.Username != "" {
+		query += " AND username = ?"
+		args = append(args, f.Username)
+	}
+	if f.Entity != "" {
+		query += " AND entity = ?"
+		args = append(args, f.Entity)
+	}
+	if f.Action != "" {
+		query += " AND action = ?"
+		args = append(args, f.Action)
+	}
+	if f.From != "" {
+		query += " AND date(created_at) >= ?"
+		args = append(args, f.From)
+	}
+	if f.To != "" {
+		query += " AND date(created_at) <= ?"
+		args = append(args, f.To)
+	}
+	query += " ORDER BY id DESC"
+	if limit > 0 {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var e models.AuditEntry
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.UserID, &e.Username, &e.Action, &e.Entity, &e.EntityID, &e.Before, &e.After, &e.IP); err != nil {
+			return nil, err
+		}
+		e.CreatedAt = e.CreatedAt.In(location)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// distinctAuditValues returns the values of a column present in the log
+func distinctAuditValues(column string) ([]string, error) {
+	rows, err := database.DB.Query("SELECT DISTINCT " + column + " FROM audit_log ORDER BY " + column)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v sql.NullString
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v.String)
+	}
+	return values, rows.Err()
+}
+
+// AuditListHandler shows the audit log with filters
+func AuditListHandler(w http.ResponseWriter, r *http.Request) {
+	filter := auditFilterParams(r)
+	entries, err := queryAudit(filter, 500)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	entities, err := distinctAuditValues("entity")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	actions, err := distinctAuditValues("action")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, "audit_list.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	data := struct {
+		Filter   auditFilter
+		Entries  []models.AuditEntry
+		Entities []string
+		Actions  []string
+		Query    string
+	}{
+		Filter:   filter,
+		Entries:  entries,
+		Entities: entities,
+		Actions:  actions,
+		Query:    r.URL.RawQuery,
+	}
+	tmpl.Execute(w, data)
+}
+
+// AuditExportHandler downloads the filtered audit log as CSV
+func AuditExportHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := queryAudit(auditFilterParams(r), 0)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="auditoria-`+time.Now().In(location).Format("20060102-1504")+`.csv"`)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "fecha", "usuario_id", "usuario", "accion", "entidad", "entidad_id", "antes", "despues", "ip"})
+	for _, e := range entries {
+		out.Write([]string{
+			strconv.Itoa(e.ID),
+			e.CreatedAt.Format(time.RFC3339),
+			strconv.Itoa(e.UserID),
+			e.Username,
+			e.Action,
+			e.Entity,
+			e.EntityID,
+			e.Before,
+			e.After,
+			e.IP,
+		})
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		slog.ErrorContext(r.Context(), "writing audit export", "err", err)
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"posadas-sistema/database"
+	"posadas-sistema/models"
+)
+
+// auditEntries returns the audit log, oldest first
+func auditEntries(t *testing.T) []models.AuditEntry {
+	t.Helper()
+	entries, err := queryAudit(auditFilter{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+func TestAuditCreateRecordsTheReportedRows(t *testing.T) {
+	openTestDB(t)
+	admin := models.User{ID: 1, Username: "ana", Role: RoleCoordinator}
+	create := Audit("create", "hosts", "", HostStoreHandler)
+
+	// Concurrent creates each record their own row
+	const hosts = 5
+	var wg sync.WaitGroup
+	for i := 0; i < hosts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			form := url.Values{"family_name": {"Familia " + string(rune('A'+i))}}
+			create(httptest.NewRecorder(), as(postForm("/admin/hosts/store", form), admin, ""))
+		}()
+	}
+	wg.Wait()
+
+	entries := auditEntries(t)
+	if len(entries) != hosts {
+		t.Fatalf("got %d audit entries, want %d", len(entries), hosts)
+	}
+	for _, entry := range entries {
+		var familyName string
+		if err := database.DB.QueryRow("SELECT family_name FROM hosts WHERE id = ?", entry.EntityID).Scan(&familyName); err != nil {
+			t.Fatalf("entry %s: %v", entry.EntityID, err)
+		}
+		if entry.Action != "create" || entry.Username != "ana" || entry.Before != "" || !strings.Contains(entry.After, `"family_name":"`+familyName+`"`) {
+			t.Errorf("entry for %s: %+v", familyName, entry)
+		}
+	}
+
+	// A handler that fails or reports nothing leaves no entry
+	Audit("create", "hosts", "", func(w http.ResponseWriter, r *http.Request) {})(httptest.NewRecorder(), as(postForm("/", nil), admin, ""))
+	Audit("create", "hosts", "", func(w http.ResponseWriter, r *http.Request) {
+		reportCreated(r, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	})(httptest.NewRecorder(), as(postForm("/", nil), admin, ""))
+	if got := len(auditEntries(t)); got != hosts {
+		t.Errorf("got %d audit entries, want still %d", got, hosts)
+	}
+}
+
+func TestAuditChangeRecordsBeforeAndAfter(t *testing.T) {
+	openTestDB(t)
+	admin := models.User{ID: 1, Username: "ana", Role: RoleCoordinator}
+	id, err := database.InsertID(database.DB, "INSERT INTO hosts (family_name, contact_name, phone, address, notes) VALUES ('Pérez', '', '', '', '')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rename := Audit("update", "hosts", "id", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := database.DB.Exec("UPDATE hosts SET family_name = ? WHERE id = ?", r.FormValue("family_name"), r.FormValue("id")); err != nil {
+			t.Fatal(err)
+		}
+	})
+	form := url.Values{"id": {toString(id)}, "family_name": {"Pérez"}}
+	rename(httptest.NewRecorder(), as(postForm("/admin/hosts/update", form), admin, ""))
+	if got := len(auditEntries(t)); got != 0 {
+		t.Fatalf("unchanged row: got %d audit entries, want none", got)
+	}
+
+	form.Set("family_name", "López")
+	rename(httptest.NewRecorder(), as(postForm("/admin/hosts/update", form), admin, ""))
+	entries := auditEntries(t)
+	if len(entries) != 1 {
+		t.Fatalf("got %d audit entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.EntityID != toString(id) || !strings.Contains(entry.Before, "Pérez") || !strings.Contains(entry.After, "López") {
+		t.Errorf("got %+v", entry)
+	}
+}
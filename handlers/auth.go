@@ -1,106 +1,177 @@
 package handlers
 
 import (
-	"database/sql"
-	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"posadas-sistema/database"
 	"posadas-sistema/models"
+	"posadas-sistema/store"
 
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var jwtSecret = []byte("mi_clave_secreta_super_larga_y_compleja_para_jwt")
-
 // Claims is a struct that will be encoded to a JWT.
 // We add `jwtRegisteredClaims` which is a jwt.RegisteredClaims that contains standard claims.
 type Claims struct {
 	UserID   int    `json:"user_id"`
 	Username string `json:"username"`
+	Role     string `json:"role"`
 	jwt.RegisteredClaims
 }
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// dummyPasswordHash returns a bcrypt hash to compare against when the
+// username doesn't exist
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("posadas-dummy-password"), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
+func (a *App) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		username := r.FormValue("username")
 		password := r.FormValue("password")
+		ip := clientIP(r)
 
-		var user models.User
-		var isActive bool
-		err := database.DB.QueryRow("SELECT id, username, password, is_active FROM users WHERE username = ?", username).Scan(&user.ID, &user.Username, &user.Password, &isActive)
-		if err == sql.ErrNoRows {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		// Attempts made while locked are only counted, so a flood of them
+		// doesn't fill the login_attempts table
+		if wait := logins.retryAfter(ip, username); wait > 0 {
+			failedLogins.Inc(loginReasonLocked)
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			httpError(w, r, http.StatusTooManyRequests, "Too many login attempts, try again later")
 			return
+		}
+
+		reason := ""
+		user, err := a.Users.FindByUsername(username)
+		if err == store.ErrNotFound {
+			// Compare anyway so unknown usernames take as long as known ones
+			user.Password = string(dummyPasswordHash())
+			reason = loginReasonUnknownUser
 		} else if err != nil {
-			http.Error(w, "Server error", http.StatusInternalServerError)
-			log.Println(err)
+			serverError(w, r, err)
 			return
 		}
 
-		user.IsActive = isActive
-		if !user.IsActive {
-			http.Error(w, "Account is inactive", http.StatusUnauthorized)
-			return
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil && reason == "" {
+			reason = loginReasonBadPassword
+		}
+		if reason == "" && !user.IsActive {
+			reason = loginReasonInactive
 		}
 
-		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
-		if err != nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		// Every failure gets the same answer so the response doesn't reveal
+		// which accounts exist or are inactive
+		if reason != "" {
+			logins.fail(ip, username)
+			a.recordFailedLogin(r, username, reason)
+			httpError(w, r, http.StatusUnauthorized, "Invalid credentials")
 			return
 		}
-
-		// Create JWT Token
-		expirationTime := time.Now().Add(24 * time.Hour)
-		claims := &Claims{
-			UserID:   user.ID,
-			Username: user.Username,
-			RegisteredClaims: jwt.RegisteredClaims{
-				ExpiresAt: jwt.NewNumericDate(expirationTime),
-				IssuedAt:  jwt.NewNumericDate(time.Now()),
-				NotBefore: jwt.NewNumericDate(time.Now()),
-			},
+		// Passwords set before the policy existed are replaced on first use
+		if checkPassword(user.Username, password) != nil {
+			if err := a.Users.RequirePasswordChange(user.ID); err != nil {
+				slog.ErrorContext(r.Context(), "flagging password for change", "user", user.Username, "err", err)
+			}
 		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		tokenString, err := token.SignedString(jwtSecret)
-		if err != nil {
-			http.Error(w, "Server error", http.StatusInternalServerError)
+		// Accounts with 2FA get a second step before the session is issued
+		if user.TOTPEnabled {
+			startTOTPChallenge(w, r, user)
 			return
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:    "jwt_token",
-			Value:   tokenString,
-			Expires: expirationTime,
-		})
-
-		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		logins.succeed(ip, username)
+		a.issueSession(w, r, user)
 		return
 	}
 	// Render login template
-	tmpl, err := template.ParseFiles("templates/base.html", "templates/login.html")
+	tmpl, err := parseTemplates(r, "login.html")
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
-	tmpl.Execute(w, nil)
+	data := struct {
+		Reset bool
+	}{
+		Reset: r.URL.Query().Get("reset") == "1",
+	}
+	tmpl.Execute(w, data)
 }
 
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+// issueSession signs the session token of user, sets the cookie and sends
+// them to the dashboard
+func (a *App) issueSession(w http.ResponseWriter, r *http.Request, user models.User) {
+	expirationTime := time.Now().Add(sessionTTL)
+	sessionID, err := a.createSession(r, user.ID, expirationTime)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	claims := &Claims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString(jwtSecret)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
 	http.SetCookie(w, &http.Cookie{
-		Name:    "jwt_token",
-		Value:   "",
-		Expires: time.Now().Add(-1 * time.Hour),
+		Name:     "jwt_token",
+		Value:    tokenString,
+		Path:     "/",
+		Domain:   cookieDomain,
+		Expires:  expirationTime,
+		HttpOnly: true,
+		Secure:   cookieSecure,
+		SameSite: http.SameSiteLaxMode,
 	})
+
+	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+}
+
+// LogoutHandler ends the session on the server too, so a copy of the token
+// is useless
+func (a *App) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie("jwt_token"); err == nil {
+		claims := &Claims{}
+		_, err := jwt.ParseWithClaims(c.Value, claims, func(token *jwt.Token) (interface{}, error) {
+			return jwtSecret, nil
+		})
+		if err == nil && claims.ID != "" {
+			if err := a.Sessions.Revoke(claims.ID); err != nil {
+				slog.ErrorContext(r.Context(), "revoking session", "err", err)
+			}
+		}
+	}
+	clearSessionCookie(w)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+func (a *App) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		c, err := r.Cookie("jwt_token")
 		if err != nil {
@@ -129,19 +200,121 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		// Verify user is still active in the database
-		var isActive bool
-		err = database.DB.QueryRow("SELECT is_active FROM users WHERE id = ?", claims.UserID).Scan(&isActive)
-		if err != nil {
-			http.Error(w, "Server error", http.StatusInternalServerError)
+		// Verify the session hasn't been revoked and the user is still
+		// active. The stored role wins over the one in the token so role
+		// changes apply immediately.
+		session, err := a.Sessions.Lookup(claims.ID)
+		if err == store.ErrNotFound || (err == nil && session.UserID != claims.UserID) {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			serverError(w, r, err)
 			return
 		}
 
-		if !isActive {
+		user := session.User
+		if !user.IsActive || session.Revoked || time.Now().After(session.ExpiresAt) {
+			clearSessionCookie(w)
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
+		claims.Role = user.Role
+		a.touchSession(r, session.Session)
+
+		// Until the password is changed, the change-password page is the
+		// only one available
+		if user.MustChangePassword && r.URL.Path != changePasswordPath {
+			http.Redirect(w, r, changePasswordPath, http.StatusSeeOther)
+			return
+		}
+
+		// Same for 2FA enrollment when a superadmin requires it for everyone
+		if !user.TOTPEnabled && !user.MustChangePassword && !strings.HasPrefix(r.URL.Path, twoFactorPath) && require2FA(r.Context()) {
+			http.Redirect(w, r, twoFactorPath, http.StatusSeeOther)
+			return
+		}
+
+		next(w, withClaims(r, claims))
+	}
+}
+
+const changePasswordPath = "/admin/password"
+
+// ChangePasswordHandler lets the logged-in user change their own password
+func (a *App) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	claims := currentClaims(r)
+
+	user, err := a.Users.Get(claims.UserID)
+	var hash string
+	if err == nil {
+		hash, err = a.Users.PasswordHash(claims.UserID)
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "loading user", "err", err)
+		httpError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+	mustChange := user.MustChangePassword
+
+	data := struct {
+		Username   string
+		MustChange bool
+		MinLength  int
+		Error      string
+		Saved      bool
+	}{
+		Username:   claims.Username,
+		MustChange: mustChange,
+		MinLength:  passwordMinLength,
+		Saved:      r.URL.Query().Get("saved") == "1",
+	}
+
+	if r.Method == http.MethodPost {
+		current := r.FormValue("current_password")
+		password := r.FormValue("password")
+		confirm := r.FormValue("password_confirm")
+
+		switch {
+		case bcrypt.CompareHashAndPassword([]byte(hash), []byte(current)) != nil:
+			data.Error = "La contraseña actual no es correcta."
+		case password != confirm:
+			data.Error = "Las contraseñas nuevas no coinciden."
+		case password == current:
+			data.Error = "La nueva contraseña debe ser distinta de la actual."
+		default:
+			if err := checkPassword(claims.Username, password); err != nil {
+				data.Error = err.Error()
+			}
+		}
+
+		if data.Error == "" {
+			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			if err := a.Users.SetPassword(claims.UserID, string(hashedPassword)); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			// Whoever knew the old password is logged out, except here
+			if err := a.Sessions.RevokeUser(claims.UserID, claims.ID); err != nil {
+				slog.ErrorContext(r.Context(), "revoking sessions", "err", err)
+			}
+			if mustChange {
+				http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+			} else {
+				http.Redirect(w, r, changePasswordPath+"?saved=1", http.StatusSeeOther)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}
 
-		next(w, r)
+	tmpl, err := parseTemplates(r, "change_password.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
 	}
+	tmpl.Execute(w, data)
 }
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"posadas-sistema/backup"
+	"posadas-sistema/database"
+)
+
+// BackupListHandler lists the database snapshots
+func BackupListHandler(w http.ResponseWriter, r *http.Request) {
+	snapshots, err := backups.List()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, "backups.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	data := struct {
+		Snapshots []backup.Snapshot
+		Dir       string
+		Keep      int
+		Encrypted bool
+		Postgres  bool
+		Created   string
+	}{
+		Snapshots: snapshots,
+		Dir:       backups.Dir,
+		Keep:      backups.Keep,
+		Encrypted: backups.Passphrase != "",
+		Postgres:  database.IsPostgres(),
+		Created:   r.URL.Query().Get("created"),
+	}
+	tmpl.Execute(w, data)
+}
+
+// BackupCreateHandler takes a snapshot now
+func BackupCreateHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := backups.Create()
+	if err == backup.ErrUnsupported {
+		httpError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		serverError(w, r, fmt.Errorf("creating backup: %w", err))
+		return
+	}
+	recordAudit(r, "create_backup", "database", snapshot.Name, "", "")
+	http.Redirect(w, r, "/admin/backups?created="+snapshot.Name, http.StatusSeeOther)
+}
+
+// BackupDownloadHandler sends a snapshot as a file download. Encrypted
+// snapshots are sent as they are; restoring them needs the passphrase.
+func BackupDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	path, err := backups.Path(name)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Backup not found")
+		return
+	}
+
+	recordAudit(r, "download_backup", "database", name, "", "")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(path)+`"`)
+	http.ServeFile(w, r, path)
+}
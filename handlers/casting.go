@@ -0,0 +1,562 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"posadas-sistema/database"
+	"posadas-sistema/models"
+)
+
+// rehearsalRate is how many of the marked rehearsals a participant attended
+type rehearsalRate struct {
+	Present int
+	Marked  int
+}
+
+// Percent returns the attendance rate, or 100 when nothing was marked yet
+func (r rehearsalRate) Percent() int {
+	if r.Marked == 0 {
+		return 100
+	}
+	return r.Present * 100 / r.Marked
+}
+
+// rehearsalRates returns the rehearsal attendance of every participant in
+// the rehearsals ("ensayo" events) of a season
+func rehearsalRates(year int) (map[int]rehearsalRate, error) {
+	rows, err := database.DB.Query(`
+		SELECT a.registration_id, COUNT(CASE WHEN a.present = TRUE THEN 1 END), COUNT(*)
+		FROM attendance a
+		JOIN events e ON e.id = a.event_id
+		WHERE e.type = 'ensayo' AND `+database.Year("e.date")+` = ?
+		GROUP BY a.registration_id`, strconv.Itoa(year))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := make(map[int]rehearsalRate)
+	for rows.Next() {
+		var registrationID int
+		var rate rehearsalRate
+		if err := rows.Scan(&registrationID, &rate.Present, &rate.Marked); err != nil {
+			return nil, err
+		}
+		rates[registrationID] = rate
+	}
+	return rates, rows.Err()
+}
+
+// castMember is an assignment joined with the participant and their
+// rehearsal attendance
+type castMember struct {
+	AssignmentID   int
+	RoleID         int
+	RegistrationID int
+	RoleName       string
+	Name           string
+	Age            int
+	IsUnderstudy   bool
+	Rate           rehearsalRate
+	LowAttendance  bool
+}
+
+// castRoleSummary is a role with its cast and understudies
+type castRoleSummary struct {
+	models.CastRole
+	Cast         []castMember
+	Understudies []castMember
+}
+
+// loadCastMembers fetches the assignments of one role, or of every role of a
+// season when roleID is 0
+func loadCastMembers(year, roleID, threshold int) ([]castMember, error) {
+	rates, err := rehearsalRates(year)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT c.id, c.role_id, r.id, cr.name, r.name, r.age, c.is_understudy
+		FROM cast_assignments c
+		JOIN cast_roles cr ON cr.id = c.role_id
+		JOIN registrations r ON r.id = c.registration_id
+		WHERE cr.year = ? AND (? = 0 OR cr.id = ?)
+		ORDER BY c.is_understudy, r.name`
+	rows, err := database.DB.Query(query, year, roleID, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []castMember
+	for rows.Next() {
+		var member castMember
+		if err := rows.Scan(&member.AssignmentID, &member.RoleID, &member.RegistrationID, &member.RoleName, &member.Name, &member.Age, &member.IsUnderstudy); err != nil {
+			return nil, err
+		}
+		member.Rate = rates[member.RegistrationID]
+		member.LowAttendance = member.Rate.Percent() < threshold
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// thresholdParam reads the "threshold" query parameter, defaulting to the
+// configured attendance threshold
+func thresholdParam(r *http.Request) int {
+	threshold, err := strconv.Atoi(r.URL.Query().Get("threshold"))
+	if err != nil || threshold < 0 || threshold > 100 {
+		return attendanceThreshold
+	}
+	return threshold
+}
+
+// CastingListHandler lists the roles of a season with their cast, and warns
+// about cast members with low rehearsal attendance
+func CastingListHandler(w http.ResponseWriter, r *http.Request) {
+	year := yearParam(r)
+	threshold := thresholdParam(r)
+
+	rows, err := database.DB.Query("SELECT id, year, name, slots, min_age, max_age, description FROM cast_roles WHERE year = ? ORDER BY name", year)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	var roles []castRoleSummary
+	roleIndex := make(map[int]int)
+	for rows.Next() {
+		var role castRoleSummary
+		if err := rows.Scan(&role.ID, &role.Year, &role.Name, &role.Slots, &role.MinAge, &role.MaxAge, &role.Description); err != nil {
+			slog.WarnContext(r.Context(), "skipping row", "err", err)
+			continue
+		}
+		roleIndex[role.ID] = len(roles)
+		roles = append(roles, role)
+	}
+
+	members, err := loadCastMembers(year, 0, threshold)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	var warnings []castMember
+	for _, member := range members {
+		i, ok := roleIndex[member.RoleID]
+		if !ok {
+			continue
+		}
+		if member.IsUnderstudy {
+			roles[i].Understudies = append(roles[i].Understudies, member)
+		} else {
+			roles[i].Cast = append(roles[i].Cast, member)
+		}
+		if member.LowAttendance {
+			warnings = append(warnings, member)
+		}
+	}
+
+	tmpl, err := parseTemplates(r, "casting_list.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	data := struct {
+		Year      int
+		Threshold int
+		Roles     []castRoleSummary
+		Warnings  []castMember
+	}{
+		Year:      year,
+		Threshold: threshold,
+		Roles:     roles,
+		Warnings:  warnings,
+	}
+	tmpl.Execute(w, data)
+}
+
+// castRoleForm is the data rendered by casting_form.html
+type castRoleForm struct {
+	models.CastRole
+	Error     string
+	Conflicts []string
+}
+
+// renderCastRoleForm shows the role form, with formError and the
+// assignments that conflict with the changes if any
+func renderCastRoleForm(w http.ResponseWriter, r *http.Request, role models.CastRole, formError string, conflicts []string) {
+	tmpl, err := parseTemplates(r, "casting_form.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if formError != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	tmpl.Execute(w, castRoleForm{CastRole: role, Error: formError, Conflicts: conflicts})
+}
+
+// fitsRole reports whether a participant of age registered for year can
+// play role
+func fitsRole(role models.CastRole, age, year int) bool {
+	return year == role.Year && age >= role.MinAge && age <= role.MaxAge
+}
+
+// CastRoleCreateHandler shows the form to define a new role
+func CastRoleCreateHandler(w http.ResponseWriter, r *http.Request) {
+	renderCastRoleForm(w, r, models.CastRole{Year: yearParam(r), Slots: 1, MaxAge: 100}, "", nil)
+}
+
+// parseCastRoleForm reads the numeric fields of the role form
+func parseCastRoleForm(r *http.Request) (year, slots, minAge, maxAge int, err error) {
+	if year, err = strconv.Atoi(r.FormValue("year")); err != nil {
+		return
+	}
+	if slots, err = strconv.Atoi(r.FormValue("slots")); err != nil {
+		return
+	}
+	if minAge, err = strconv.Atoi(r.FormValue("min_age")); err != nil {
+		return
+	}
+	maxAge, err = strconv.Atoi(r.FormValue("max_age"))
+	return
+}
+
+// CastRoleStoreHandler saves the new role
+func CastRoleStoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	name := r.FormValue("name")
+	description := r.FormValue("description")
+	year, slots, minAge, maxAge, err := parseCastRoleForm(r)
+	if err != nil || slots < 1 || minAge > maxAge {
+		httpError(w, r, http.StatusBadRequest, "Invalid role")
+		return
+	}
+
+	id, err := database.InsertID(database.DB, "INSERT INTO cast_roles (year, name, slots, min_age, max_age, description) VALUES (?, ?, ?, ?, ?, ?)",
+		year, name, slots, minAge, maxAge, description)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	reportCreated(r, id)
+
+	http.Redirect(w, r, "/admin/casting?year="+strconv.Itoa(year), http.StatusSeeOther)
+}
+
+// CastRoleEditHandler shows the form to edit a role
+func CastRoleEditHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	var role models.CastRole
+	err := database.DB.QueryRow("SELECT id, year, name, slots, min_age, max_age, description FROM cast_roles WHERE id = ?", id).
+		Scan(&role.ID, &role.Year, &role.Name, &role.Slots, &role.MinAge, &role.MaxAge, &role.Description)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Role not found")
+		return
+	}
+
+	renderCastRoleForm(w, r, role, "", nil)
+}
+
+// CastRoleUpdateHandler updates the role, unless its current cast no longer
+// fits: fewer slots than cast members, or participants of another year or
+// age. Those assignments are listed so they can be changed first.
+func CastRoleUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	role := models.CastRole{ID: id, Name: r.FormValue("name"), Description: r.FormValue("description")}
+	var err error
+	role.Year, role.Slots, role.MinAge, role.MaxAge, err = parseCastRoleForm(r)
+	if err != nil || role.Slots < 1 || role.MinAge > role.MaxAge {
+		httpError(w, r, http.StatusBadRequest, "Invalid role")
+		return
+	}
+
+	// Like CastAssignmentStoreHandler, the role row stays locked so no
+	// assignment is added between the check and the update
+	tx, err := database.DB.Begin()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow("SELECT id FROM cast_roles WHERE id = ?"+database.ForUpdate(), id).Scan(&exists); err != nil {
+		httpError(w, r, http.StatusNotFound, "Role not found")
+		return
+	}
+
+	rows, err := tx.Query(`
+		SELECT r.name, r.age, r.year, c.is_understudy
+		FROM cast_assignments c
+		JOIN registrations r ON r.id = c.registration_id
+		WHERE c.role_id = ?
+		ORDER BY r.name`, id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	var conflicts []string
+	cast := 0
+	for rows.Next() {
+		var name string
+		var age, year int
+		var isUnderstudy bool
+		if err := rows.Scan(&name, &age, &year, &isUnderstudy); err != nil {
+			rows.Close()
+			serverError(w, r, err)
+			return
+		}
+		if !isUnderstudy {
+			cast++
+		}
+		if !fitsRole(role, age, year) {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%d años, %d)", name, age, year))
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	if cast > role.Slots {
+		conflicts = append([]string{fmt.Sprintf("El papel tiene %d participantes en sus cupos y quedaría con %d cupo(s).", cast, role.Slots)}, conflicts...)
+	}
+	if len(conflicts) > 0 {
+		tx.Rollback()
+		renderCastRoleForm(w, r, role, "El reparto actual no cabe en el papel con estos cambios. Cambia o libera estas asignaciones primero:", conflicts)
+		return
+	}
+
+	_, err = tx.Exec("UPDATE cast_roles SET year = ?, name = ?, slots = ?, min_age = ?, max_age = ?, description = ? WHERE id = ?",
+		role.Year, role.Name, role.Slots, role.MinAge, role.MaxAge, role.Description, id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/casting?year="+strconv.Itoa(role.Year), http.StatusSeeOther)
+}
+
+// CastRoleDeleteHandler deletes a role together with its assignments
+func CastRoleDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	var year int
+	err := database.DB.QueryRow("SELECT year FROM cast_roles WHERE id = ?", id).Scan(&year)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Role not found")
+		return
+	}
+
+	// The assignments go with it through ON DELETE CASCADE
+	_, err = database.DB.Exec("DELETE FROM cast_roles WHERE id = ?", id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/casting?year="+strconv.Itoa(year), http.StatusSeeOther)
+}
+
+// CastRoleHandler shows the cast of a role and lets admins assign
+// participants of the right age
+func CastRoleHandler(w http.ResponseWriter, r *http.Request) {
+	renderCastRole(w, r, r.URL.Query().Get("id"), "")
+}
+
+// renderCastRole renders casting_role.html for the role id, with an error
+// about the last assignment if any
+func renderCastRole(w http.ResponseWriter, r *http.Request, id string, formError string) {
+	threshold := thresholdParam(r)
+
+	var role models.CastRole
+	err := database.DB.QueryRow("SELECT id, year, name, slots, min_age, max_age, description FROM cast_roles WHERE id = ?", id).
+		Scan(&role.ID, &role.Year, &role.Name, &role.Slots, &role.MinAge, &role.MaxAge, &role.Description)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Role not found")
+		return
+	}
+
+	members, err := loadCastMembers(role.Year, role.ID, threshold)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, name, age FROM registrations
+		WHERE year = ? AND age BETWEEN ? AND ?
+			AND id NOT IN (SELECT registration_id FROM cast_assignments WHERE role_id = ?)
+		ORDER BY name`, role.Year, role.MinAge, role.MaxAge, role.ID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	var candidates []models.Registration
+	for rows.Next() {
+		var reg models.Registration
+		if err := rows.Scan(&reg.ID, &reg.Name, &reg.Age); err != nil {
+			slog.WarnContext(r.Context(), "skipping row", "err", err)
+			continue
+		}
+		candidates = append(candidates, reg)
+	}
+
+	filled := 0
+	for _, member := range members {
+		if !member.IsUnderstudy {
+			filled++
+		}
+	}
+
+	tmpl, err := parseTemplates(r, "casting_role.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	data := struct {
+		Role       models.CastRole
+		Members    []castMember
+		Candidates []models.Registration
+		FreeSlots  int
+		Threshold  int
+		Error      string
+	}{
+		Role:       role,
+		Members:    members,
+		Candidates: candidates,
+		FreeSlots:  role.Slots - filled,
+		Threshold:  threshold,
+		Error:      formError,
+	}
+	if formError != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	tmpl.Execute(w, data)
+}
+
+// CastAssignmentStoreHandler casts a participant in a role, either in one of
+// its slots or as an understudy
+func CastAssignmentStoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	roleID := r.FormValue("role_id")
+	registrationID := r.FormValue("registration_id")
+	isUnderstudy := r.FormValue("is_understudy") == "on"
+
+	// The role row stays locked until the assignment is saved, so two
+	// admins can't both take the last slot
+	tx, err := database.DB.Begin()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var role models.CastRole
+	err = tx.QueryRow("SELECT id, year, slots, min_age, max_age FROM cast_roles WHERE id = ?"+database.ForUpdate(), roleID).
+		Scan(&role.ID, &role.Year, &role.Slots, &role.MinAge, &role.MaxAge)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Role not found")
+		return
+	}
+
+	var age, year int
+	err = tx.QueryRow("SELECT age, year FROM registrations WHERE id = ?", registrationID).Scan(&age, &year)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Participant not found")
+		return
+	}
+	if !fitsRole(role, age, year) {
+		httpError(w, r, http.StatusBadRequest, "Participant does not fit the role")
+		return
+	}
+
+	var assigned int
+	err = tx.QueryRow("SELECT COUNT(*) FROM cast_assignments WHERE role_id = ? AND registration_id = ?", role.ID, registrationID).Scan(&assigned)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if assigned > 0 {
+		tx.Rollback()
+		renderCastRole(w, r, roleID, "El participante ya está asignado a este papel.")
+		return
+	}
+
+	if !isUnderstudy {
+		var filled int
+		err = tx.QueryRow("SELECT COUNT(*) FROM cast_assignments WHERE role_id = ? AND is_understudy = FALSE", role.ID).Scan(&filled)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if filled >= role.Slots {
+			tx.Rollback()
+			renderCastRole(w, r, roleID, "Todos los cupos del papel están ocupados. Asígnalo como suplente o libera un cupo.")
+			return
+		}
+	}
+
+	id, err := database.InsertID(tx, "INSERT INTO cast_assignments (role_id, registration_id, is_understudy) VALUES (?, ?, ?)",
+		role.ID, registrationID, isUnderstudy)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+	reportCreated(r, id)
+
+	http.Redirect(w, r, "/admin/casting/role?id="+roleID, http.StatusSeeOther)
+}
+
+// CastAssignmentDeleteHandler removes a participant from a role
+func CastAssignmentDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	var roleID int
+	err := database.DB.QueryRow("SELECT role_id FROM cast_assignments WHERE id = ?", id).Scan(&roleID)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Assignment not found")
+		return
+	}
+
+	_, err = database.DB.Exec("DELETE FROM cast_assignments WHERE id = ?", id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/casting/role?id="+strconv.Itoa(roleID), http.StatusSeeOther)
+}
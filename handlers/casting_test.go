@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"posadas-sistema/database"
+)
+
+func TestCastRoleUpdateKeepsTheCastValid(t *testing.T) {
+	newTestApp(t) // for the templates
+	openTestDB(t)
+	roleID, err := database.InsertID(database.DB, "INSERT INTO cast_roles (year, name, slots, min_age, max_age, description) VALUES (2025, 'Pastor', 2, 6, 10, '')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, child := range []struct {
+		name string
+		age  int
+	}{{"Ana", 8}, {"Beto", 10}} {
+		regID, err := database.InsertID(database.DB, "INSERT INTO registrations (name, age, dni, year) VALUES (?, ?, ?, 2025)", child.name, child.age, "dni-"+child.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.DB.Exec("INSERT INTO cast_assignments (role_id, registration_id, is_understudy) VALUES (?, ?, FALSE)", roleID, regID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	update := func(changes url.Values) *httptest.ResponseRecorder {
+		form := url.Values{"id": {strconv.FormatInt(roleID, 10)}, "name": {"Pastor"}, "year": {"2025"}, "slots": {"2"}, "min_age": {"6"}, "max_age": {"10"}}
+		for key, value := range changes {
+			form[key] = value
+		}
+		w := httptest.NewRecorder()
+		CastRoleUpdateHandler(w, postForm("/admin/casting/update", form))
+		return w
+	}
+
+	tests := []struct {
+		name    string
+		changes url.Values
+		listed  []string
+	}{
+		{"fewer slots than cast", url.Values{"slots": {"1"}}, []string{"2 participantes"}},
+		{"lower maximum age", url.Values{"max_age": {"9"}}, []string{"Beto"}},
+		{"another year", url.Values{"year": {"2026"}}, []string{"Ana", "Beto"}},
+	}
+	for _, tt := range tests {
+		w := update(tt.changes)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, http.StatusBadRequest)
+			continue
+		}
+		for _, want := range tt.listed {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s: %q not listed", tt.name, want)
+			}
+		}
+	}
+
+	var slots, maxAge, year int
+	if err := database.DB.QueryRow("SELECT slots, max_age, year FROM cast_roles WHERE id = ?", roleID).Scan(&slots, &maxAge, &year); err != nil {
+		t.Fatal(err)
+	}
+	if slots != 2 || maxAge != 10 || year != 2025 {
+		t.Errorf("rejected changes saved: %d slots, max age %d, year %d", slots, maxAge, year)
+	}
+
+	if w := update(url.Values{"name": {"Pastor principal"}, "slots": {"3"}}); w.Code != http.StatusSeeOther {
+		t.Errorf("valid change: status %d, want %d", w.Code, http.StatusSeeOther)
+	}
+}
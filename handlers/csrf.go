@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+)
+
+// CSRF protection uses the double-submit pattern: every visitor gets a random
+// token in the csrf_token cookie, and every unsafe request must echo it in the
+// csrf_token form field or the X-CSRF-Token header. Other sites can't read
+// the cookie, so they can't forge the field.
+const (
+	csrfCookieName = "csrf_token"
+	csrfFieldName  = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+const csrfContextKey contextKey = "csrf"
+
+// CSRFMiddleware issues the CSRF cookie and rejects POST, PUT, PATCH and
+// DELETE requests whose token doesn't match it
+func CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if cookie, err := r.Cookie(csrfCookieName); err == nil && cookie.Value != "" {
+			token = cookie.Value
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		default:
+			sent := r.Header.Get(csrfHeaderName)
+			if sent == "" {
+				sent = r.FormValue(csrfFieldName)
+			}
+			if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				httpError(w, r, http.StatusForbidden, "Invalid CSRF token")
+				return
+			}
+		}
+
+		if token == "" {
+			b := make([]byte, 32)
+			if _, err := rand.Read(b); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			token = base64.RawURLEncoding.EncodeToString(b)
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookieName,
+				Value:    token,
+				Path:     "/",
+				Domain:   cookieDomain,
+				HttpOnly: true,
+				Secure:   cookieSecure,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfContextKey, token)))
+	})
+}
+
+// csrfToken returns the CSRF token of the request, as set by CSRFMiddleware
+func csrfToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfContextKey).(string)
+	return token
+}
+
+// templateFuncs returns the functions available to the templates of a request
+func templateFuncs(r *http.Request) template.FuncMap {
+	return template.FuncMap{
+		// csrfField renders the hidden CSRF input every POST form must include
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + csrfFieldName + `" value="` + template.HTMLEscapeString(csrfToken(r)) + `">`)
+		},
+		"csrfToken": func() string {
+			return csrfToken(r)
+		},
+	}
+}
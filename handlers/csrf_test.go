@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	reached := false
+	handler := CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	// A first visit gets the cookie
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login", nil))
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == csrfCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value == "" || !reached {
+		t.Fatalf("GET: cookie %v, handler reached %v", cookie, reached)
+	}
+
+	post := func(field, header string) *http.Request {
+		r := postForm("/login", url.Values{csrfFieldName: {field}})
+		r.AddCookie(cookie)
+		if header != "" {
+			r.Header.Set(csrfHeaderName, header)
+		}
+		return r
+	}
+	noCookie := postForm("/login", url.Values{csrfFieldName: {cookie.Value}})
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		want int
+	}{
+		{"token in the form", post(cookie.Value, ""), http.StatusOK},
+		{"token in the header", post("", cookie.Value), http.StatusOK},
+		{"missing token", post("", ""), http.StatusForbidden},
+		{"mismatched token", post(strings.Repeat("x", len(cookie.Value)), ""), http.StatusForbidden},
+		{"mismatched header", post(cookie.Value, "other"), http.StatusForbidden},
+		{"no cookie", noCookie, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		reached = false
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, tt.r)
+		if w.Code != tt.want || reached != (tt.want == http.StatusOK) {
+			t.Errorf("%s: status %d, handler reached %v; want %d", tt.name, w.Code, reached, tt.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"posadas-sistema/database"
+)
+
+// DatabaseCheckHandler shows the result of SQLite's integrity and foreign
+// key checks, which don't apply to PostgreSQL
+func DatabaseCheckHandler(w http.ResponseWriter, r *http.Request) {
+	integrity, err := database.IntegrityCheck()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	violations, err := database.ForeignKeyCheck()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	var journalMode string
+	var foreignKeys bool
+	if !database.IsPostgres() {
+		database.DB.QueryRow("PRAGMA journal_mode").Scan(&journalMode)
+		database.DB.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys)
+	}
+
+	tmpl, err := parseTemplates(r, "database_check.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	data := struct {
+		Integrity   []string
+		Healthy     bool
+		Violations  []database.ForeignKeyViolation
+		JournalMode string
+		ForeignKeys bool
+		Repaired    bool
+		Postgres    bool
+	}{
+		Integrity:   integrity,
+		Healthy:     len(integrity) == 1 && integrity[0] == "ok",
+		Violations:  violations,
+		JournalMode: journalMode,
+		ForeignKeys: foreignKeys,
+		Repaired:    r.URL.Query().Get("repaired") == "1",
+		Postgres:    database.IsPostgres(),
+	}
+	tmpl.Execute(w, data)
+}
+
+// DatabaseRepairHandler fixes the rows whose parent row no longer exists
+func DatabaseRepairHandler(w http.ResponseWriter, r *http.Request) {
+	repaired, err := database.RepairOrphans()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if len(repaired) > 0 {
+		counts, _ := json.Marshal(repaired)
+		recordAudit(r, "repair_orphans", "database", "", "", string(counts))
+	}
+	http.Redirect(w, r, "/admin/database?repaired=1", http.StatusSeeOther)
+}
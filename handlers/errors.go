@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// errorPage is the title and explanation shown for a status code
+type errorPage struct {
+	Title   string
+	Message string
+}
+
+var errorPages = map[int]errorPage{
+	http.StatusBadRequest:          {"Solicitud no válida", "Los datos enviados no son correctos. Revísalos e inténtalo de nuevo."},
+	http.StatusUnauthorized:        {"Acceso no autorizado", "Inicia sesión para continuar."},
+	http.StatusForbidden:           {"Acceso denegado", "No tienes permiso para ver esta página, o el enlace ya no es válido."},
+	http.StatusNotFound:            {"Página no encontrada", "Lo que buscas no existe o fue eliminado."},
+	http.StatusMethodNotAllowed:    {"Acción no permitida", "Esta página no admite esa acción."},
+	http.StatusConflict:            {"No se pudo completar", "La acción no es posible con los datos actuales."},
+	http.StatusTooManyRequests:     {"Demasiados intentos", "Espera unos minutos antes de volver a intentarlo."},
+	http.StatusInternalServerError: {"Error del servidor", "Ocurrió un error inesperado. Si se repite, avisa al coordinador."},
+}
+
+// httpError answers r with the error page for status. detail says what
+// went wrong in the request log; users only see the page.
+func httpError(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	if info := currentRequestLog(r); info != nil {
+		info.detail = detail
+	}
+	renderError(w, r, status)
+}
+
+// serverError logs err with the request ID and answers with the error page
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "request failed", "err", err)
+	renderError(w, r, http.StatusInternalServerError)
+}
+
+// NotFoundHandler answers the paths no route matches
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	httpError(w, r, http.StatusNotFound, "no route")
+}
+
+// renderError writes the error page for status through base.html, or as
+// JSON to clients that ask for it
+func renderError(w http.ResponseWriter, r *http.Request, status int) {
+	page, ok := errorPages[status]
+	if !ok {
+		page = errorPage{http.StatusText(status), "No se pudo completar la solicitud."}
+		if status >= 500 {
+			page = errorPages[http.StatusInternalServerError]
+		}
+	}
+	data := struct {
+		errorPage
+		RequestID string
+	}{page, requestID(r)}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": page.Title, "request_id": data.RequestID})
+		return
+	}
+
+	tmpl, err := parseTemplates(r, "error.html")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error page template", "err", err)
+		http.Error(w, fmt.Sprintf("%s (%s)", page.Title, data.RequestID), status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, data); err != nil {
+		slog.ErrorContext(r.Context(), "error page template", "err", err)
+	}
+}
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"posadas-sistema/database"
+	"posadas-sistema/models"
+)
+
+// groupSummary is a group with its member and leader counts
+type groupSummary struct {
+	models.Group
+	MemberCount int
+	Leaders     string
+}
+
+// GroupListHandler lists the groups of a season
+func GroupListHandler(w http.ResponseWriter, r *http.Request) {
+	year := yearParam(r)
+
+	rows, err := database.DB.Query(`
+		SELECT g.id, g.name, g.year, g.description, g.created_at,
+			(SELECT COUNT(*) FROM group_members m WHERE m.group_id = g.id),
+			COALESCE((SELECT `+database.GroupConcat("r.name", ", ")+` FROM group_members m
+				JOIN registrations r ON r.id = m.registration_id
+				WHERE m.group_id = g.id AND m.is_leader = TRUE), '')
+		FROM participant_groups g
+		WHERE g.year = ?
+		ORDER BY g.name`, year)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	var groups []groupSummary
+	for rows.Next() {
+		var group groupSummary
+		if err := rows.Scan(&group.ID, &group.Name, &group.Year, &group.Description, &group.CreatedAt, &group.MemberCount, &group.Leaders); err != nil {
+			slog.WarnContext(r.Context(), "skipping row", "err", err)
+			continue
+		}
+		groups = append(groups, group)
+	}
+
+	tmpl, err := parseTemplates(r, "groups_list.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	data := struct {
+		Year   int
+		Groups []groupSummary
+	}{
+		Year:   year,
+		Groups: groups,
+	}
+	tmpl.Execute(w, data)
+}
+
+// GroupCreateHandler shows the form to create a new group
+func GroupCreateHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := parseTemplates(r, "groups_form.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	tmpl.Execute(w, models.Group{Year: yearParam(r)})
+}
+
+// GroupStoreHandler saves the new group
+func GroupStoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	name := r.FormValue("name")
+	description := r.FormValue("description")
+	year, err := strconv.Atoi(r.FormValue("year"))
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "Invalid year")
+		return
+	}
+
+	id, err := database.InsertID(database.DB, "INSERT INTO participant_groups (name, year, description) VALUES (?, ?, ?)", name, year, description)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	reportCreated(r, id)
+
+	http.Redirect(w, r, "/admin/groups?year="+strconv.Itoa(year), http.StatusSeeOther)
+}
+
+// GroupEditHandler shows the form to edit a group
+func GroupEditHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	var group models.Group
+	err := database.DB.QueryRow("SELECT id, name, year, description FROM participant_groups WHERE id = ?", id).
+		Scan(&group.ID, &group.Name, &group.Year, &group.Description)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Group not found")
+		return
+	}
+
+	tmpl, err := parseTemplates(r, "groups_form.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	tmpl.Execute(w, group)
+}
+
+// GroupUpdateHandler updates the group
+func GroupUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id := r.FormValue("id")
+	name := r.FormValue("name")
+	description := r.FormValue("description")
+	year, err := strconv.Atoi(r.FormValue("year"))
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "Invalid year")
+		return
+	}
+
+	_, err = database.DB.Exec("UPDATE participant_groups SET name = ?, year = ?, description = ? WHERE id = ?", name, year, description, id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/groups?year="+strconv.Itoa(year), http.StatusSeeOther)
+}
+
+// GroupDeleteHandler deletes a group together with its memberships
+func GroupDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	var year int
+	err := database.DB.QueryRow("SELECT year FROM participant_groups WHERE id = ?", id).Scan(&year)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Group not found")
+		return
+	}
+
+	// Memberships and event targeting go with it through ON DELETE CASCADE
+	_, err = database.DB.Exec("DELETE FROM participant_groups WHERE id = ?", id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/groups?year="+strconv.Itoa(year), http.StatusSeeOther)
+}
+
+// groupMemberRow is a membership joined with the participant's details
+type groupMemberRow struct {
+	ID             int
+	RegistrationID int
+	Name           string
+	Age            int
+	IsLeader       bool
+}
+
+// GroupMembersHandler shows the leaders and members of a group and lets
+// admins add participants of the same season
+func GroupMembersHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	var group models.Group
+	err := database.DB.QueryRow("SELECT id, name, year, description FROM participant_groups WHERE id = ?", id).
+		Scan(&group.ID, &group.Name, &group.Year, &group.Description)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Group not found")
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT m.id, r.id, r.name, r.age, m.is_leader
+		FROM group_members m
+		JOIN registrations r ON r.id = m.registration_id
+		WHERE m.group_id = ?
+		ORDER BY m.is_leader DESC, r.name`, group.ID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	var members []groupMemberRow
+	for rows.Next() {
+		var member groupMemberRow
+		if err := rows.Scan(&member.ID, &member.RegistrationID, &member.Name, &member.Age, &member.IsLeader); err != nil {
+			slog.WarnContext(r.Context(), "skipping row", "err", err)
+			continue
+		}
+		members = append(members, member)
+	}
+
+	candidateRows, err := database.DB.Query(`
+		SELECT id, name, age FROM registrations
+		WHERE year = ? AND id NOT IN (SELECT registration_id FROM group_members WHERE group_id = ?)
+		ORDER BY name`, group.Year, group.ID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer candidateRows.Close()
+
+	var candidates []models.Registration
+	for candidateRows.Next() {
+		var reg models.Registration
+		if err := candidateRows.Scan(&reg.ID, &reg.Name, &reg.Age); err != nil {
+			slog.WarnContext(r.Context(), "skipping row", "err", err)
+			continue
+		}
+		candidates = append(candidates, reg)
+	}
+
+	tmpl, err := parseTemplates(r, "groups_members.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	data := struct {
+		Group      models.Group
+		Members    []groupMemberRow
+		Candidates []models.Registration
+	}{
+		Group:      group,
+		Members:    members,
+		Candidates: candidates,
+	}
+	tmpl.Execute(w, data)
+}
+
+// GroupMemberStoreHandler adds participants to a group
+func GroupMemberStoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	r.ParseForm()
+	groupID := r.FormValue("group_id")
+	isLeader := r.FormValue("is_leader") == "on"
+
+	for _, registrationID := range r.Form["registration_id"] {
+		id, err := database.InsertID(database.DB, "INSERT INTO group_members (group_id, registration_id, is_leader) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+			groupID, registrationID, isLeader)
+		if err == sql.ErrNoRows {
+			// Already a member
+			continue
+		} else if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		reportCreated(r, id)
+	}
+
+	http.Redirect(w, r, "/admin/groups/members?id="+groupID, http.StatusSeeOther)
+}
+
+// GroupMemberDeleteHandler removes a participant from a group
+func GroupMemberDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	var groupID int
+	err := database.DB.QueryRow("SELECT group_id FROM group_members WHERE id = ?", id).Scan(&groupID)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Member not found")
+		return
+	}
+
+	_, err = database.DB.Exec("DELETE FROM group_members WHERE id = ?", id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/groups/members?id="+strconv.Itoa(groupID), http.StatusSeeOther)
+}
+
+// GroupMemberToggleLeaderHandler toggles whether a member leads the group
+func GroupMemberToggleLeaderHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	var groupID int
+	var isLeader bool
+	err := database.DB.QueryRow("SELECT group_id, is_leader FROM group_members WHERE id = ?", id).Scan(&groupID, &isLeader)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Member not found")
+		return
+	}
+
+	_, err = database.DB.Exec("UPDATE group_members SET is_leader = ? WHERE id = ?", !isLeader, id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/groups/members?id="+strconv.Itoa(groupID), http.StatusSeeOther)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"posadas-sistema/database"
+	"posadas-sistema/metrics"
+)
+
+var (
+	requestsTotal = metrics.NewCounter("posadas_http_requests_total",
+		"HTTP requests served, by route and status.", "method", "route", "status")
+	requestDuration = metrics.NewHistogram("posadas_http_request_duration_seconds",
+		"Time taken to serve HTTP requests, by route.", metrics.DefaultBuckets, "method", "route")
+	failedLogins = metrics.NewCounter("posadas_login_failures_total",
+		"Failed logins, by reason.", "reason")
+)
+
+// Business figures, read from the database on every scrape
+func init() {
+	metrics.NewGaugeFunc("posadas_registrations", "Registrations per season.", []string{"season"}, func() ([]metrics.Sample, error) {
+		rows, err := database.DB.Query("SELECT year, COUNT(*) FROM registrations GROUP BY year ORDER BY year")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var samples []metrics.Sample
+		for rows.Next() {
+			var year, count int
+			if err := rows.Scan(&year, &count); err != nil {
+				return nil, err
+			}
+			samples = append(samples, metrics.Sample{Labels: []string{strconv.Itoa(year)}, Value: float64(count)})
+		}
+		return samples, rows.Err()
+	})
+
+	metrics.NewGaugeFunc("posadas_attendance_today", "Attendance marks of today's events.", []string{"present"}, func() ([]metrics.Sample, error) {
+		day := now()
+		var present, absent int
+		err := database.DB.QueryRow(`
+			SELECT COUNT(CASE WHEN a.present = TRUE THEN 1 END), COUNT(CASE WHEN a.present = FALSE THEN 1 END)
+			FROM attendance a JOIN events e ON e.id = a.event_id
+			WHERE e.date >= ? AND e.date < ?`, day.Format("2006-01-02"), day.AddDate(0, 0, 1).Format("2006-01-02")).
+			Scan(&present, &absent)
+		if err != nil {
+			return nil, err
+		}
+		return []metrics.Sample{
+			{Labels: []string{"true"}, Value: float64(present)},
+			{Labels: []string{"false"}, Value: float64(absent)},
+		}, nil
+	})
+}
+
+// HealthzHandler answers while the process is up
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// ReadyzHandler answers whether the server can take requests, that is,
+// whether the database answers
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := database.DB.PingContext(ctx); err != nil {
+		if info := currentRequestLog(r); info != nil {
+			info.detail = err.Error()
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("database unavailable\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format, to
+// clients with the metrics token if one is configured. Production always
+// configures one.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if metricsToken != "" {
+		given := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(given, []byte("Bearer "+metricsToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	metrics.Handler().ServeHTTP(w, r)
+}
+
+// probePaths are logged at debug level when they succeed, since monitoring
+// calls them every few seconds
+var probePaths = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// RecordRoute wraps mux so the request log and the metrics know the route
+// pattern each request matched, which keeps the metrics to one series per
+// route instead of one per URL
+func RecordRoute(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if info := currentRequestLog(r); info != nil {
+				info.route = r.Pattern
+			}
+		}()
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// observeRequest counts a served request in the metrics
+func observeRequest(r *http.Request, route string, status int, elapsed time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	// The method is a label of its own
+	if _, path, ok := strings.Cut(route, " "); ok {
+		route = path
+	}
+	requestsTotal.Inc(r.Method, route, strconv.Itoa(status))
+	requestDuration.Observe(elapsed.Seconds(), r.Method, route)
+}
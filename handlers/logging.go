@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+const requestLogContextKey contextKey = "requestLog"
+
+// requestLog is what the access log line says about a request. Handlers
+// deeper in the chain fill it in through the request context.
+type requestLog struct {
+	id     string
+	route  string // the ServeMux pattern, set by RecordRoute
+	user   string
+	detail string // why an error response was sent
+}
+
+// currentRequestLog returns the requestLog of r, or nil outside of
+// RequestLogger
+func currentRequestLog(r *http.Request) *requestLog {
+	info, _ := r.Context().Value(requestLogContextKey).(*requestLog)
+	return info
+}
+
+// requestID returns the ID RequestLogger gave to r, or "" outside of it
+func requestID(r *http.Request) string {
+	if info := currentRequestLog(r); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// newRequestID returns a short random ID, enough to find a request in the
+// logs of a few days
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestLogger gives every request an ID, sent back in the X-Request-ID
+// header and added to everything logged with its context, and logs one
+// line per request with its method, path, status, duration and user, and
+// counts it in the metrics. A
+// panicking handler is logged with its stack and answered with the error
+// page instead of closing the connection.
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestLog{id: newRequestID()}
+		w.Header().Set("X-Request-ID", info.id)
+		r = r.WithContext(context.WithValue(r.Context(), requestLogContextKey, info))
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				slog.ErrorContext(r.Context(), "panic serving request", "panic", p, "stack", string(debug.Stack()))
+				if !rec.wroteHeader {
+					renderError(rec, r, http.StatusInternalServerError)
+				} else {
+					rec.status = http.StatusInternalServerError
+				}
+			}
+
+			elapsed := time.Since(start)
+			observeRequest(r, info.route, rec.status, elapsed)
+
+			level := slog.LevelInfo
+			switch {
+			case rec.status >= 500:
+				level = slog.LevelError
+			case rec.status >= 400:
+				level = slog.LevelWarn
+			case probePaths[r.URL.Path]:
+				level = slog.LevelDebug
+			}
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rec.status),
+				slog.Duration("duration", elapsed),
+				slog.String("ip", clientIP(r)),
+			}
+			if info.user != "" {
+				attrs = append(attrs, slog.String("user", info.user))
+			}
+			if info.detail != "" {
+				attrs = append(attrs, slog.String("detail", info.detail))
+			}
+			slog.LogAttrs(r.Context(), level, "request", attrs...)
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// LogHandler wraps h so records logged with a request's context carry its
+// request ID
+func LogHandler(h slog.Handler) slog.Handler {
+	return logHandler{h}
+}
+
+type logHandler struct {
+	slog.Handler
+}
+
+func (h logHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info, ok := ctx.Value(requestLogContextKey).(*requestLog); ok {
+		record.AddAttrs(slog.String("request_id", info.id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return logHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h logHandler) WithGroup(name string) slog.Handler {
+	return logHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"posadas-sistema/models"
+)
+
+// Login throttling. Failed attempts are counted per client IP and per
+// username. The first loginFreeAttempts failures are free; after that each
+// failure locks the key for loginBaseDelay, doubling every time up to
+// loginMaxLockout.
+const (
+	loginFreeAttempts = 3
+	loginBaseDelay    = 2 * time.Second
+	loginMaxLockout   = 15 * time.Minute
+	loginForgetAfter  = time.Hour // failures older than this are forgotten
+)
+
+type loginFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// loginLimiter keeps the failure counters in memory
+type loginLimiter struct {
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+}
+
+var logins = &loginLimiter{failures: map[string]*loginFailures{}}
+
+// loginKeys returns the limiter keys of a login attempt
+func loginKeys(ip, username string) []string {
+	return []string{"ip:" + ip, "user:" + strings.ToLower(username)}
+}
+
+// retryAfter returns how long the caller must wait before trying again, or
+// zero if the attempt may go ahead
+func (l *loginLimiter) retryAfter(ip, username string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range loginKeys(ip, username) {
+		if f, ok := l.failures[key]; ok && f.lockedUntil.After(now) {
+			if d := f.lockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+// fail records a failed attempt and locks the keys if needed
+func (l *loginLimiter) fail(ip, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+	for _, key := range loginKeys(ip, username) {
+		f, ok := l.failures[key]
+		if !ok {
+			f = &loginFailures{}
+			l.failures[key] = f
+		}
+		f.count++
+		f.last = now
+		if f.count > loginFreeAttempts {
+			lockout := loginMaxLockout
+			if shift := f.count - loginFreeAttempts - 1; shift < 20 {
+				if d := loginBaseDelay << shift; d < lockout {
+					lockout = d
+				}
+			}
+			f.lockedUntil = now.Add(lockout)
+		}
+	}
+}
+
+// succeed clears the counters after a successful login
+func (l *loginLimiter) succeed(ip, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range loginKeys(ip, username) {
+		delete(l.failures, key)
+	}
+}
+
+// prune drops counters that are no longer locked and have been idle for
+// loginForgetAfter
+func (l *loginLimiter) prune(now time.Time) {
+	for key, f := range l.failures {
+		if now.Sub(f.last) > loginForgetAfter && now.After(f.lockedUntil) {
+			delete(l.failures, key)
+		}
+	}
+}
+
+// clientIP returns the IP address of the remote end of the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Reasons stored with failed login attempts
+const (
+	loginReasonUnknownUser = "unknown_user"
+	loginReasonBadPassword = "bad_password"
+	loginReasonInactive    = "inactive"
+	loginReasonLocked      = "locked"
+	loginReasonBadTOTP     = "bad_totp"
+)
+
+var loginReasonLabels = map[string]string{
+	loginReasonUnknownUser: "Usuario inexistente",
+	loginReasonBadPassword: "Contraseña incorrecta",
+	loginReasonInactive:    "Cuenta inactiva",
+	loginReasonLocked:      "Bloqueado temporalmente",
+	loginReasonBadTOTP:     "Código de verificación incorrecto",
+}
+
+// recordFailedLogin stores a failed attempt for the admins to review. Only
+// the attempts of the last loginForgetAfter are kept, the window in which
+// they count towards a lockout.
+func (a *App) recordFailedLogin(r *http.Request, username, reason string) {
+	failedLogins.Inc(reason)
+	attempt := models.LoginAttempt{Username: username, IP: clientIP(r), UserAgent: r.UserAgent(), Reason: reason}
+	err := a.LoginAttempts.Record(attempt, loginForgetAfter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "recording failed login", "err", err)
+	}
+}
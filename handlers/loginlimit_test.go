@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// useLoginLimiter replaces the login limiter with an empty one for the
+// length of the test
+func useLoginLimiter(t *testing.T) *loginLimiter {
+	t.Helper()
+	saved := logins
+	logins = &loginLimiter{failures: map[string]*loginFailures{}}
+	t.Cleanup(func() { logins = saved })
+	return logins
+}
+
+func TestLoginLimiterBackoff(t *testing.T) {
+	l := useLoginLimiter(t)
+
+	for i := 0; i < loginFreeAttempts; i++ {
+		l.fail("192.0.2.1", "ana")
+	}
+	if wait := l.retryAfter("192.0.2.1", "ana"); wait != 0 {
+		t.Fatalf("locked for %v after the free attempts", wait)
+	}
+
+	// Each failure after the free ones doubles the lockout
+	for _, want := range []time.Duration{loginBaseDelay, 2 * loginBaseDelay, 4 * loginBaseDelay} {
+		l.fail("192.0.2.1", "ana")
+		if wait := l.retryAfter("192.0.2.1", "ana"); wait <= want-time.Second || wait > want {
+			t.Errorf("locked for %v, want %v", wait, want)
+		}
+	}
+
+	// Both keys are locked: the IP with another username, and the username
+	// from another IP
+	if l.retryAfter("192.0.2.1", "beto") == 0 {
+		t.Error("IP not locked for another username")
+	}
+	if l.retryAfter("198.51.100.7", "ANA") == 0 {
+		t.Error("username not locked from another IP")
+	}
+	if wait := l.retryAfter("198.51.100.7", "beto"); wait != 0 {
+		t.Errorf("unrelated IP and username locked for %v", wait)
+	}
+
+	l.succeed("192.0.2.1", "ana")
+	if wait := l.retryAfter("192.0.2.1", "ana"); wait != 0 {
+		t.Errorf("locked for %v after a successful login", wait)
+	}
+}
+
+func TestLoginLimiterLockoutIsCapped(t *testing.T) {
+	l := useLoginLimiter(t)
+	for i := 0; i < 100; i++ {
+		l.fail("192.0.2.1", "ana")
+	}
+	if wait := l.retryAfter("192.0.2.1", "ana"); wait <= loginMaxLockout-time.Second || wait > loginMaxLockout {
+		t.Errorf("locked for %v, want %v", wait, loginMaxLockout)
+	}
+}
+
+func TestLoginHandlerWhileLocked(t *testing.T) {
+	a, _ := newTestApp(t)
+	useLoginLimiter(t)
+	addUser(t, a, "ana", "Estrella-de-Belen-7", RoleCoordinator)
+
+	login := func(password string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		a.LoginHandler(w, postForm("/login", url.Values{"username": {"ana"}, "password": {password}}))
+		return w.Code
+	}
+
+	for i := 0; i <= loginFreeAttempts; i++ {
+		if code := login("wrong password"); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d, want %d", i+1, code, http.StatusUnauthorized)
+		}
+	}
+
+	// The right password is refused too while locked, and isn't recorded
+	for i := 0; i < 5; i++ {
+		if code := login("Estrella-de-Belen-7"); code != http.StatusTooManyRequests {
+			t.Fatalf("locked attempt: status %d, want %d", code, http.StatusTooManyRequests)
+		}
+	}
+	attempts, err := a.LoginAttempts.List("ana", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != loginFreeAttempts+1 {
+		t.Errorf("%d attempts recorded, want %d", len(attempts), loginFreeAttempts+1)
+	}
+}
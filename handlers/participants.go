@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"posadas-sistema/database"
+	"posadas-sistema/models"
+)
+
+// ParticipantProfileHandler shows a participant with their groups, cast
+// roles and attendance history
+func (a *App) ParticipantProfileHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	reg, err := a.Registrations.Get(id)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Participant not found")
+		return
+	}
+
+	type profileGroup struct {
+		Name     string
+		IsLeader bool
+	}
+	var groups []profileGroup
+	groupRows, err := database.DB.Query(`
+		SELECT g.name, m.is_leader FROM group_members m
+		JOIN participant_groups g ON g.id = m.group_id
+		WHERE m.registration_id = ? ORDER BY g.name`, reg.ID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer groupRows.Close()
+	for groupRows.Next() {
+		var group profileGroup
+		if err := groupRows.Scan(&group.Name, &group.IsLeader); err != nil {
+			slog.WarnContext(r.Context(), "skipping row", "err", err)
+			continue
+		}
+		groups = append(groups, group)
+	}
+
+	type profileRole struct {
+		RoleID       int
+		Name         string
+		Year         int
+		IsUnderstudy bool
+	}
+	var roles []profileRole
+	roleRows, err := database.DB.Query(`
+		SELECT cr.id, cr.name, cr.year, c.is_understudy FROM cast_assignments c
+		JOIN cast_roles cr ON cr.id = c.role_id
+		WHERE c.registration_id = ? ORDER BY cr.year DESC, c.is_understudy, cr.name`, reg.ID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer roleRows.Close()
+	for roleRows.Next() {
+		var role profileRole
+		if err := roleRows.Scan(&role.RoleID, &role.Name, &role.Year, &role.IsUnderstudy); err != nil {
+			slog.WarnContext(r.Context(), "skipping row", "err", err)
+			continue
+		}
+		roles = append(roles, role)
+	}
+
+	type profileAttendance struct {
+		Event   models.Event
+		Present bool
+	}
+	var history []profileAttendance
+	attendanceRows, err := database.DB.Query(`
+		SELECT e.id, e.name, e.type, e.date, a.present FROM attendance a
+		JOIN events e ON e.id = a.event_id
+		WHERE a.registration_id = ? ORDER BY e.date DESC`, reg.ID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer attendanceRows.Close()
+	for attendanceRows.Next() {
+		var entry profileAttendance
+		if err := attendanceRows.Scan(&entry.Event.ID, &entry.Event.Name, &entry.Event.Type, &entry.Event.Date, &entry.Present); err != nil {
+			slog.WarnContext(r.Context(), "skipping row", "err", err)
+			continue
+		}
+		history = append(history, entry)
+	}
+
+	rates, err := rehearsalRates(reg.Year)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	rate := rates[reg.ID]
+
+	tmpl, err := parseTemplates(r, "participant_profile.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	data := struct {
+		Registration  models.Registration
+		Groups        []profileGroup
+		Roles         []profileRole
+		History       []profileAttendance
+		Rate          rehearsalRate
+		LowAttendance bool
+		Threshold     int
+	}{
+		Registration:  reg,
+		Groups:        groups,
+		Roles:         roles,
+		History:       history,
+		Rate:          rate,
+		LowAttendance: len(roles) > 0 && rate.Percent() < attendanceThreshold,
+		Threshold:     attendanceThreshold,
+	}
+	tmpl.Execute(w, data)
+}
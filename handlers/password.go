@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// commonPasswords is the embedded wordlist, lowercased
+var commonPasswords = loadCommonPasswords(commonPasswordsFile)
+
+func loadCommonPasswords(list string) map[string]bool {
+	words := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words[strings.ToLower(word)] = true
+	}
+	return words
+}
+
+// Password policy, set by Configure
+var (
+	passwordMinLength   = 10
+	passwordCheckCommon = true
+)
+
+// checkPassword returns why a password is not acceptable for username, or
+// nil. The messages are shown to the user.
+func checkPassword(username, password string) error {
+	if utf8.RuneCountInString(password) < passwordMinLength {
+		return fmt.Errorf("La contraseña debe tener al menos %d caracteres.", passwordMinLength)
+	}
+	lower := strings.ToLower(password)
+	if username != "" && strings.Contains(lower, strings.ToLower(username)) {
+		return errors.New("La contraseña no puede contener el nombre de usuario.")
+	}
+	if passwordCheckCommon && isCommonPassword(lower) {
+		return errors.New("La contraseña es demasiado común o aparece en filtraciones conocidas.")
+	}
+	return nil
+}
+
+// isCommonPassword reports whether password, lowercased, is in the
+// wordlist, either as is or with digits and symbols added at the end, as
+// in "dragon2024!". Most listed passwords are shorter than the minimum
+// length, and that is how people lengthen them.
+func isCommonPassword(password string) bool {
+	if commonPasswords[password] {
+		return true
+	}
+	base := strings.TrimRightFunc(password, func(r rune) bool {
+		return unicode.IsDigit(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	})
+	return base != "" && commonPasswords[base]
+}
+
+// checkEmail returns an error if email is set but is not a plain address
+func checkEmail(email string) error {
+	if email == "" {
+		return nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errors.New("El correo electrónico no es válido.")
+	}
+	return nil
+}
@@ -0,0 +1,507 @@
+package handlers
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"posadas-sistema/database"
+	"posadas-sistema/models"
+)
+
+// The nine nights of the posada run from December 16 to December 24.
+const (
+	posadaNights     = 9
+	posadaFirstNight = 16
+)
+
+// nullableID converts an optional id form value into a value for a nullable
+// foreign key column.
+func nullableID(value string) interface{} {
+	id, err := strconv.Atoi(value)
+	if err != nil || id <= 0 {
+		return nil
+	}
+	return id
+}
+
+// yearParam reads the "year" query parameter, defaulting to the current season.
+func yearParam(r *http.Request) int {
+	year, err := strconv.Atoi(r.URL.Query().Get("year"))
+	if err != nil {
+		return currentSeason()
+	}
+	return year
+}
+
+// ===== HOST HOUSEHOLD HANDLERS =====
+
+// HostListHandler lists all host households
+func HostListHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := database.DB.Query("SELECT id, family_name, COALESCE(contact_name, ''), COALESCE(phone, ''), address, COALESCE(notes, ''), created_at FROM hosts ORDER BY family_name")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	var hosts []models.Host
+	for rows.Next() {
+		var host models.Host
+		if err := rows.Scan(&host.ID, &host.FamilyName, &host.ContactName, &host.Phone, &host.Address, &host.Notes, &host.CreatedAt); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		hosts = append(hosts, host)
+	}
+	if err := rows.Err(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, "hosts_list.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	tmpl.Execute(w, hosts)
+}
+
+// HostCreateHandler shows the form to create a new host household
+func HostCreateHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := parseTemplates(r, "hosts_form.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	tmpl.Execute(w, nil)
+}
+
+// HostStoreHandler saves the new host household
+func HostStoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	familyName := r.FormValue("family_name")
+	contactName := r.FormValue("contact_name")
+	phone := r.FormValue("phone")
+	address := r.FormValue("address")
+	notes := r.FormValue("notes")
+
+	id, err := database.InsertID(database.DB, "INSERT INTO hosts (family_name, contact_name, phone, address, notes) VALUES (?, ?, ?, ?, ?)",
+		familyName, contactName, phone, address, notes)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	reportCreated(r, id)
+
+	http.Redirect(w, r, "/admin/hosts", http.StatusSeeOther)
+}
+
+// HostEditHandler shows the form to edit a host household
+func HostEditHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	var host models.Host
+	err := database.DB.QueryRow("SELECT id, family_name, COALESCE(contact_name, ''), COALESCE(phone, ''), address, COALESCE(notes, '') FROM hosts WHERE id = ?", id).
+		Scan(&host.ID, &host.FamilyName, &host.ContactName, &host.Phone, &host.Address, &host.Notes)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Host not found")
+		return
+	}
+
+	tmpl, err := parseTemplates(r, "hosts_form.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	tmpl.Execute(w, host)
+}
+
+// HostUpdateHandler updates the host household
+func HostUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id := r.FormValue("id")
+	familyName := r.FormValue("family_name")
+	contactName := r.FormValue("contact_name")
+	phone := r.FormValue("phone")
+	address := r.FormValue("address")
+	notes := r.FormValue("notes")
+
+	_, err := database.DB.Exec("UPDATE hosts SET family_name = ?, contact_name = ?, phone = ?, address = ?, notes = ? WHERE id = ?",
+		familyName, contactName, phone, address, notes, id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/hosts", http.StatusSeeOther)
+}
+
+// HostDeleteHandler deletes a host household. Nights hosted by it are left
+// without a host through ON DELETE SET NULL.
+func HostDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	_, err := database.DB.Exec("DELETE FROM hosts WHERE id = ?", id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/admin/hosts", http.StatusSeeOther)
+}
+
+// ===== POSADA NIGHT HANDLERS =====
+
+// nightSummary is a posada night joined with its host, event and route size
+type nightSummary struct {
+	models.PosadaNight
+	HostName  string
+	EventName string
+	StopCount int
+}
+
+// NightListHandler lists the nine nights of a season with their hosts
+func NightListHandler(w http.ResponseWriter, r *http.Request) {
+	year := yearParam(r)
+
+	rows, err := database.DB.Query(`
+		SELECT n.id, n.year, n.night, n.date, COALESCE(n.host_id, 0), COALESCE(n.event_id, 0), COALESCE(n.notes, ''),
+			COALESCE(h.family_name, ''), COALESCE(e.name, ''),
+			(SELECT COUNT(*) FROM route_stops s WHERE s.night_id = n.id)
+		FROM posada_nights n
+		LEFT JOIN hosts h ON h.id = n.host_id
+		LEFT JOIN events e ON e.id = n.event_id
+		WHERE n.year = ?
+		ORDER BY n.night`, year)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	var nights []nightSummary
+	for rows.Next() {
+		var n nightSummary
+		if err := rows.Scan(&n.ID, &n.Year, &n.Night, &n.Date, &n.HostID, &n.EventID, &n.Notes, &n.HostName, &n.EventName, &n.StopCount); err != nil {
+			slog.WarnContext(r.Context(), "skipping row", "err", err)
+			continue
+		}
+		nights = append(nights, n)
+	}
+
+	tmpl, err := parseTemplates(r, "posadas_list.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	data := struct {
+		Year   int
+		Nights []nightSummary
+	}{
+		Year:   year,
+		Nights: nights,
+	}
+	tmpl.Execute(w, data)
+}
+
+// NightGenerateHandler creates the nine nights (Dec 16-24) of a season.
+// Nights that already exist are left untouched.
+func NightGenerateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	year, err := strconv.Atoi(r.FormValue("year"))
+	if err != nil {
+		httpError(w, r, http.StatusBadRequest, "Invalid year")
+		return
+	}
+
+	for night := 1; night <= posadaNights; night++ {
+		date := time.Date(year, time.December, posadaFirstNight+night-1, 0, 0, 0, 0, time.UTC)
+		_, err := database.DB.Exec("INSERT INTO posada_nights (year, night, date) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+			year, night, date.Format("2006-01-02"))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/admin/posadas?year="+strconv.Itoa(year), http.StatusSeeOther)
+}
+
+// loadNight fetches a posada night by id
+func loadNight(id string) (models.PosadaNight, error) {
+	var night models.PosadaNight
+	err := database.DB.QueryRow("SELECT id, year, night, date, COALESCE(host_id, 0), COALESCE(event_id, 0), COALESCE(notes, '') FROM posada_nights WHERE id = ?", id).
+		Scan(&night.ID, &night.Year, &night.Night, &night.Date, &night.HostID, &night.EventID, &night.Notes)
+	return night, err
+}
+
+// loadRouteStops fetches the ordered route of a posada night
+func loadRouteStops(nightID int) ([]models.RouteStop, error) {
+	rows, err := database.DB.Query("SELECT id, night_id, position, name, COALESCE(address, ''), COALESCE(time, ''), meeting_point FROM route_stops WHERE night_id = ? ORDER BY position", nightID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stops []models.RouteStop
+	for rows.Next() {
+		var stop models.RouteStop
+		if err := rows.Scan(&stop.ID, &stop.NightID, &stop.Position, &stop.Name, &stop.Address, &stop.Time, &stop.MeetingPoint); err != nil {
+			return nil, err
+		}
+		stops = append(stops, stop)
+	}
+	return stops, rows.Err()
+}
+
+// NightEditHandler shows the form to assign a host, an event and the route
+// of a posada night
+func NightEditHandler(w http.ResponseWriter, r *http.Request) {
+	night, err := loadNight(r.URL.Query().Get("id"))
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Night not found")
+		return
+	}
+
+	stops, err := loadRouteStops(night.ID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	var hosts []models.Host
+	rows, err := database.DB.Query("SELECT id, family_name, address FROM hosts ORDER BY family_name")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var host models.Host
+		if err := rows.Scan(&host.ID, &host.FamilyName, &host.Address); err != nil {
+			slog.WarnContext(r.Context(), "skipping row", "err", err)
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+
+	var events []models.Event
+	eventRows, err := database.DB.Query("SELECT id, name, type, date, time FROM events ORDER BY date DESC")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer eventRows.Close()
+	for eventRows.Next() {
+		var event models.Event
+		if err := eventRows.Scan(&event.ID, &event.Name, &event.Type, &event.Date, &event.Time); err != nil {
+			slog.WarnContext(r.Context(), "skipping row", "err", err)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	tmpl, err := parseTemplates(r, "posadas_form.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	data := struct {
+		Night  models.PosadaNight
+		Stops  []models.RouteStop
+		Hosts  []models.Host
+		Events []models.Event
+	}{
+		Night:  night,
+		Stops:  stops,
+		Hosts:  hosts,
+		Events: events,
+	}
+	tmpl.Execute(w, data)
+}
+
+// NightUpdateHandler saves the host, event and notes of a posada night
+func NightUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id := r.FormValue("id")
+	hostID := nullableID(r.FormValue("host_id"))
+	eventID := nullableID(r.FormValue("event_id"))
+	notes := r.FormValue("notes")
+
+	_, err := database.DB.Exec("UPDATE posada_nights SET host_id = ?, event_id = ?, notes = ? WHERE id = ?",
+		hostID, eventID, notes, id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/posadas/edit?id="+id, http.StatusSeeOther)
+}
+
+// RouteStopStoreHandler appends a stop to the route of a posada night
+func RouteStopStoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	nightID := r.FormValue("night_id")
+	name := r.FormValue("name")
+	address := r.FormValue("address")
+	stopTime := r.FormValue("time")
+	meetingPoint := r.FormValue("meeting_point") == "on"
+
+	id, err := database.InsertID(database.DB, `INSERT INTO route_stops (night_id, position, name, address, time, meeting_point)
+		VALUES (?, (SELECT COALESCE(MAX(position), 0) + 1 FROM route_stops WHERE night_id = ?), ?, ?, ?, ?)`,
+		nightID, nightID, name, address, stopTime, meetingPoint)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	reportCreated(r, id)
+
+	http.Redirect(w, r, "/admin/posadas/edit?id="+nightID, http.StatusSeeOther)
+}
+
+// RouteStopDeleteHandler removes a stop from a route
+func RouteStopDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	var nightID int
+	err := database.DB.QueryRow("SELECT night_id FROM route_stops WHERE id = ?", id).Scan(&nightID)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Stop not found")
+		return
+	}
+
+	_, err = database.DB.Exec("DELETE FROM route_stops WHERE id = ?", id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/posadas/edit?id="+strconv.Itoa(nightID), http.StatusSeeOther)
+}
+
+// swapRouteStops exchanges the positions of two stops, both or neither
+func swapRouteStops(id string, position, neighbourID, neighbourPosition int) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE route_stops SET position = ? WHERE id = ?", neighbourPosition, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE route_stops SET position = ? WHERE id = ?", position, neighbourID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RouteStopMoveHandler swaps a stop with its neighbour to reorder the route
+func RouteStopMoveHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	direction := r.URL.Query().Get("dir")
+
+	var nightID, position int
+	err := database.DB.QueryRow("SELECT night_id, position FROM route_stops WHERE id = ?", id).Scan(&nightID, &position)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Stop not found")
+		return
+	}
+
+	neighbourQuery := "SELECT id, position FROM route_stops WHERE night_id = ? AND position < ? ORDER BY position DESC LIMIT 1"
+	if direction == "down" {
+		neighbourQuery = "SELECT id, position FROM route_stops WHERE night_id = ? AND position > ? ORDER BY position ASC LIMIT 1"
+	}
+
+	var neighbourID, neighbourPosition int
+	err = database.DB.QueryRow(neighbourQuery, nightID, position).Scan(&neighbourID, &neighbourPosition)
+	if err == nil {
+		if err := swapRouteStops(id, position, neighbourID, neighbourPosition); err != nil {
+			serverError(w, r, err)
+			return
+		}
+	} else if err != sql.ErrNoRows {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/posadas/edit?id="+strconv.Itoa(nightID), http.StatusSeeOther)
+}
+
+// NightItineraryHandler renders the printable itinerary of a posada night
+func NightItineraryHandler(w http.ResponseWriter, r *http.Request) {
+	night, err := loadNight(r.URL.Query().Get("id"))
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Night not found")
+		return
+	}
+
+	stops, err := loadRouteStops(night.ID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	var host *models.Host
+	if night.HostID != 0 {
+		host = &models.Host{}
+		err := database.DB.QueryRow("SELECT id, family_name, COALESCE(contact_name, ''), COALESCE(phone, ''), address, COALESCE(notes, '') FROM hosts WHERE id = ?", night.HostID).
+			Scan(&host.ID, &host.FamilyName, &host.ContactName, &host.Phone, &host.Address, &host.Notes)
+		if err != nil {
+			slog.WarnContext(r.Context(), "loading host", "host", night.HostID, "err", err)
+			host = nil
+		}
+	}
+
+	var event *models.Event
+	if night.EventID != 0 {
+		event = &models.Event{}
+		err := database.DB.QueryRow("SELECT id, name, type, date, time, location FROM events WHERE id = ?", night.EventID).
+			Scan(&event.ID, &event.Name, &event.Type, &event.Date, &event.Time, &event.Location)
+		if err != nil {
+			slog.WarnContext(r.Context(), "loading event", "event", night.EventID, "err", err)
+			event = nil
+		}
+	}
+
+	tmpl, err := parseTemplates(r, "posadas_itinerary.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	data := struct {
+		Night models.PosadaNight
+		Host  *models.Host
+		Event *models.Event
+		Stops []models.RouteStop
+	}{
+		Night: night,
+		Host:  host,
+		Event: event,
+		Stops: stops,
+	}
+	tmpl.Execute(w, data)
+}
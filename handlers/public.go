@@ -1,37 +1,33 @@
 package handlers
 
 import (
-	"html/template"
-	"log"
 	"net/http"
 	"strconv"
 
-	"posadas-sistema/database"
+	"posadas-sistema/models"
 )
 
 func LandingHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.ParseFiles("templates/base.html", "templates/index.html")
+	tmpl, err := parseTemplates(r, "index.html")
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
 	tmpl.Execute(w, nil)
 }
 
 func RegisterFormHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.ParseFiles("templates/base.html", "templates/register.html")
+	tmpl, err := parseTemplates(r, "register.html")
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		serverError(w, r, err)
 		return
 	}
-	tmpl.Execute(w, nil)
+	tmpl.Execute(w, struct{ Season int }{currentSeason()})
 }
 
-func RegisterSubmitHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) RegisterSubmitHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
@@ -44,28 +40,26 @@ func RegisterSubmitHandler(w http.ResponseWriter, r *http.Request) {
 
 	age, err := strconv.Atoi(ageStr)
 	if err != nil {
-		http.Error(w, "Invalid age", http.StatusBadRequest)
+		httpError(w, r, http.StatusBadRequest, "Invalid age")
 		return
 	}
 
 	year, err := strconv.Atoi(yearStr)
 	if err != nil {
-		http.Error(w, "Invalid year", http.StatusBadRequest)
+		httpError(w, r, http.StatusBadRequest, "Invalid year")
 		return
 	}
 
-	stmt, err := database.DB.Prepare("INSERT INTO registrations(name, age, dni, guardian_name, guardian_contact, year) VALUES(?, ?, ?, ?, ?, ?)")
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
+	reg := models.Registration{
+		Name:            name,
+		Age:             age,
+		DNI:             dni,
+		GuardianName:    guardianName,
+		GuardianContact: guardianContact,
+		Year:            year,
 	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(name, age, dni, guardianName, guardianContact, year)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	if err := a.Registrations.Create(&reg); err != nil {
+		serverError(w, r, err)
 		return
 	}
 
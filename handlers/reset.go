@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"posadas-sistema/database"
+	"posadas-sistema/mailer"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetTTL is how long an emailed reset link stays valid
+const passwordResetTTL = time.Hour
+
+// resets throttles reset requests per client IP and per username or email,
+// so the form can't be used to flood someone's inbox
+var resets = &loginLimiter{failures: map[string]*loginFailures{}}
+
+// hashResetToken returns the stored form of a reset token. Only the hash is
+// kept, so a leaked database does not leak usable links.
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ForgotPasswordHandler asks for a username or email and sends a reset link
+// to the account's email. The answer is the same whether or not the account
+// exists.
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Sent bool
+	}{}
+
+	if r.Method == http.MethodPost {
+		identifier := strings.TrimSpace(r.FormValue("identifier"))
+		ip := clientIP(r)
+
+		if wait := resets.retryAfter(ip, identifier); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			httpError(w, r, http.StatusTooManyRequests, "Too many reset requests, try again later")
+			return
+		}
+		resets.fail(ip, identifier)
+
+		// The lookup, the token and the email all happen after answering,
+		// so the response time doesn't reveal whether the account exists
+		if identifier != "" {
+			ctx := context.WithoutCancel(r.Context())
+			go func() {
+				if err := sendPasswordReset(ctx, identifier); err != nil {
+					slog.ErrorContext(ctx, "password reset", "err", err)
+				}
+			}()
+		}
+		data.Sent = true
+	}
+
+	tmpl, err := parseTemplates(r, "forgot_password.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	tmpl.Execute(w, data)
+}
+
+// sendPasswordReset creates a reset token for the active account matching
+// identifier, if it has an email, and mails the link. Older tokens of the
+// account stop working.
+func sendPasswordReset(ctx context.Context, identifier string) error {
+	var userID int
+	var username, email string
+	err := database.DB.QueryRowContext(ctx, "SELECT id, username, email FROM users WHERE (username = ? OR (email != '' AND lower(email) = lower(?))) AND is_active = TRUE",
+		identifier, identifier).Scan(&userID, &username, &email)
+	if err == sql.ErrNoRows || (err == nil && email == "") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(b)
+
+	now := time.Now().UTC()
+	if _, err := database.DB.ExecContext(ctx, "DELETE FROM password_resets WHERE user_id = ? OR expires_at < ?", userID, now); err != nil {
+		return err
+	}
+	_, err = database.DB.ExecContext(ctx, "INSERT INTO password_resets (user_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		userID, hashResetToken(token), now, now.Add(passwordResetTTL))
+	if err != nil {
+		return err
+	}
+
+	link := publicBaseURL + "/reset-password?token=" + url.QueryEscape(token)
+	msg := mailer.Message{
+		To:      email,
+		Subject: "Restablecer tu contraseña de Posadas",
+		Body: fmt.Sprintf("Hola %s,\n\n"+
+			"Alguien pidió restablecer la contraseña de tu cuenta. Para elegir una nueva abre este enlace:\n\n"+
+			"%s\n\n"+
+			"El enlace sirve una sola vez y vence en %d minutos. Si no fuiste tú, ignora este correo; tu contraseña no cambia.\n",
+			username, link, int(passwordResetTTL.Minutes())),
+	}
+	return mailSender.Send(msg)
+}
+
+// ResetPasswordHandler lets the holder of a valid reset link choose a new
+// password. The link works once; afterwards every session of the account
+// is ended.
+func (a *App) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+
+	var resetID, userID int
+	var username string
+	err := database.DB.QueryRow(`
+		SELECT p.id, u.id, u.username FROM password_resets p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.token_hash = ? AND p.used_at IS NULL AND p.expires_at > ? AND u.is_active = TRUE`,
+		hashResetToken(token), time.Now().UTC()).Scan(&resetID, &userID, &username)
+
+	data := struct {
+		Token     string
+		Valid     bool
+		MinLength int
+		Error     string
+	}{
+		Token:     token,
+		Valid:     err == nil,
+		MinLength: passwordMinLength,
+	}
+
+	if err != nil && err != sql.ErrNoRows {
+		serverError(w, r, err)
+		return
+	}
+
+	if data.Valid && r.Method == http.MethodPost {
+		password := r.FormValue("password")
+		if password != r.FormValue("password_confirm") {
+			data.Error = "Las contraseñas no coinciden."
+		} else if err := checkPassword(username, password); err != nil {
+			data.Error = err.Error()
+		}
+
+		if data.Error == "" {
+			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+
+			// Mark the token used first, so two submissions can't both win
+			res, err := database.DB.Exec("UPDATE password_resets SET used_at = ? WHERE id = ? AND used_at IS NULL", time.Now().UTC(), resetID)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				data.Valid = false
+			} else {
+				if err := a.Users.SetPassword(userID, string(hashedPassword)); err != nil {
+					serverError(w, r, err)
+					return
+				}
+				if err := a.Sessions.RevokeUser(userID, ""); err != nil {
+					slog.ErrorContext(r.Context(), "revoking sessions", "err", err)
+				}
+				logins.succeed(clientIP(r), username)
+				appendAudit(r.Context(), userID, username, clientIP(r), "reset_password", "users", strconv.Itoa(userID), "", "")
+				http.Redirect(w, r, "/login?reset=1", http.StatusSeeOther)
+				return
+			}
+		}
+	}
+
+	if !data.Valid {
+		w.WriteHeader(http.StatusNotFound)
+	} else if data.Error != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	tmpl, err := parseTemplates(r, "reset_password.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	tmpl.Execute(w, data)
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"posadas-sistema/database"
+	"posadas-sistema/mailer"
+	"posadas-sistema/store"
+)
+
+// useFileMailer sends the emails of the test to .eml files in the returned
+// directory
+func useFileMailer(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	old := mailSender
+	mailSender = &mailer.FileMailer{Dir: dir, From: "posadas@example.com"}
+	t.Cleanup(func() { mailSender = old })
+	return dir
+}
+
+// waitForMail returns the first email written to dir, waiting a few
+// seconds for it
+func waitForMail(t *testing.T, dir string) string {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) > 0 {
+			data, err := os.ReadFile(files[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(data)
+		}
+	}
+	t.Fatal("no email sent")
+	return ""
+}
+
+var resetLink = regexp.MustCompile(`/reset-password\?token=([0-9a-f]+)`)
+
+func TestPasswordResetByEmail(t *testing.T) {
+	newTestApp(t) // for the templates
+	openTestDB(t)
+	a := NewApp(store.NewSQL(database.DB))
+	dir := useFileMailer(t)
+	user := addUser(t, a, "maria", "Estrella-de-Belen-7", RoleCoordinator)
+	user.Email = "maria@example.com"
+	if err := a.Users.Update(user); err != nil {
+		t.Fatal(err)
+	}
+	addSession(t, a, user, "s1")
+
+	w := httptest.NewRecorder()
+	ForgotPasswordHandler(w, postForm("/forgot-password", url.Values{"identifier": {"MARIA@example.com"}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("forgot password: status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	mail := waitForMail(t, dir)
+	match := resetLink.FindStringSubmatch(mail)
+	if match == nil {
+		t.Fatalf("no reset link in:\n%s", mail)
+	}
+	token := match[1]
+
+	form := url.Values{"token": {token}, "password": {"Pastorela-en-la-Plaza-9"}, "password_confirm": {"Pastorela-en-la-Plaza-9"}}
+	w = httptest.NewRecorder()
+	a.ResetPasswordHandler(w, postForm("/reset-password", form))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("reset: status %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if got := activeSessions(t, a, user); len(got) != 0 {
+		t.Errorf("sessions after the reset: %v, want none", got)
+	}
+
+	// The link works once
+	w = httptest.NewRecorder()
+	a.ResetPasswordHandler(w, postForm("/reset-password", form))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("second use: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestPasswordResetOfUnknownAccountSendsNothing(t *testing.T) {
+	openTestDB(t)
+	dir := useFileMailer(t)
+	if _, err := database.DB.Exec("INSERT INTO users (username, password, is_active) VALUES ('sin-correo', '', TRUE)"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, identifier := range []string{"nadie", "nadie@example.com", "sin-correo"} {
+		if err := sendPasswordReset(context.Background(), identifier); err != nil {
+			t.Errorf("%s: %v", identifier, err)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.eml")); len(files) != 0 {
+		t.Errorf("%d emails sent, want none", len(files))
+	}
+	var tokens int
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM password_resets").Scan(&tokens); err != nil {
+		t.Fatal(err)
+	}
+	if tokens != 0 {
+		t.Errorf("%d reset links created, want none", tokens)
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+)
+
+// Roles an admin account can have
+const (
+	RoleSuperadmin  = "superadmin"
+	RoleCoordinator = "coordinator"
+	RoleAttendance  = "attendance"
+	RoleViewer      = "viewer"
+)
+
+// RoleOption is a role with its display name
+type RoleOption struct {
+	Key   string
+	Label string
+}
+
+// Roles lists every role with its display name, in decreasing order of power
+var Roles = []RoleOption{
+	{RoleSuperadmin, "Superadministrador"},
+	{RoleCoordinator, "Coordinador"},
+	{RoleAttendance, "Toma de Asistencia"},
+	{RoleViewer, "Solo Lectura"},
+}
+
+// Permission is an action a route requires
+type Permission string
+
+const (
+	PermView       Permission = "view"       // read-only admin pages
+	PermAttendance Permission = "attendance" // take attendance
+	PermManage     Permission = "manage"     // events, groups, casting, posadas and volunteers
+	PermUsers      Permission = "users"      // admin accounts
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleSuperadmin:  {PermView, PermAttendance, PermManage, PermUsers},
+	RoleCoordinator: {PermView, PermAttendance, PermManage},
+	RoleAttendance:  {PermView, PermAttendance},
+	RoleViewer:      {PermView},
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether a role grants a permission
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleLabel returns the display name of a role
+func RoleLabel(role string) string {
+	for _, r := range Roles {
+		if r.Key == role {
+			return r.Label
+		}
+	}
+	return role
+}
+
+type contextKey string
+
+const claimsContextKey contextKey = "claims"
+
+// withClaims stores the authenticated user's claims in the request context,
+// and names the user in the request log
+func withClaims(r *http.Request, claims *Claims) *http.Request {
+	if info := currentRequestLog(r); info != nil {
+		info.user = claims.Username
+	}
+	return r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims))
+}
+
+// currentClaims returns the claims of the authenticated user, or nil outside
+// of AuthMiddleware
+func currentClaims(r *http.Request) *Claims {
+	claims, _ := r.Context().Value(claimsContextKey).(*Claims)
+	return claims
+}
+
+// Require protects a route with AuthMiddleware and only lets through users
+// whose role grants perm
+func (a *App) Require(perm Permission, next http.HandlerFunc) http.HandlerFunc {
+	return a.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		claims := currentClaims(r)
+		if claims == nil || !HasPermission(claims.Role, perm) {
+			httpError(w, r, http.StatusForbidden, "Forbidden")
+			return
+		}
+		next(w, r)
+	})
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"posadas-sistema/models"
+	"posadas-sistema/store"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Account invariants. checkAccountChange holds an admin back from deleting
+// or deactivating their own account. The user store keeps the last active
+// superadmin in the same transaction as the change, and its
+// store.ErrLastSuperadmin is shown as errLastSuperadmin.
+var (
+	errSelfDelete     = errors.New("No puedes eliminar tu propia cuenta.")
+	errSelfDeactivate = errors.New("No puedes desactivar tu propia cuenta.")
+	errLastSuperadmin = errors.New("Debe quedar al menos un superadministrador activo.")
+)
+
+// accountChange is what a user account will look like after a change
+type accountChange struct {
+	Delete bool
+	Active bool
+}
+
+// checkAccountChange returns why actorID may not apply change to targetID,
+// or nil
+func checkAccountChange(actorID, targetID int, change accountChange) error {
+	if targetID == actorID {
+		if change.Delete {
+			return errSelfDelete
+		}
+		if !change.Active {
+			return errSelfDeactivate
+		}
+	}
+	return nil
+}
+
+// accountRuleError returns the broken invariant behind err, or nil if err
+// is a database failure
+func accountRuleError(err error) error {
+	switch err {
+	case errSelfDelete, errSelfDeactivate, errLastSuperadmin:
+		return err
+	case store.ErrLastSuperadmin:
+		return errLastSuperadmin
+	}
+	return nil
+}
+
+// accountChangeError answers a request refused by checkAccountChange
+func accountChangeError(w http.ResponseWriter, r *http.Request, err error) {
+	if ruleErr := accountRuleError(err); ruleErr != nil {
+		httpError(w, r, http.StatusConflict, ruleErr.Error())
+		return
+	}
+	serverError(w, r, err)
+}
+
+// RecoverAdmin gives back access to the admin panel from the command line:
+// it creates username as an active superadmin, or resets the existing
+// account to one, with password or a generated one that must be changed on
+// the next login. 2FA and the account's sessions are cleared. It returns the
+// password that was set.
+func (a *App) RecoverAdmin(username, password string) (string, error) {
+	if username == "" {
+		return "", errors.New("a username is required")
+	}
+	password, _, err := passwordOrRandom(username, password)
+	if err != nil {
+		return "", err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := a.Users.FindByUsername(username)
+	switch {
+	case err == store.ErrNotFound:
+		user = models.User{Username: username, Password: string(hashedPassword), IsActive: true, Role: RoleSuperadmin, MustChangePassword: true}
+		if err := a.Users.Create(&user); err != nil {
+			return "", err
+		}
+	case err != nil:
+		return "", err
+	default:
+		user.Password = string(hashedPassword)
+		user.Role = RoleSuperadmin
+		user.MustChangePassword = true
+		if err := a.Users.Update(user); err != nil {
+			return "", err
+		}
+		if err := a.Users.SetActive(user.ID, true); err != nil {
+			return "", err
+		}
+		if err := a.Users.DisableTOTP(user.ID); err != nil {
+			return "", err
+		}
+		if err := a.Sessions.RevokeUser(user.ID, ""); err != nil {
+			return "", err
+		}
+	}
+
+	appendAudit(context.Background(), 0, "(cli)", "", "recover_admin", "users", fmt.Sprint(user.ID), "", "")
+	return password, nil
+}
+
+// randomPassword returns a password of n characters that is easy to type
+func randomPassword(n int) (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	b := make([]byte, n)
+	for i := range b {
+		v, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = alphabet[v.Int64()]
+	}
+	return string(b), nil
+}
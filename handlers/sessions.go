@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"posadas-sistema/models"
+	"posadas-sistema/store"
+)
+
+// sessionTouchEvery limits how often last_seen_at is written, so a page
+// with many requests doesn't write on every one of them
+const sessionTouchEvery = time.Minute
+
+// newSessionID returns a random session ID, used as the token's jti
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// createSession stores a new session of a user and returns its ID
+func (a *App) createSession(r *http.Request, userID int, expires time.Time) (string, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	err = a.Sessions.Create(models.Session{ID: id, UserID: userID, IP: clientIP(r), UserAgent: r.UserAgent(), CreatedAt: now, LastSeenAt: now, ExpiresAt: expires})
+	return id, err
+}
+
+// touchSession records that the session was just used
+func (a *App) touchSession(r *http.Request, session models.Session) {
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < sessionTouchEvery {
+		return
+	}
+	if err := a.Sessions.Touch(session.ID, clientIP(r), now); err != nil {
+		slog.ErrorContext(r.Context(), "touching session", "err", err)
+	}
+}
+
+// clearSessionCookie removes the session cookie from the browser
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "jwt_token",
+		Value:    "",
+		Path:     "/",
+		Domain:   cookieDomain,
+		Expires:  time.Now().Add(-1 * time.Hour),
+		HttpOnly: true,
+		Secure:   cookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// SessionListHandler lists the active sessions of the logged-in user
+func (a *App) SessionListHandler(w http.ResponseWriter, r *http.Request) {
+	claims := currentClaims(r)
+	active, err := a.Sessions.Active(claims.UserID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	type sessionRow struct {
+		models.Session
+		Current bool
+	}
+
+	var sessions []sessionRow
+	for _, session := range active {
+		session.CreatedAt = session.CreatedAt.In(location)
+		session.LastSeenAt = session.LastSeenAt.In(location)
+		sessions = append(sessions, sessionRow{Session: session, Current: session.ID == claims.ID})
+	}
+
+	tmpl, err := parseTemplates(r, "sessions.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	tmpl.Execute(w, sessions)
+}
+
+// SessionRevokeHandler ends one of the logged-in user's sessions
+func (a *App) SessionRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	claims := currentClaims(r)
+	id := r.URL.Query().Get("id")
+
+	session, err := a.Sessions.Lookup(id)
+	if err == store.ErrNotFound || (err == nil && session.UserID != claims.UserID) {
+		httpError(w, r, http.StatusNotFound, "Session not found")
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	if err := a.Sessions.Revoke(id); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	if id == claims.ID {
+		clearSessionCookie(w)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+}
+
+// SessionRevokeAllHandler logs the user out everywhere, this browser included
+func (a *App) SessionRevokeAllHandler(w http.ResponseWriter, r *http.Request) {
+	claims := currentClaims(r)
+	if err := a.Sessions.RevokeUser(claims.UserID, ""); err != nil {
+		serverError(w, r, err)
+		return
+	}
+	clearSessionCookie(w)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
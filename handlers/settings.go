@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"posadas-sistema/backup"
+	"posadas-sistema/config"
+	"posadas-sistema/database"
+	"posadas-sistema/mailer"
+)
+
+// Settings taken from config.Config by Configure. The defaults match
+// config.Default so the handlers also work unconfigured.
+var (
+	jwtSecret           = []byte(config.DefaultJWTSecret)
+	cookieSecure        bool
+	cookieDomain        string
+	sessionTTL          = 24 * time.Hour
+	location            = time.Local
+	seasonYear          int
+	attendanceThreshold               = 75
+	publicBaseURL                     = "http://localhost:8080"
+	mailSender          mailer.Mailer = mailer.LogMailer{}
+	backups                           = &backup.Manager{Dir: "backups", Keep: 7}
+	metricsToken        string
+)
+
+// Configure applies the server configuration to the handlers. files holds
+// the embedded templates/ and static/ directories.
+func Configure(cfg *config.Config, files fs.FS) {
+	jwtSecret = []byte(cfg.JWTSecret)
+	cookieSecure = cfg.CookieSecure
+	cookieDomain = cfg.CookieDomain
+	sessionTTL = cfg.SessionTTL.Duration
+	location = cfg.Location
+	pages = &templateSet{files: filesFrom(files, "templates", cfg.TemplateDir), reload: cfg.TemplateDir != "" && !cfg.IsProduction()}
+	assets = &assetSet{files: filesFrom(files, "static", cfg.StaticDir), reload: cfg.StaticDir != "" && !cfg.IsProduction()}
+	seasonYear = cfg.SeasonYear
+	attendanceThreshold = cfg.AttendanceThreshold
+	passwordMinLength = cfg.PasswordMinLength
+	passwordCheckCommon = cfg.PasswordCheckCommon
+	publicBaseURL = cfg.BaseURL
+	metricsToken = cfg.MetricsToken
+	backups = &backup.Manager{Dir: cfg.BackupDir, Keep: cfg.BackupKeep, Passphrase: cfg.BackupPassphrase}
+
+	switch cfg.MailDriver {
+	case "smtp":
+		mailSender = &mailer.SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		}
+	case "file":
+		mailSender = &mailer.FileMailer{Dir: cfg.MailDir, From: cfg.MailFrom}
+	default:
+		mailSender = mailer.LogMailer{}
+	}
+}
+
+// parseTemplates parses base.html together with the given page templates,
+// with the template functions bound to r
+func parseTemplates(r *http.Request, names ...string) (*template.Template, error) {
+	return pages.lookup(r, names...)
+}
+
+// now returns the current time in the configured timezone
+func now() time.Time {
+	return time.Now().In(location)
+}
+
+// today returns the current date in the configured timezone as YYYY-MM-DD
+func today() string {
+	return now().Format("2006-01-02")
+}
+
+// currentSeason returns the configured season year, or the current year
+func currentSeason() int {
+	if seasonYear != 0 {
+		return seasonYear
+	}
+	return now().Year()
+}
+
+// appSettings caches the app_settings rows read so far, since some are read
+// on every request. Every change goes through setAppSetting, which drops the
+// cached value and bumps version, so a read that raced with it isn't cached.
+var appSettings = struct {
+	sync.Mutex
+	values  map[string]string
+	version int
+}{values: map[string]string{}}
+
+// appSetting returns a setting changed from the admin pages, stored in the
+// app_settings table, or "" when it was never set
+func appSetting(ctx context.Context, key string) string {
+	appSettings.Lock()
+	value, ok := appSettings.values[key]
+	version := appSettings.version
+	appSettings.Unlock()
+	if ok {
+		return value
+	}
+
+	err := database.DB.QueryRow("SELECT value FROM app_settings WHERE key = ?", key).Scan(&value)
+	if err != nil && err != sql.ErrNoRows {
+		slog.ErrorContext(ctx, "reading app setting", "key", key, "err", err)
+		return value
+	}
+
+	appSettings.Lock()
+	if appSettings.version == version {
+		appSettings.values[key] = value
+	}
+	appSettings.Unlock()
+	return value
+}
+
+// setAppSetting stores a setting in the app_settings table
+func setAppSetting(key, value string) error {
+	_, err := database.DB.Exec("INSERT INTO app_settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value", key, value)
+
+	appSettings.Lock()
+	delete(appSettings.values, key)
+	appSettings.version++
+	appSettings.Unlock()
+	return err
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// The templates and static files come from the copy embedded in the
+// binary, or from template_dir and static_dir when they are set. In
+// development, files on disk are read again on every request so edits show
+// without a restart; otherwise each page is parsed once and reused.
+var (
+	pages  = &templateSet{files: emptyFS{}}
+	assets = &assetSet{files: emptyFS{}}
+)
+
+// emptyFS stands for the files until Configure sets them
+type emptyFS struct{}
+
+func (emptyFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// filesFrom returns dir on disk if it is set, or the embedded sub
+// directory otherwise
+func filesFrom(embedded fs.FS, sub, dir string) fs.FS {
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+	files, err := fs.Sub(embedded, sub)
+	if err != nil {
+		return emptyFS{}
+	}
+	return files
+}
+
+// templateSet parses and keeps the pages, each one together with base.html
+type templateSet struct {
+	files  fs.FS
+	reload bool
+
+	mu     sync.Mutex
+	parsed map[string]*template.Template
+}
+
+// get returns the page made of base.html and names, parsing it the first
+// time. The template functions are unbound; lookup binds them to a request.
+func (s *templateSet) get(names ...string) (*template.Template, error) {
+	key := strings.Join(names, ",")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tmpl, ok := s.parsed[key]; ok && !s.reload {
+		return tmpl, nil
+	}
+
+	files := append([]string{"base.html"}, names...)
+	tmpl, err := template.New("base.html").Funcs(pageFuncs()).Funcs(templateFuncs(nil)).ParseFS(s.files, files...)
+	if err != nil {
+		return nil, err
+	}
+	if s.parsed == nil {
+		s.parsed = map[string]*template.Template{}
+	}
+	s.parsed[key] = tmpl
+	return tmpl, nil
+}
+
+// lookup returns a copy of the page with the template functions bound to r
+func (s *templateSet) lookup(r *http.Request, names ...string) (*template.Template, error) {
+	tmpl, err := s.get(names...)
+	if err != nil {
+		return nil, err
+	}
+	page, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return page.Funcs(templateFuncs(r)), nil
+}
+
+// LoadTemplates parses every page up front, so a broken template stops the
+// server at startup instead of failing a request
+func LoadTemplates() error {
+	names, err := fs.Glob(pages.files, "*.html")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == "base.html" {
+			continue
+		}
+		if _, err := pages.get(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pageFuncs are the template functions that don't depend on the request
+func pageFuncs() template.FuncMap {
+	return template.FuncMap{
+		// asset returns the URL of a static file with its content hash,
+		// which lets browsers keep it until it changes
+		"asset": func(name string) string { return assets.url(name) },
+	}
+}
+
+// assetSet serves the static files and knows their content hashes
+type assetSet struct {
+	files  fs.FS
+	reload bool
+
+	mu      sync.Mutex
+	hashes  map[string]string
+	missing map[string]bool
+}
+
+// hash returns a short hash of the content of the static file name, or ""
+// if it doesn't exist
+func (s *assetSet) hash(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hash, ok := s.hashes[name]; ok && !s.reload {
+		return hash
+	}
+
+	content, err := fs.ReadFile(s.files, name)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:6])
+	if s.hashes == nil {
+		s.hashes = map[string]string{}
+	}
+	s.hashes[name] = hash
+	return hash
+}
+
+// url returns the address of the static file name, versioned by its hash
+func (s *assetSet) url(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if hash := s.hash(name); hash != "" {
+		return "/static/" + name + "?v=" + hash
+	}
+	s.warnMissing(name)
+	return "/static/" + name
+}
+
+// warnMissing logs once that a static file used by the pages doesn't exist
+func (s *assetSet) warnMissing(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.missing[name] {
+		return
+	}
+	if s.missing == nil {
+		s.missing = map[string]bool{}
+	}
+	s.missing[name] = true
+	if strings.HasPrefix(name, "vendor/") {
+		slog.Warn("static file missing; run go generate to download the front-end libraries", "file", name)
+	} else {
+		slog.Warn("static file missing", "file", name)
+	}
+}
+
+// StaticHandler serves the static files under /static/. Versioned URLs
+// made by the asset template function are cached for a year, since a new
+// version gets a new URL; the rest must be revalidated.
+func StaticHandler() http.Handler {
+	return http.StripPrefix("/static/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if v := r.URL.Query().Get("v"); v != "" && v == assets.hash(name) {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		http.FileServerFS(assets.files).ServeHTTP(w, r)
+	}))
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"posadas-sistema/database"
+	"posadas-sistema/store"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", base32 encoded
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// openTestDB opens a migrated SQLite database in a temporary directory as
+// database.DB for the length of the test
+func openTestDB(t *testing.T) {
+	t.Helper()
+	if err := database.Open(database.SQLite, filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.DB.Close() })
+	if err := database.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	forgetAppSettings(t)
+}
+
+// forgetAppSettings empties the app settings cache, which would otherwise
+// carry values over from another test's database
+func forgetAppSettings(t *testing.T) {
+	t.Helper()
+	appSettings.Lock()
+	defer appSettings.Unlock()
+	appSettings.values = map[string]string{}
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// Appendix B of RFC 6238 lists 8-digit codes; these are their last 6
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := totpCode(rfc6238Secret, uint64(tt.unix/totpPeriod))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("T=%d: got %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestTOTPCodeLowercaseSecret(t *testing.T) {
+	code, err := totpCode(strings.ToLower(rfc6238Secret), 59/totpPeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "287082" {
+		t.Errorf("got %s, want 287082", code)
+	}
+}
+
+func TestVerifyTOTPWindow(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	current := now.Unix() / totpPeriod
+	codeAt := func(step int64) string {
+		code, err := totpCode(rfc6238Secret, uint64(step))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name  string
+		step  int64
+		valid bool
+	}{
+		{"current step", current, true},
+		{"one step behind", current - 1, true},
+		{"one step ahead", current + 1, true},
+		{"two steps behind", current - 2, false},
+		{"two steps ahead", current + 2, false},
+	}
+	for _, tt := range tests {
+		step, ok := verifyTOTP(rfc6238Secret, codeAt(tt.step), now, 0)
+		if ok != tt.valid {
+			t.Errorf("%s: got %v, want %v", tt.name, ok, tt.valid)
+		}
+		if ok && step != tt.step {
+			t.Errorf("%s: matched step %d, want %d", tt.name, step, tt.step)
+		}
+	}
+
+	// The last and first second of the current step see the same window
+	start := time.Unix(current*totpPeriod, 0)
+	end := time.Unix(current*totpPeriod+totpPeriod-1, 0)
+	for _, at := range []time.Time{start, end} {
+		if _, ok := verifyTOTP(rfc6238Secret, codeAt(current-1), at, 0); !ok {
+			t.Errorf("%d: previous step rejected", at.Unix())
+		}
+		if _, ok := verifyTOTP(rfc6238Secret, codeAt(current+1), at, 0); !ok {
+			t.Errorf("%d: next step rejected", at.Unix())
+		}
+	}
+}
+
+func TestVerifyTOTPRejectsReuse(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	code := "081804"
+
+	step, ok := verifyTOTP(rfc6238Secret, code, now, 0)
+	if !ok {
+		t.Fatal("valid code rejected")
+	}
+	if _, ok := verifyTOTP(rfc6238Secret, code, now, step); ok {
+		t.Error("code accepted again after its step was used")
+	}
+	if _, ok := verifyTOTP(rfc6238Secret, " 081 804 ", now, 0); !ok {
+		t.Error("code with spaces rejected")
+	}
+	for _, bad := range []string{"", "08180", "0818045", "abcdef"} {
+		if _, ok := verifyTOTP(rfc6238Secret, bad, now, 0); ok {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	openTestDB(t)
+	var ids [2]int
+	for i, username := range []string{"ana", "beto"} {
+		id, err := database.InsertID(database.DB, "INSERT INTO users (username, password, is_active) VALUES (?, '', TRUE)", username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = int(id)
+	}
+
+	codes, err := saveRecoveryCodes(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), recoveryCodeCount)
+	}
+
+	check := func(userID int, code string) bool {
+		t.Helper()
+		ok, err := checkSecondFactor(userID, rfc6238Secret, 0, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	if check(ids[1], codes[0]) {
+		t.Error("another user's recovery code accepted")
+	}
+	if !check(ids[0], codes[0]) {
+		t.Fatal("recovery code rejected")
+	}
+	if check(ids[0], codes[0]) {
+		t.Error("recovery code accepted twice")
+	}
+	if !check(ids[0], " "+strings.ToUpper(codes[1])+" ") {
+		t.Error("recovery code typed in capitals rejected")
+	}
+
+	var remaining int
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", ids[0]).Scan(&remaining); err != nil {
+		t.Fatal(err)
+	}
+	if remaining != recoveryCodeCount-2 {
+		t.Errorf("%d unused codes left, want %d", remaining, recoveryCodeCount-2)
+	}
+
+	// Regenerating replaces the old codes, used or not
+	if _, err := saveRecoveryCodes(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if check(ids[0], codes[2]) {
+		t.Error("code from before regenerating accepted")
+	}
+}
+
+func TestTOTPCodeIsSpentOnce(t *testing.T) {
+	openTestDB(t)
+	secret, err := newTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := database.InsertID(database.DB, "INSERT INTO users (username, password, is_active, totp_secret, totp_enabled) VALUES ('ana', '', TRUE, ?, TRUE)", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totpCode(secret, uint64(time.Now().Unix()/totpPeriod))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two requests that both read the counter before either one wrote it
+	results := make([]bool, 2)
+	for i := range results {
+		if results[i], err = checkSecondFactor(int(id), secret, 0, code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !results[0] || results[1] {
+		t.Errorf("got %v, want only the first use accepted", results)
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		groups := strings.Split(code, "-")
+		if len(groups) != 3 || len(code) != 14 {
+			t.Errorf("%q isn't three groups of four", code)
+		}
+		if strings.Trim(strings.Join(groups, ""), "abcdefghjkmnpqrstuvwxyz23456789") != "" {
+			t.Errorf("%q has characters outside the alphabet", code)
+		}
+		if seen[code] {
+			t.Errorf("%q repeated", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestDisableTOTPForgetsTheCodes(t *testing.T) {
+	openTestDB(t)
+	id, err := database.InsertID(database.DB, "INSERT INTO users (username, password, is_active, totp_secret, totp_enabled, totp_last_counter) VALUES ('ana', '', TRUE, ?, TRUE, 42)", rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := saveRecoveryCodes(int(id)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.NewSQL(database.DB).Users.DisableTOTP(int(id)); err != nil {
+		t.Fatal(err)
+	}
+	var secret string
+	var enabled bool
+	var counter, codes int
+	err = database.DB.QueryRow("SELECT totp_secret, totp_enabled, totp_last_counter, (SELECT COUNT(*) FROM recovery_codes) FROM users WHERE id = ?", id).
+		Scan(&secret, &enabled, &counter, &codes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "" || enabled || counter != 0 || codes != 0 {
+		t.Errorf("got secret %q, enabled %v, counter %d and %d recovery codes", secret, enabled, counter, codes)
+	}
+}
+
+func TestRequire2FAIsCachedUntilChanged(t *testing.T) {
+	openTestDB(t)
+	ctx := context.Background()
+	if require2FA(ctx) {
+		t.Fatal("2FA required before the setting was stored")
+	}
+	if err := setAppSetting(require2FASetting, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if !require2FA(ctx) {
+		t.Fatal("2FA not required after turning the setting on")
+	}
+
+	// A change that bypasses setAppSetting isn't seen: the cached value is used
+	if _, err := database.DB.Exec("UPDATE app_settings SET value = '0' WHERE key = ?", require2FASetting); err != nil {
+		t.Fatal(err)
+	}
+	if !require2FA(ctx) {
+		t.Error("setting read from the database instead of the cache")
+	}
+
+	if err := setAppSetting(require2FASetting, "0"); err != nil {
+		t.Fatal(err)
+	}
+	if require2FA(ctx) {
+		t.Error("2FA still required after turning the setting off")
+	}
+}
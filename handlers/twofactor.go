@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"posadas-sistema/database"
+	"posadas-sistema/models"
+	"posadas-sistema/store"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	twoFactorPath = "/admin/2fa"
+
+	// require2FASetting is the app_settings key of the "everyone must use
+	// 2FA" switch
+	require2FASetting = "require_2fa"
+
+	loginChallengeCookie   = "login_challenge"
+	loginChallengeAudience = "login-2fa"
+	loginChallengeTTL      = 5 * time.Minute
+)
+
+// require2FA reports whether a superadmin requires 2FA for every account.
+// AuthMiddleware asks on every request; appSetting answers from its cache.
+func require2FA(ctx context.Context) bool {
+	return appSetting(ctx, require2FASetting) == "1"
+}
+
+// loginChallengeClaims identify a user who passed the password check and
+// still has to enter a 2FA code
+type loginChallengeClaims struct {
+	UserID int `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+// startTOTPChallenge remembers the half-logged-in user in a short-lived
+// cookie and asks for the 2FA code
+func startTOTPChallenge(w http.ResponseWriter, r *http.Request, user models.User) {
+	expirationTime := time.Now().Add(loginChallengeTTL)
+	claims := &loginChallengeClaims{
+		UserID: user.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{loginChallengeAudience},
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginChallengeCookie,
+		Value:    tokenString,
+		Path:     "/login",
+		Domain:   cookieDomain,
+		Expires:  expirationTime,
+		HttpOnly: true,
+		Secure:   cookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+}
+
+// loginChallengeUser returns the user ID of the pending 2FA login, or 0
+func loginChallengeUser(r *http.Request) int {
+	c, err := r.Cookie(loginChallengeCookie)
+	if err != nil {
+		return 0
+	}
+	claims := &loginChallengeClaims{}
+	token, err := jwt.ParseWithClaims(c.Value, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithAudience(loginChallengeAudience), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return 0
+	}
+	return claims.UserID
+}
+
+// LoginTOTPHandler is the second login step: it checks the authenticator
+// code or a recovery code and then issues the session
+func (a *App) LoginTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID := loginChallengeUser(r)
+	if userID == 0 {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	var user models.User
+	var secret string
+	var lastCounter int64
+	err := database.DB.QueryRow("SELECT id, username, role, is_active, totp_secret, totp_last_counter FROM users WHERE id = ? AND totp_enabled = TRUE", userID).
+		Scan(&user.ID, &user.Username, &user.Role, &user.IsActive, &secret, &lastCounter)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	formError := ""
+	if r.Method == http.MethodPost {
+		ip := clientIP(r)
+		if wait := logins.retryAfter(ip, user.Username); wait > 0 {
+			failedLogins.Inc(loginReasonLocked)
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			httpError(w, r, http.StatusTooManyRequests, "Too many login attempts, try again later")
+			return
+		}
+
+		ok, err := checkSecondFactor(user.ID, secret, lastCounter, r.FormValue("code"))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if ok && user.IsActive {
+			http.SetCookie(w, &http.Cookie{
+				Name:    loginChallengeCookie,
+				Value:   "",
+				Path:    "/login",
+				Domain:  cookieDomain,
+				Expires: time.Now().Add(-1 * time.Hour),
+			})
+			logins.succeed(ip, user.Username)
+			a.issueSession(w, r, user)
+			return
+		}
+
+		logins.fail(ip, user.Username)
+		a.recordFailedLogin(r, user.Username, loginReasonBadTOTP)
+		formError = "El código no es válido."
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	tmpl, err := parseTemplates(r, "login_2fa.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	tmpl.Execute(w, struct{ Error string }{formError})
+}
+
+// checkSecondFactor accepts either a current TOTP code, which can't be
+// reused, or an unused recovery code, which is spent
+func checkSecondFactor(userID int, secret string, lastCounter int64, code string) (bool, error) {
+	if step, ok := verifyTOTP(secret, code, time.Now(), lastCounter); ok {
+		// Only one of two requests racing with the same code moves the
+		// counter forward; the other is rejected
+		result, err := database.DB.Exec("UPDATE users SET totp_last_counter = ? WHERE id = ? AND totp_last_counter < ?", step, userID, step)
+		if err != nil {
+			return false, err
+		}
+		n, err := result.RowsAffected()
+		return n == 1, err
+	}
+	if strings.TrimSpace(code) == "" {
+		return false, nil
+	}
+	result, err := database.DB.Exec("UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// saveRecoveryCodes replaces the recovery codes of a user and returns the
+// new ones in clear text, to be shown once
+func saveRecoveryCodes(userID int) ([]string, error) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hashRecoveryCode(code)); err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
+// twoFactorPage is the data rendered by twofactor.html
+type twoFactorPage struct {
+	Username       string
+	Enabled        bool
+	Required       bool
+	Secret         string
+	QRCode         template.URL
+	RemainingCodes int
+	RecoveryCodes  []string
+	Error          string
+}
+
+// renderTwoFactor renders the 2FA page of the logged-in user
+func renderTwoFactor(w http.ResponseWriter, r *http.Request, recoveryCodes []string, formError string) {
+	claims := currentClaims(r)
+	page := twoFactorPage{
+		Username:      claims.Username,
+		Required:      require2FA(r.Context()),
+		RecoveryCodes: recoveryCodes,
+		Error:         formError,
+	}
+
+	err := database.DB.QueryRow("SELECT totp_enabled, totp_secret FROM users WHERE id = ?", claims.UserID).Scan(&page.Enabled, &page.Secret)
+	if err != nil {
+		slog.WarnContext(r.Context(), "loading user", "err", err)
+		httpError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if page.Enabled {
+		page.Secret = ""
+		err = database.DB.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", claims.UserID).Scan(&page.RemainingCodes)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+	} else {
+		// The pending secret is kept until enrollment is confirmed, so
+		// reloading the page doesn't invalidate an already scanned code
+		if page.Secret == "" {
+			page.Secret, err = newTOTPSecret()
+			if err == nil {
+				_, err = database.DB.Exec("UPDATE users SET totp_secret = ? WHERE id = ?", page.Secret, claims.UserID)
+			}
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+		}
+		png, err := qrcode.Encode(totpURI(claims.Username, page.Secret), qrcode.Medium, 256)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		page.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	}
+
+	tmpl, err := parseTemplates(r, "twofactor.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if formError != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	tmpl.Execute(w, page)
+}
+
+// TwoFactorHandler shows the 2FA status of the logged-in user, or the QR
+// code to enroll
+func TwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	renderTwoFactor(w, r, nil, "")
+}
+
+// loadTOTP returns the secret, last used step and status of a user's 2FA
+func loadTOTP(userID int) (secret string, lastCounter int64, enabled bool, err error) {
+	err = database.DB.QueryRow("SELECT totp_secret, totp_last_counter, totp_enabled FROM users WHERE id = ?", userID).
+		Scan(&secret, &lastCounter, &enabled)
+	return
+}
+
+// TwoFactorEnableHandler confirms enrollment with a first code and shows the
+// recovery codes
+func TwoFactorEnableHandler(w http.ResponseWriter, r *http.Request) {
+	claims := currentClaims(r)
+	secret, lastCounter, enabled, err := loadTOTP(claims.UserID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if enabled {
+		http.Redirect(w, r, twoFactorPath, http.StatusSeeOther)
+		return
+	}
+
+	step, ok := verifyTOTP(secret, r.FormValue("code"), time.Now(), lastCounter)
+	if secret == "" || !ok {
+		renderTwoFactor(w, r, nil, "El código no es válido. Revisa que la hora de tu teléfono sea correcta.")
+		return
+	}
+
+	_, err = database.DB.Exec("UPDATE users SET totp_enabled = TRUE, totp_last_counter = ? WHERE id = ?", step, claims.UserID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	codes, err := saveRecoveryCodes(claims.UserID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	renderTwoFactor(w, r, codes, "")
+}
+
+// verifyCurrentTOTP checks the code posted to confirm a 2FA change
+func verifyCurrentTOTP(userID int, code string) (bool, error) {
+	secret, lastCounter, enabled, err := loadTOTP(userID)
+	if err != nil || !enabled {
+		return false, err
+	}
+	return checkSecondFactor(userID, secret, lastCounter, code)
+}
+
+// TwoFactorRecoveryHandler replaces the recovery codes of the logged-in user
+func TwoFactorRecoveryHandler(w http.ResponseWriter, r *http.Request) {
+	claims := currentClaims(r)
+	ok, err := verifyCurrentTOTP(claims.UserID, r.FormValue("code"))
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if !ok {
+		renderTwoFactor(w, r, nil, "El código no es válido.")
+		return
+	}
+
+	codes, err := saveRecoveryCodes(claims.UserID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	renderTwoFactor(w, r, codes, "")
+}
+
+// TwoFactorDisableHandler turns 2FA off for the logged-in user, unless it is
+// required for everyone
+func (a *App) TwoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	claims := currentClaims(r)
+	if require2FA(r.Context()) {
+		renderTwoFactor(w, r, nil, "La verificación en dos pasos es obligatoria para todas las cuentas.")
+		return
+	}
+
+	ok, err := verifyCurrentTOTP(claims.UserID, r.FormValue("code"))
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if !ok {
+		renderTwoFactor(w, r, nil, "El código no es válido.")
+		return
+	}
+
+	if err := a.Users.DisableTOTP(claims.UserID); err != nil {
+		serverError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, twoFactorPath, http.StatusSeeOther)
+}
+
+// AdminRequire2FAHandler switches the "2FA required for everyone" setting
+func AdminRequire2FAHandler(w http.ResponseWriter, r *http.Request) {
+	value := "0"
+	if r.FormValue("required") == "1" {
+		value = "1"
+	}
+	before := appSetting(r.Context(), require2FASetting)
+	if err := setAppSetting(require2FASetting, value); err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if before != value {
+		recordAudit(r, "update", "app_settings", require2FASetting, before, value)
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminReset2FAHandler turns 2FA off for another user who lost their device.
+// If 2FA is required they will enroll again on their next login.
+func (a *App) AdminReset2FAHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	_, err := a.Users.Get(id)
+	if err == store.ErrNotFound {
+		httpError(w, r, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	if err := a.Users.DisableTOTP(id); err != nil {
+		serverError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
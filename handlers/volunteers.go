@@ -0,0 +1,694 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"posadas-sistema/database"
+	"posadas-sistema/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// volunteerSkills are the kinds of help a volunteer can offer, and the kinds
+// of shift slot an event can ask for
+var volunteerSkills = []struct {
+	Key   string
+	Label string
+}{
+	{"chofer", "Chofer"},
+	{"cocina", "Cocina"},
+	{"seguridad", "Seguridad"},
+	{"primeros_auxilios", "Primeros Auxilios"},
+	{"general", "Apoyo General"},
+}
+
+// skillLabel returns the display name of a skill key
+func skillLabel(key string) string {
+	for _, skill := range volunteerSkills {
+		if skill.Key == key {
+			return skill.Label
+		}
+	}
+	return key
+}
+
+// hasSkill reports whether a comma separated skill list contains key.
+// Everybody can cover "general" slots.
+func hasSkill(skills, key string) bool {
+	if key == "general" {
+		return true
+	}
+	for _, skill := range strings.Split(skills, ",") {
+		if skill == key {
+			return true
+		}
+	}
+	return false
+}
+
+// ===== SIGNED SIGN-UP LINKS =====
+
+// volunteerLinkAudience keeps sign-up tokens from being accepted as admin
+// sessions and the other way around
+const volunteerLinkAudience = "volunteer-signup"
+
+// volunteerLinkTTL is how long a sign-up link stays valid
+const volunteerLinkTTL = 60 * 24 * time.Hour
+
+// volunteerClaims is the payload of a volunteer sign-up link
+type volunteerClaims struct {
+	VolunteerID int `json:"volunteer_id"`
+	jwt.RegisteredClaims
+}
+
+// volunteerSignupToken signs a sign-up link token for a volunteer
+func volunteerSignupToken(volunteerID int) (string, error) {
+	claims := &volunteerClaims{
+		VolunteerID: volunteerID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{volunteerLinkAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(volunteerLinkTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+}
+
+// volunteerFromToken validates a sign-up link token and loads the active
+// volunteer it was issued to
+func volunteerFromToken(tokenString string) (models.Volunteer, error) {
+	var volunteer models.Volunteer
+
+	claims := &volunteerClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithAudience(volunteerLinkAudience), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return volunteer, err
+	}
+	if !token.Valid {
+		return volunteer, errors.New("invalid token")
+	}
+
+	err = database.DB.QueryRow("SELECT id, name, phone, email, skills, availability, is_active FROM volunteers WHERE id = ?", claims.VolunteerID).
+		Scan(&volunteer.ID, &volunteer.Name, &volunteer.Phone, &volunteer.Email, &volunteer.Skills, &volunteer.Availability, &volunteer.IsActive)
+	if err != nil {
+		return volunteer, err
+	}
+	if !volunteer.IsActive {
+		return volunteer, errors.New("volunteer is inactive")
+	}
+	return volunteer, nil
+}
+
+// ===== VOLUNTEER ROSTER HANDLERS =====
+
+// volunteerRow is a volunteer with readable skills and their sign-up link
+type volunteerRow struct {
+	models.Volunteer
+	SkillLabels []string
+	SignupLink  string
+}
+
+// VolunteerListHandler lists the volunteer roster
+func VolunteerListHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := database.DB.Query("SELECT id, name, phone, email, skills, availability, is_active, created_at FROM volunteers ORDER BY name")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	// Sign-up links act on behalf of the volunteer, so only show them to
+	// users who could manage the roster anyway
+	showLinks := HasPermission(currentClaims(r).Role, PermManage)
+
+	var volunteers []volunteerRow
+	for rows.Next() {
+		var v volunteerRow
+		if err := rows.Scan(&v.ID, &v.Name, &v.Phone, &v.Email, &v.Skills, &v.Availability, &v.IsActive, &v.CreatedAt); err != nil {
+			slog.WarnContext(r.Context(), "skipping row", "err", err)
+			continue
+		}
+		for _, skill := range strings.Split(v.Skills, ",") {
+			if skill != "" {
+				v.SkillLabels = append(v.SkillLabels, skillLabel(skill))
+			}
+		}
+		if v.IsActive && showLinks {
+			token, err := volunteerSignupToken(v.ID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "signing volunteer link", "volunteer", v.ID, "err", err)
+			} else {
+				v.SignupLink = publicBaseURL + "/volunteer?token=" + url.QueryEscape(token)
+			}
+		}
+		volunteers = append(volunteers, v)
+	}
+
+	tmpl, err := parseTemplates(r, "volunteers_list.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	tmpl.Execute(w, volunteers)
+}
+
+// volunteerForm is the data rendered by volunteers_form.html
+type volunteerForm struct {
+	Volunteer models.Volunteer
+	Skills    []skillOption
+}
+
+// skillOption is a skill checkbox of the volunteer form
+type skillOption struct {
+	Key      string
+	Label    string
+	Selected bool
+}
+
+// skillOptions lists every skill, marking the ones in a comma separated list
+func skillOptions(skills string) []skillOption {
+	var options []skillOption
+	for _, skill := range volunteerSkills {
+		options = append(options, skillOption{
+			Key:      skill.Key,
+			Label:    skill.Label,
+			Selected: skill.Key != "general" && hasSkill(skills, skill.Key),
+		})
+	}
+	return options
+}
+
+// VolunteerCreateHandler shows the form to add a volunteer
+func VolunteerCreateHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := parseTemplates(r, "volunteers_form.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	tmpl.Execute(w, volunteerForm{Volunteer: models.Volunteer{IsActive: true}, Skills: skillOptions("")})
+}
+
+// VolunteerStoreHandler saves the new volunteer
+func VolunteerStoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	name := r.FormValue("name")
+	phone := r.FormValue("phone")
+	email := r.FormValue("email")
+	availability := r.FormValue("availability")
+	skills := strings.Join(r.Form["skills"], ",")
+
+	id, err := database.InsertID(database.DB, "INSERT INTO volunteers (name, phone, email, skills, availability, is_active) VALUES (?, ?, ?, ?, ?, ?)",
+		name, phone, email, skills, availability, true)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	reportCreated(r, id)
+
+	http.Redirect(w, r, "/admin/volunteers", http.StatusSeeOther)
+}
+
+// VolunteerEditHandler shows the form to edit a volunteer
+func VolunteerEditHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	var v models.Volunteer
+	err := database.DB.QueryRow("SELECT id, name, phone, email, skills, availability, is_active FROM volunteers WHERE id = ?", id).
+		Scan(&v.ID, &v.Name, &v.Phone, &v.Email, &v.Skills, &v.Availability, &v.IsActive)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Volunteer not found")
+		return
+	}
+
+	tmpl, err := parseTemplates(r, "volunteers_form.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	tmpl.Execute(w, volunteerForm{Volunteer: v, Skills: skillOptions(v.Skills)})
+}
+
+// VolunteerUpdateHandler updates the volunteer. Deactivated volunteers can no
+// longer use their sign-up link.
+func VolunteerUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id := r.FormValue("id")
+	name := r.FormValue("name")
+	phone := r.FormValue("phone")
+	email := r.FormValue("email")
+	availability := r.FormValue("availability")
+	skills := strings.Join(r.Form["skills"], ",")
+	isActive := r.FormValue("is_active") == "on"
+
+	_, err := database.DB.Exec("UPDATE volunteers SET name = ?, phone = ?, email = ?, skills = ?, availability = ?, is_active = ? WHERE id = ?",
+		name, phone, email, skills, availability, isActive, id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/volunteers", http.StatusSeeOther)
+}
+
+// VolunteerDeleteHandler deletes a volunteer together with their sign-ups
+func VolunteerDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	// The sign-ups go with them through ON DELETE CASCADE
+	_, err := database.DB.Exec("DELETE FROM volunteers WHERE id = ?", id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/volunteers", http.StatusSeeOther)
+}
+
+// ===== EVENT STAFFING HANDLERS =====
+
+// signupView is a sign-up joined with the volunteer's contact
+type signupView struct {
+	SignupID    int
+	VolunteerID int
+	Name        string
+	Phone       string
+}
+
+// slotView is a shift slot with the volunteers signed up for it
+type slotView struct {
+	models.ShiftSlot
+	SkillLabel string
+	Volunteers []signupView
+	SignedUp   bool // whether the volunteer viewing the portal took this slot
+	CanSignUp  bool // whether the volunteer viewing the portal may take it
+}
+
+// Filled returns how many volunteers signed up for the slot
+func (s slotView) Filled() int {
+	return len(s.Volunteers)
+}
+
+// Full reports whether the slot needs nobody else
+func (s slotView) Full() bool {
+	return len(s.Volunteers) >= s.Needed
+}
+
+// Understaffed reports whether a critical slot still has open places
+func (s slotView) Understaffed() bool {
+	return s.Critical && !s.Full()
+}
+
+// loadSlots fetches the shift slots of an event with their volunteers
+func loadSlots(eventID int) ([]slotView, error) {
+	rows, err := database.DB.Query("SELECT id, event_id, skill, start_time, end_time, needed, critical, notes FROM shift_slots WHERE event_id = ? ORDER BY critical DESC, start_time, skill", eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slots []slotView
+	index := make(map[int]int)
+	for rows.Next() {
+		var slot slotView
+		if err := rows.Scan(&slot.ID, &slot.EventID, &slot.Skill, &slot.StartTime, &slot.EndTime, &slot.Needed, &slot.Critical, &slot.Notes); err != nil {
+			return nil, err
+		}
+		slot.SkillLabel = skillLabel(slot.Skill)
+		index[slot.ID] = len(slots)
+		slots = append(slots, slot)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	signupRows, err := database.DB.Query(`
+		SELECT s.id, s.slot_id, v.id, v.name, v.phone FROM shift_signups s
+		JOIN volunteers v ON v.id = s.volunteer_id
+		JOIN shift_slots sl ON sl.id = s.slot_id
+		WHERE sl.event_id = ? ORDER BY s.created_at`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer signupRows.Close()
+
+	for signupRows.Next() {
+		var signup signupView
+		var slotID int
+		if err := signupRows.Scan(&signup.SignupID, &slotID, &signup.VolunteerID, &signup.Name, &signup.Phone); err != nil {
+			return nil, err
+		}
+		if i, ok := index[slotID]; ok {
+			slots[i].Volunteers = append(slots[i].Volunteers, signup)
+		}
+	}
+	return slots, signupRows.Err()
+}
+
+// EventStaffingHandler shows the shift slots of an event and highlights
+// critical roles that are still unfilled
+func EventStaffingHandler(w http.ResponseWriter, r *http.Request) {
+	eventID := r.URL.Query().Get("event_id")
+
+	var event models.Event
+	err := database.DB.QueryRow("SELECT id, name, type, date, time, location FROM events WHERE id = ?", eventID).
+		Scan(&event.ID, &event.Name, &event.Type, &event.Date, &event.Time, &event.Location)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	slots, err := loadSlots(event.ID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	rows, err := database.DB.Query("SELECT id, name, skills FROM volunteers WHERE is_active = TRUE ORDER BY name")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	var volunteers []models.Volunteer
+	for rows.Next() {
+		var v models.Volunteer
+		if err := rows.Scan(&v.ID, &v.Name, &v.Skills); err != nil {
+			slog.WarnContext(r.Context(), "skipping row", "err", err)
+			continue
+		}
+		volunteers = append(volunteers, v)
+	}
+
+	understaffed := 0
+	for _, slot := range slots {
+		if slot.Understaffed() {
+			understaffed++
+		}
+	}
+
+	tmpl, err := parseTemplates(r, "events_staffing.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	data := struct {
+		Event        models.Event
+		Slots        []slotView
+		Volunteers   []models.Volunteer
+		Skills       []skillOption
+		Understaffed int
+	}{
+		Event:        event,
+		Slots:        slots,
+		Volunteers:   volunteers,
+		Skills:       skillOptions(""),
+		Understaffed: understaffed,
+	}
+	tmpl.Execute(w, data)
+}
+
+// ShiftSlotStoreHandler adds a shift slot to an event
+func ShiftSlotStoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	eventID := r.FormValue("event_id")
+	skill := r.FormValue("skill")
+	startTime := r.FormValue("start_time")
+	endTime := r.FormValue("end_time")
+	notes := r.FormValue("notes")
+	critical := r.FormValue("critical") == "on"
+	needed, err := strconv.Atoi(r.FormValue("needed"))
+	if err != nil || needed < 1 {
+		httpError(w, r, http.StatusBadRequest, "Invalid number of volunteers")
+		return
+	}
+
+	id, err := database.InsertID(database.DB, "INSERT INTO shift_slots (event_id, skill, start_time, end_time, needed, critical, notes) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		eventID, skill, startTime, endTime, needed, critical, notes)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	reportCreated(r, id)
+
+	http.Redirect(w, r, "/admin/events/staffing?event_id="+eventID, http.StatusSeeOther)
+}
+
+// ShiftSlotDeleteHandler removes a shift slot and its sign-ups
+func ShiftSlotDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	var eventID int
+	err := database.DB.QueryRow("SELECT event_id FROM shift_slots WHERE id = ?", id).Scan(&eventID)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Slot not found")
+		return
+	}
+
+	// The sign-ups go with it through ON DELETE CASCADE
+	_, err = database.DB.Exec("DELETE FROM shift_slots WHERE id = ?", id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/events/staffing?event_id="+strconv.Itoa(eventID), http.StatusSeeOther)
+}
+
+// errSlotFull is returned when signing up for a slot with no room left
+var errSlotFull = errors.New("slot is full")
+
+// signUpVolunteer puts a volunteer in a slot if it still has room, and
+// reports the new sign-up to Audit. The slot row stays locked from the count
+// to the insert, so two sign-ups can't both take the last place.
+func signUpVolunteer(r *http.Request, slotID, volunteerID int) (eventID int, err error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var needed, filled int
+	err = tx.QueryRow("SELECT event_id, needed FROM shift_slots WHERE id = ?"+database.ForUpdate(), slotID).Scan(&eventID, &needed)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.QueryRow("SELECT COUNT(*) FROM shift_signups WHERE slot_id = ?", slotID).Scan(&filled); err != nil {
+		return 0, err
+	}
+	if filled >= needed {
+		return eventID, errSlotFull
+	}
+
+	id, err := database.InsertID(tx, "INSERT INTO shift_signups (slot_id, volunteer_id) VALUES (?, ?) ON CONFLICT DO NOTHING", slotID, volunteerID)
+	if err == sql.ErrNoRows {
+		// Already signed up
+		return eventID, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	reportCreated(r, id)
+	return eventID, nil
+}
+
+// ShiftSignupStoreHandler lets an admin put a volunteer in a slot
+func ShiftSignupStoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	slotID, _ := strconv.Atoi(r.FormValue("slot_id"))
+	volunteerID, _ := strconv.Atoi(r.FormValue("volunteer_id"))
+
+	eventID, err := signUpVolunteer(r, slotID, volunteerID)
+	if err == errSlotFull {
+		httpError(w, r, http.StatusBadRequest, "The slot is already full")
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/events/staffing?event_id="+strconv.Itoa(eventID), http.StatusSeeOther)
+}
+
+// ShiftSignupDeleteHandler removes a volunteer from a slot
+func ShiftSignupDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	var eventID int
+	err := database.DB.QueryRow("SELECT sl.event_id FROM shift_signups s JOIN shift_slots sl ON sl.id = s.slot_id WHERE s.id = ?", id).Scan(&eventID)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Sign-up not found")
+		return
+	}
+
+	_, err = database.DB.Exec("DELETE FROM shift_signups WHERE id = ?", id)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/events/staffing?event_id="+strconv.Itoa(eventID), http.StatusSeeOther)
+}
+
+// ===== VOLUNTEER PORTAL (PUBLIC, SIGNED LINK) =====
+
+// portalEvent is an upcoming event with the slots a volunteer can see
+type portalEvent struct {
+	Event models.Event
+	Slots []slotView
+}
+
+// VolunteerPortalHandler shows a volunteer the upcoming shifts they can sign
+// up for. Access is granted by the signed token in the link.
+func VolunteerPortalHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	volunteer, err := volunteerFromToken(token)
+	if err != nil {
+		httpError(w, r, http.StatusForbidden, "Invalid or expired link")
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, name, type, date, time, location FROM events
+		WHERE date >= ? AND id IN (SELECT event_id FROM shift_slots)
+		ORDER BY date`, today())
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	var events []portalEvent
+	for rows.Next() {
+		var event models.Event
+		if err := rows.Scan(&event.ID, &event.Name, &event.Type, &event.Date, &event.Time, &event.Location); err != nil {
+			slog.WarnContext(r.Context(), "skipping row", "err", err)
+			continue
+		}
+		events = append(events, portalEvent{Event: event})
+	}
+
+	for i := range events {
+		slots, err := loadSlots(events[i].Event.ID)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		for j := range slots {
+			for _, signup := range slots[j].Volunteers {
+				if signup.VolunteerID == volunteer.ID {
+					slots[j].SignedUp = true
+				}
+			}
+			slots[j].CanSignUp = !slots[j].SignedUp && !slots[j].Full() && hasSkill(volunteer.Skills, slots[j].Skill)
+		}
+		events[i].Slots = slots
+	}
+
+	tmpl, err := parseTemplates(r, "volunteer_portal.html")
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	data := struct {
+		Volunteer models.Volunteer
+		Token     string
+		Events    []portalEvent
+	}{
+		Volunteer: volunteer,
+		Token:     token,
+		Events:    events,
+	}
+	tmpl.Execute(w, data)
+}
+
+// VolunteerSignupHandler signs the volunteer of the link up for a slot
+func VolunteerSignupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	token := r.FormValue("token")
+	volunteer, err := volunteerFromToken(token)
+	if err != nil {
+		httpError(w, r, http.StatusForbidden, "Invalid or expired link")
+		return
+	}
+
+	slotID, _ := strconv.Atoi(r.FormValue("slot_id"))
+	var skill string
+	var upcoming bool
+	err = database.DB.QueryRow("SELECT sl.skill, e.date >= ? FROM shift_slots sl JOIN events e ON e.id = sl.event_id WHERE sl.id = ?", today(), slotID).
+		Scan(&skill, &upcoming)
+	if err != nil {
+		httpError(w, r, http.StatusNotFound, "Slot not found")
+		return
+	}
+	if !upcoming || !hasSkill(volunteer.Skills, skill) {
+		httpError(w, r, http.StatusForbidden, "You cannot sign up for this slot")
+		return
+	}
+
+	_, err = signUpVolunteer(r, slotID, volunteer.ID)
+	if err == errSlotFull {
+		httpError(w, r, http.StatusBadRequest, "The slot is already full")
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/volunteer?token="+url.QueryEscape(token), http.StatusSeeOther)
+}
+
+// VolunteerCancelHandler removes the volunteer of the link from a slot
+func VolunteerCancelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	token := r.FormValue("token")
+	volunteer, err := volunteerFromToken(token)
+	if err != nil {
+		httpError(w, r, http.StatusForbidden, "Invalid or expired link")
+		return
+	}
+
+	_, err = database.DB.Exec("DELETE FROM shift_signups WHERE slot_id = ? AND volunteer_id = ?", r.FormValue("slot_id"), volunteer.ID)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/volunteer?token="+url.QueryEscape(token), http.StatusSeeOther)
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"posadas-sistema/database"
+)
+
+func TestSignUpVolunteerFillsTheLastPlaceOnce(t *testing.T) {
+	openTestDB(t)
+	eventID, err := database.InsertID(database.DB, "INSERT INTO events (name, type, date, time, location) VALUES ('Posada', 'salida', '2025-12-16', '19:00', 'Centro')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slotID, err := database.InsertID(database.DB, "INSERT INTO shift_slots (event_id, skill, needed) VALUES (?, 'sonido', 1)", eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const volunteers = 4
+	var ids []int
+	for i := 0; i < volunteers; i++ {
+		id, err := database.InsertID(database.DB, "INSERT INTO volunteers (name) VALUES ('Voluntario')")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, int(id))
+	}
+
+	errs := make([]error, volunteers)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = signUpVolunteer(httptest.NewRequest(http.MethodPost, "/admin/events/staffing/signups/store", nil), int(slotID), id)
+		}()
+	}
+	wg.Wait()
+
+	signedUp := 0
+	for _, err := range errs {
+		switch err {
+		case nil:
+			signedUp++
+		case errSlotFull:
+		default:
+			t.Fatal(err)
+		}
+	}
+	var rows int
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM shift_signups WHERE slot_id = ?", slotID).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if signedUp != 1 || rows != 1 {
+		t.Errorf("%d sign-ups accepted and %d stored for a slot of 1", signedUp, rows)
+	}
+}
@@ -1,55 +1,63 @@
 package main
 
 import (
-	"log"
-	"net/http"
-
-	"posadas-sistema/database"
-	"posadas-sistema/handlers"
+	"fmt"
+	"os"
+	"strings"
 )
 
-func main() {
-	database.InitDB()
-
-	mux := http.NewServeMux()
-
-	// Static files
-	fs := http.FileServer(http.Dir("static"))
-	mux.Handle("GET /static/", http.StripPrefix("/static/", fs))
-
-	// Public Routes
-	mux.HandleFunc("GET /", handlers.LandingHandler)
-	mux.HandleFunc("GET /register", handlers.RegisterFormHandler)
-	mux.HandleFunc("POST /register/submit", handlers.RegisterSubmitHandler)
-	mux.HandleFunc("GET /login", handlers.LoginHandler)
-	mux.HandleFunc("POST /login", handlers.LoginHandler) // Allow POST for login submission
-	mux.HandleFunc("GET /logout", handlers.LogoutHandler)
+// command is a subcommand of the server binary. Every command takes the
+// config flags and reads the same config file and environment as serve.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
 
-	// Admin Routes (Protected)
-	mux.HandleFunc("GET /admin/dashboard", handlers.AuthMiddleware(handlers.DashboardHandler))
-	mux.HandleFunc("GET /admin/dashboard-data", handlers.AuthMiddleware(handlers.DashboardDataHandler))
-	mux.HandleFunc("GET /admin/users", handlers.AuthMiddleware(handlers.AdminListHandler))
-	mux.HandleFunc("GET /admin/users/create", handlers.AuthMiddleware(handlers.AdminCreateHandler))
-	mux.HandleFunc("POST /admin/users/store", handlers.AuthMiddleware(handlers.AdminStoreHandler))
-	mux.HandleFunc("GET /admin/users/edit", handlers.AuthMiddleware(handlers.AdminEditHandler))
-	mux.HandleFunc("POST /admin/users/update", handlers.AuthMiddleware(handlers.AdminUpdateHandler))
-	mux.HandleFunc("POST /admin/users/delete", handlers.AuthMiddleware(handlers.AdminDeleteHandler))
-	mux.HandleFunc("GET /admin/users/toggle-status", handlers.AuthMiddleware(handlers.AdminToggleStatusHandler))
+func commands() []command {
+	return []command{
+		{"serve", "run the HTTP server (the default)", serveCommand},
+		{"migrate", "show, apply or roll back schema migrations", migrateCommand},
+		{"create-admin", "create an admin account", createAdminCommand},
+		{"reset-password", "set a new password on an admin account", resetPasswordCommand},
+		{"recover-admin", "give back superadmin access when nobody can log in", recoverAdminCommand},
+		{"import-csv", "import registrations from a CSV file", importCSVCommand},
+		{"export", "export registrations, events, attendance or users", exportCommand},
+		{"backup", "take a snapshot of the database", backupCommand},
+		{"restore", "replace the database by a snapshot", restoreCommand},
+		{"seed-demo", "fill an empty database with demo data", seedDemoCommand},
+	}
+}
 
-	// Event Management Routes (Protected)
-	mux.HandleFunc("GET /admin/events", handlers.AuthMiddleware(handlers.EventListHandler))
-	mux.HandleFunc("GET /admin/events/create", handlers.AuthMiddleware(handlers.EventCreateHandler))
-	mux.HandleFunc("POST /admin/events/store", handlers.AuthMiddleware(handlers.EventStoreHandler))
-	mux.HandleFunc("GET /admin/events/edit", handlers.AuthMiddleware(handlers.EventEditHandler))
-	mux.HandleFunc("POST /admin/events/update", handlers.AuthMiddleware(handlers.EventUpdateHandler))
-	mux.HandleFunc("POST /admin/events/delete", handlers.AuthMiddleware(handlers.EventDeleteHandler))
+func main() {
+	// Without a command, or with only flags, the server starts as it
+	// always did
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-	// Attendance Routes (Protected)
-	mux.HandleFunc("GET /admin/attendance", handlers.AuthMiddleware(handlers.AttendanceHandler))
-	mux.HandleFunc("POST /admin/attendance/store", handlers.AuthMiddleware(handlers.AttendanceStoreHandler))
+	if name == "help" {
+		usage()
+		return
+	}
+	for _, c := range commands() {
+		if c.name == name {
+			c.run(args)
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
 
-	log.Println("Server starting on :8080...")
-	if err := http.ListenAndServe(":8080", mux); err != nil {
-		log.Fatal(err)
+// usage lists the commands
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: server [command] [flags]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, c := range commands() {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", c.name, c.summary)
 	}
+	fmt.Fprintln(os.Stderr, "\nRun \"server COMMAND -h\" for the flags of a command.")
 }
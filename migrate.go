@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"posadas-sistema/config"
+	"posadas-sistema/database"
+)
+
+// migrateCommand implements "migrate": it reports which schema migrations
+// are applied, applies the pending ones or rolls back the newest ones. The
+// server applies pending migrations on its own at startup.
+//
+//	server migrate status|up|down [-steps 1] [-force] [-db ./posadas.db]
+func migrateCommand(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	steps := fs.Int("steps", 1, "number of migrations to roll back with down")
+	force := fs.Bool("force", false, "let down roll back the first migration, which drops every table")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: server migrate status|up|down [-steps N] [-force] [config flags]")
+		fmt.Fprintln(fs.Output(), "  status  lists the migrations and whether they are applied")
+		fmt.Fprintln(fs.Output(), "  up      applies the pending migrations")
+		fmt.Fprintln(fs.Output(), "  down    rolls back the newest applied migrations")
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	action := args[0]
+
+	cfg, err := config.LoadFlags(fs, args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := database.Open(cfg.DBDriver, cfg.DBSource()); err != nil {
+		log.Fatal(err)
+	}
+
+	switch action {
+	case "status":
+		states, err := database.MigrationStatus()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range states {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied " + s.AppliedAt.In(cfg.Location).Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30s %s\n", s.Version, s.Name, applied)
+		}
+	case "up":
+		if err := database.Migrate(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("The database is up to date.")
+	case "down":
+		if *steps < 1 {
+			log.Fatal("-steps must be at least 1")
+		}
+		reverted, err := database.Rollback(*steps, *force)
+		if errors.Is(err, database.ErrBaselineRollback) {
+			log.Fatal(err, "; pass -force to do it anyway")
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(reverted) == 0 {
+			fmt.Println("No migrations are applied.")
+		}
+		for _, m := range reverted {
+			fmt.Printf("Rolled back %04d_%s\n", m.Version, m.Name)
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
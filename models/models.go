@@ -18,6 +18,11 @@ type User struct {
 	Username string `json:"username"`
 	Password string `json:"password"` // Hashed
 	IsActive bool   `json:"is_active"`
+	Role     string `json:"role"`  // "superadmin", "coordinator", "attendance" o "viewer"
+	Email    string `json:"email"` // para recuperar la contraseña
+
+	MustChangePassword bool `json:"must_change_password"`
+	TOTPEnabled        bool `json:"totp_enabled"` // verificación en dos pasos activa
 }
 
 type Event struct {
@@ -32,10 +37,139 @@ type Event struct {
 }
 
 type Attendance struct {
-	ID            int       `json:"id"`
-	EventID       int       `json:"event_id"`
-	RegistrationID int      `json:"registration_id"`
-	Present       bool      `json:"present"`
-	Notes         string    `json:"notes"`
-	MarkedAt      time.Time `json:"marked_at"`
+	ID             int       `json:"id"`
+	EventID        int       `json:"event_id"`
+	RegistrationID int       `json:"registration_id"`
+	Present        bool      `json:"present"`
+	Notes          string    `json:"notes"`
+	MarkedAt       time.Time `json:"marked_at"`
+}
+
+type Host struct {
+	ID          int       `json:"id"`
+	FamilyName  string    `json:"family_name"`
+	ContactName string    `json:"contact_name"`
+	Phone       string    `json:"phone"`
+	Address     string    `json:"address"`
+	Notes       string    `json:"notes"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type PosadaNight struct {
+	ID      int       `json:"id"`
+	Year    int       `json:"year"`
+	Night   int       `json:"night"` // 1 (16 de diciembre) a 9 (24 de diciembre)
+	Date    time.Time `json:"date"`
+	HostID  int       `json:"host_id"`  // 0 si no tiene anfitrión asignado
+	EventID int       `json:"event_id"` // 0 si no está vinculada a un evento
+	Notes   string    `json:"notes"`
+}
+
+type RouteStop struct {
+	ID           int    `json:"id"`
+	NightID      int    `json:"night_id"`
+	Position     int    `json:"position"`
+	Name         string `json:"name"`
+	Address      string `json:"address"`
+	Time         string `json:"time"`
+	MeetingPoint bool   `json:"meeting_point"`
+}
+
+type Group struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"` // "Coro", "Peregrinos", "Músicos", ...
+	Year        int       `json:"year"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type GroupMember struct {
+	ID             int  `json:"id"`
+	GroupID        int  `json:"group_id"`
+	RegistrationID int  `json:"registration_id"`
+	IsLeader       bool `json:"is_leader"`
+}
+
+type CastRole struct {
+	ID          int       `json:"id"`
+	Year        int       `json:"year"`
+	Name        string    `json:"name"` // "María", "José", "Ángel", "Pastor", ...
+	Slots       int       `json:"slots"`
+	MinAge      int       `json:"min_age"`
+	MaxAge      int       `json:"max_age"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type CastAssignment struct {
+	ID             int  `json:"id"`
+	RoleID         int  `json:"role_id"`
+	RegistrationID int  `json:"registration_id"`
+	IsUnderstudy   bool `json:"is_understudy"`
+}
+
+// Volunteer is an adult helper. Volunteers are not users: they never log in
+// and reach their shifts through a signed link.
+type Volunteer struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	Phone        string    `json:"phone"`
+	Email        string    `json:"email"`
+	Skills       string    `json:"skills"` // separadas por comas: "chofer,cocina"
+	Availability string    `json:"availability"`
+	IsActive     bool      `json:"is_active"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type ShiftSlot struct {
+	ID        int    `json:"id"`
+	EventID   int    `json:"event_id"`
+	Skill     string `json:"skill"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	Needed    int    `json:"needed"`
+	Critical  bool   `json:"critical"`
+	Notes     string `json:"notes"`
+}
+
+type ShiftSignup struct {
+	ID          int       `json:"id"`
+	SlotID      int       `json:"slot_id"`
+	VolunteerID int       `json:"volunteer_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// LoginAttempt es un intento fallido de inicio de sesión
+type LoginAttempt struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Session es una sesión iniciada por un administrador
+type Session struct {
+	ID         string    `json:"id"` // jti del token
+	UserID     int       `json:"user_id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// AuditEntry es un cambio registrado en la bitácora de auditoría
+type AuditEntry struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Action    string    `json:"action"` // "create", "update", "delete", ...
+	Entity    string    `json:"entity"` // tabla afectada
+	EntityID  string    `json:"entity_id"`
+	Before    string    `json:"before"` // filas en JSON antes del cambio
+	After     string    `json:"after"`  // filas en JSON después del cambio
+	IP        string    `json:"ip"`
 }
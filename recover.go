@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"posadas-sistema/config"
+)
+
+// recoverAdminCommand implements "recover-admin": it works directly on the
+// database, for when nobody can log in to the admin panel anymore.
+//
+//	server recover-admin -username admin [-password ...] [-db ./posadas.db]
+func recoverAdminCommand(args []string) {
+	fs := flag.NewFlagSet("recover-admin", flag.ExitOnError)
+	username := fs.String("username", "admin", "account to create or reset as an active superadmin")
+	password := fs.String("password", "", "new password (a random one is generated if empty)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: server recover-admin [-username NAME] [-password PASS] [config flags]")
+		fmt.Fprintln(fs.Output(), "Creates or resets an admin account so someone can log in again.")
+		fs.PrintDefaults()
+	}
+
+	cfg, err := config.LoadFlags(fs, args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app := openApp(cfg)
+
+	newPassword, err := app.RecoverAdmin(*username, *password)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Account %q is an active superadmin again.\n", *username)
+	if *password == "" {
+		fmt.Printf("Temporary password: %s\n", newPassword)
+	}
+	fmt.Println("2FA and open sessions were cleared; the password must be changed on the next login.")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"posadas-sistema/backup"
+	"posadas-sistema/config"
+)
+
+// restoreCommand implements "restore": it validates a snapshot and puts it
+// in place of the database, keeping a copy of the current one. The server
+// must be stopped while it runs.
+//
+//	server restore FILE [-check] [-db ./posadas.db]
+func restoreCommand(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	check := fs.Bool("check", false, "only validate the snapshot")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: server restore FILE [-check] [config flags]")
+		fmt.Fprintln(fs.Output(), "Replaces the database by a snapshot. Stop the server first.")
+		fmt.Fprintln(fs.Output(), "FILE is a path or the name of a snapshot in the backup directory;")
+		fmt.Fprintln(fs.Output(), "encrypted snapshots need POSADAS_BACKUP_PASSPHRASE.")
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	file := args[0]
+
+	cfg, err := config.LoadFlags(fs, args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.DBDriver != "sqlite" {
+		log.Fatal(backup.ErrUnsupported)
+	}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		if path, err := backupManager(cfg).Path(file); err == nil {
+			file = path
+		}
+	}
+
+	version, err := backup.Validate(file, cfg.BackupPassphrase)
+	if err != nil {
+		log.Fatalf("%s can't be restored: %v", file, err)
+	}
+	fmt.Printf("%s is valid (schema version %d).\n", file, version)
+	if *check {
+		return
+	}
+
+	saved, err := backup.Restore(cfg.DBPath, file, cfg.BackupPassphrase)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Restored %s from %s.\n", cfg.DBPath, file)
+	if saved != "" {
+		fmt.Printf("The previous database was saved as %s.\n", saved)
+	}
+	fmt.Println("Pending migrations are applied when the server starts.")
+}
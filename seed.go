@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"posadas-sistema/config"
+	"posadas-sistema/database"
+	"posadas-sistema/models"
+)
+
+// seedDemoCommand implements "seed-demo": it fills an empty database with a
+// season of made-up participants, rehearsals, attendance, groups, hosts and
+// volunteers, to try the system or show it. It refuses to run in
+// production or on a database that already has registrations.
+//
+//	server seed-demo [-db ./demo.db]
+func seedDemoCommand(args []string) {
+	fs := flag.NewFlagSet("seed-demo", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: server seed-demo [config flags]")
+		fmt.Fprintln(fs.Output(), "Fills an empty database with demo data.")
+		fs.PrintDefaults()
+	}
+
+	cfg, err := config.LoadFlags(fs, args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.IsProduction() {
+		log.Fatal("seed-demo doesn't run in production")
+	}
+	app := openApp(cfg)
+
+	existing, err := app.Registrations.List()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(existing) > 0 {
+		log.Fatal("the database already has registrations; seed-demo only fills an empty one")
+	}
+
+	year := cfg.Season()
+	children := []struct {
+		name     string
+		age      int
+		guardian string
+	}{
+		{"Sofía Quispe", 8, "Rosa Quispe"},
+		{"Mateo Huamán", 10, "Jorge Huamán"},
+		{"Valentina Rojas", 7, "Carmen Rojas"},
+		{"Santiago Flores", 12, "Luis Flores"},
+		{"Camila Torres", 9, "Ana Torres"},
+		{"Diego Mendoza", 11, "Pedro Mendoza"},
+		{"Lucía Vargas", 6, "Elena Vargas"},
+		{"Sebastián Castillo", 13, "Miguel Castillo"},
+		{"Isabella Ramos", 10, "Patricia Ramos"},
+		{"Gabriel Chávez", 8, "Raúl Chávez"},
+		{"Mariana Díaz", 12, "Julia Díaz"},
+		{"Nicolás Salazar", 9, "Óscar Salazar"},
+	}
+	var registrations []models.Registration
+	for i, child := range children {
+		reg := models.Registration{
+			Name:            child.name,
+			Age:             child.age,
+			DNI:             fmt.Sprintf("7%07d", 1001+i),
+			GuardianName:    child.guardian,
+			GuardianContact: fmt.Sprintf("9%08d", 87650000+i),
+			Year:            year,
+		}
+		if err := app.Registrations.Create(&reg); err != nil {
+			log.Fatal(err)
+		}
+		registrations = append(registrations, reg)
+	}
+
+	// Four Saturday rehearsals in November and the first outings
+	var events []models.Event
+	for week := 0; week < 4; week++ {
+		events = append(events, models.Event{
+			Name:     fmt.Sprintf("Ensayo %d", week+1),
+			Type:     "ensayo",
+			Date:     time.Date(year, time.November, 7+7*week, 0, 0, 0, 0, time.UTC),
+			Time:     "4:00 PM",
+			Location: "Salón parroquial",
+		})
+	}
+	for night := 0; night < 2; night++ {
+		events = append(events, models.Event{
+			Name:     fmt.Sprintf("Posada %d", night+1),
+			Type:     "salida",
+			Date:     time.Date(year, time.December, 16+night, 0, 0, 0, 0, time.UTC),
+			Time:     "6:00 PM",
+			Location: "Plaza principal",
+		})
+	}
+	for i := range events {
+		if err := app.Events.Create(&events[i], nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Most children attend most rehearsals
+	for _, event := range events {
+		if event.Type != "ensayo" {
+			continue
+		}
+		var marks []models.Attendance
+		for i, reg := range registrations {
+			marks = append(marks, models.Attendance{RegistrationID: reg.ID, Present: (i+event.ID)%4 != 0})
+		}
+		if err := app.Attendance.Replace(event.ID, marks); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := seedDemoExtras(year, registrations); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Added %d registrations, %d events, 2 groups, 3 hosts and 3 volunteers for %d.\n", len(registrations), len(events), year)
+}
+
+// seedDemoExtras adds the groups, hosts and volunteers, which have no
+// store of their own
+func seedDemoExtras(year int, registrations []models.Registration) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for g, group := range []struct{ name, description string }{
+		{"Pastores", "Acompañan a los peregrinos con faroles"},
+		{"Ángeles", "Cantan los villancicos de cada posada"},
+	} {
+		groupID, err := database.InsertID(tx, "INSERT INTO participant_groups (name, year, description) VALUES (?, ?, ?)", group.name, year, group.description)
+		if err != nil {
+			return err
+		}
+		// Every other child goes to each group; the first one leads it
+		for i := g; i < len(registrations); i += 2 {
+			_, err := tx.Exec("INSERT INTO group_members (group_id, registration_id, is_leader) VALUES (?, ?, ?)", groupID, registrations[i].ID, i == g)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, host := range []struct{ family, contact, phone, address string }{
+		{"Familia Quispe", "Rosa Quispe", "987650000", "Jr. Los Olivos 123"},
+		{"Familia Torres", "Ana Torres", "987650004", "Av. Las Flores 456"},
+		{"Familia Ramos", "Patricia Ramos", "987650008", "Calle Lima 789"},
+	} {
+		_, err := tx.Exec("INSERT INTO hosts (family_name, contact_name, phone, address, notes) VALUES (?, ?, ?, ?, ?)",
+			host.family, host.contact, host.phone, host.address, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, volunteer := range []struct{ name, phone, skills, availability string }{
+		{"Carlos Medina", "912340001", "chofer,seguridad", "Fines de semana"},
+		{"Lourdes Paredes", "912340002", "cocina", "Todas las noches"},
+		{"Fernando Ríos", "912340003", "primeros_auxilios", "16 al 20 de diciembre"},
+	} {
+		_, err := tx.Exec("INSERT INTO volunteers (name, phone, email, skills, availability, is_active) VALUES (?, ?, ?, ?, ?, ?)",
+			volunteer.name, volunteer.phone, "", volunteer.skills, volunteer.availability, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"posadas-sistema/config"
+	"posadas-sistema/database"
+	"posadas-sistema/handlers"
+)
+
+// serveCommand implements "serve", the default command: it migrates the
+// database and runs the HTTP server. On SIGINT or SIGTERM it stops taking
+// requests, waits for those in progress and closes the database.
+//
+//	server serve [config flags]
+func serveCommand(args []string) {
+	cfg, err := config.Load(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logHandler := setupLogging(cfg)
+
+	app := openApp(cfg)
+	if err := handlers.LoadTemplates(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var backupsStopped <-chan struct{}
+	if cfg.BackupInterval.Duration > 0 && !database.IsPostgres() {
+		backupsStopped = backupManager(cfg).Schedule(ctx, cfg.BackupInterval.Duration)
+	}
+
+	mux := http.NewServeMux()
+
+	// Static files
+	mux.Handle("GET /static/", handlers.StaticHandler())
+
+	// Public Routes
+	mux.HandleFunc("GET /{$}", handlers.LandingHandler)
+	mux.HandleFunc("/", handlers.NotFoundHandler)
+
+	// Monitoring
+	mux.HandleFunc("GET /healthz", handlers.HealthzHandler)
+	mux.HandleFunc("GET /readyz", handlers.ReadyzHandler)
+	mux.HandleFunc("GET /metrics", handlers.MetricsHandler)
+	mux.HandleFunc("GET /register", handlers.RegisterFormHandler)
+	mux.HandleFunc("POST /register/submit", app.RegisterSubmitHandler)
+	mux.HandleFunc("GET /login", app.LoginHandler)
+	mux.HandleFunc("POST /login", app.LoginHandler) // Allow POST for login submission
+	mux.HandleFunc("GET /login/2fa", app.LoginTOTPHandler)
+	mux.HandleFunc("POST /login/2fa", app.LoginTOTPHandler)
+	mux.HandleFunc("GET /forgot-password", handlers.ForgotPasswordHandler)
+	mux.HandleFunc("POST /forgot-password", handlers.ForgotPasswordHandler)
+	mux.HandleFunc("GET /reset-password", app.ResetPasswordHandler)
+	mux.HandleFunc("POST /reset-password", app.ResetPasswordHandler)
+	mux.HandleFunc("GET /logout", app.LogoutHandler)
+	mux.HandleFunc("GET /admin/password", app.AuthMiddleware(app.ChangePasswordHandler))
+	mux.HandleFunc("POST /admin/password", app.AuthMiddleware(handlers.AuditSelf("change_password", "users", "id", app.ChangePasswordHandler)))
+	mux.HandleFunc("GET /admin/2fa", app.AuthMiddleware(handlers.TwoFactorHandler))
+	mux.HandleFunc("POST /admin/2fa/enable", app.AuthMiddleware(handlers.AuditSelf("enable_2fa", "users", "id", handlers.TwoFactorEnableHandler)))
+	mux.HandleFunc("POST /admin/2fa/recovery", app.AuthMiddleware(handlers.AuditSelf("regenerate_recovery_codes", "recovery_codes", "user_id", handlers.TwoFactorRecoveryHandler)))
+	mux.HandleFunc("POST /admin/2fa/disable", app.AuthMiddleware(handlers.AuditSelf("disable_2fa", "users", "id", app.TwoFactorDisableHandler)))
+	mux.HandleFunc("GET /admin/sessions", app.AuthMiddleware(app.SessionListHandler))
+	mux.HandleFunc("POST /admin/sessions/revoke", app.AuthMiddleware(handlers.Audit("revoke", "sessions", "id", app.SessionRevokeHandler)))
+	mux.HandleFunc("POST /admin/sessions/revoke-all", app.AuthMiddleware(handlers.AuditSelf("revoke_all", "sessions", "user_id", app.SessionRevokeAllHandler)))
+
+	// Volunteer Portal Routes (signed link, no account)
+	mux.HandleFunc("GET /volunteer", handlers.VolunteerPortalHandler)
+	mux.HandleFunc("POST /volunteer/signup", handlers.VolunteerSignupHandler)
+	mux.HandleFunc("POST /volunteer/cancel", handlers.VolunteerCancelHandler)
+
+	// Admin Routes (Protected). handlers.Require checks the user's role
+	// grants the permission each route needs.
+	mux.HandleFunc("GET /admin/dashboard", app.Require(handlers.PermView, app.DashboardHandler))
+	mux.HandleFunc("GET /admin/dashboard-data", app.Require(handlers.PermView, app.DashboardDataHandler))
+	mux.HandleFunc("GET /admin/users", app.Require(handlers.PermUsers, app.AdminListHandler))
+	mux.HandleFunc("GET /admin/users/create", app.Require(handlers.PermUsers, handlers.AdminCreateHandler))
+	mux.HandleFunc("POST /admin/users/store", app.Require(handlers.PermUsers, handlers.Audit("create", "users", "", app.AdminStoreHandler)))
+	mux.HandleFunc("GET /admin/users/edit", app.Require(handlers.PermUsers, app.AdminEditHandler))
+	mux.HandleFunc("POST /admin/users/update", app.Require(handlers.PermUsers, handlers.Audit("update", "users", "id", app.AdminUpdateHandler)))
+	mux.HandleFunc("POST /admin/users/delete", app.Require(handlers.PermUsers, handlers.Audit("delete", "users", "id", app.AdminDeleteHandler)))
+	mux.HandleFunc("POST /admin/users/toggle-status", app.Require(handlers.PermUsers, handlers.Audit("toggle_status", "users", "id", app.AdminToggleStatusHandler)))
+	mux.HandleFunc("GET /admin/users/login-attempts", app.Require(handlers.PermUsers, app.LoginAttemptsHandler))
+	mux.HandleFunc("POST /admin/users/require-2fa", app.Require(handlers.PermUsers, handlers.AdminRequire2FAHandler))
+	mux.HandleFunc("POST /admin/users/reset-2fa", app.Require(handlers.PermUsers, handlers.Audit("reset_2fa", "users", "id", app.AdminReset2FAHandler)))
+	mux.HandleFunc("GET /admin/database", app.Require(handlers.PermUsers, handlers.DatabaseCheckHandler))
+	mux.HandleFunc("POST /admin/database/repair", app.Require(handlers.PermUsers, handlers.DatabaseRepairHandler))
+	mux.HandleFunc("GET /admin/backups", app.Require(handlers.PermUsers, handlers.BackupListHandler))
+	mux.HandleFunc("POST /admin/backups/create", app.Require(handlers.PermUsers, handlers.BackupCreateHandler))
+	mux.HandleFunc("GET /admin/backups/download", app.Require(handlers.PermUsers, handlers.BackupDownloadHandler))
+	mux.HandleFunc("GET /admin/audit", app.Require(handlers.PermUsers, handlers.AuditListHandler))
+	mux.HandleFunc("GET /admin/audit/export", app.Require(handlers.PermUsers, handlers.AuditExportHandler))
+
+	// Event Management Routes (Protected)
+	mux.HandleFunc("GET /admin/events", app.Require(handlers.PermView, app.EventListHandler))
+	mux.HandleFunc("GET /admin/events/create", app.Require(handlers.PermManage, app.EventCreateHandler))
+	mux.HandleFunc("POST /admin/events/store", app.Require(handlers.PermManage, handlers.Audit("create", "events", "", app.EventStoreHandler)))
+	mux.HandleFunc("GET /admin/events/edit", app.Require(handlers.PermManage, app.EventEditHandler))
+	mux.HandleFunc("POST /admin/events/update", app.Require(handlers.PermManage, handlers.Audit("update", "events", "id", app.EventUpdateHandler)))
+	mux.HandleFunc("POST /admin/events/delete", app.Require(handlers.PermManage, handlers.Audit("delete", "events", "id", app.EventDeleteHandler)))
+
+	// Attendance Routes (Protected)
+	mux.HandleFunc("GET /admin/attendance", app.Require(handlers.PermAttendance, app.AttendanceHandler))
+	mux.HandleFunc("POST /admin/attendance/store", app.Require(handlers.PermAttendance, handlers.Audit("update", "attendance", "event_id", app.AttendanceStoreHandler)))
+
+	// Posada Route Planner Routes (Protected)
+	mux.HandleFunc("GET /admin/hosts", app.Require(handlers.PermView, handlers.HostListHandler))
+	mux.HandleFunc("GET /admin/hosts/create", app.Require(handlers.PermManage, handlers.HostCreateHandler))
+	mux.HandleFunc("POST /admin/hosts/store", app.Require(handlers.PermManage, handlers.Audit("create", "hosts", "", handlers.HostStoreHandler)))
+	mux.HandleFunc("GET /admin/hosts/edit", app.Require(handlers.PermManage, handlers.HostEditHandler))
+	mux.HandleFunc("POST /admin/hosts/update", app.Require(handlers.PermManage, handlers.Audit("update", "hosts", "id", handlers.HostUpdateHandler)))
+	mux.HandleFunc("POST /admin/hosts/delete", app.Require(handlers.PermManage, handlers.Audit("delete", "hosts", "id", handlers.HostDeleteHandler)))
+	mux.HandleFunc("GET /admin/posadas", app.Require(handlers.PermView, handlers.NightListHandler))
+	mux.HandleFunc("POST /admin/posadas/generate", app.Require(handlers.PermManage, handlers.Audit("create", "posada_nights", "year", handlers.NightGenerateHandler)))
+	mux.HandleFunc("GET /admin/posadas/edit", app.Require(handlers.PermManage, handlers.NightEditHandler))
+	mux.HandleFunc("POST /admin/posadas/update", app.Require(handlers.PermManage, handlers.Audit("update", "posada_nights", "id", handlers.NightUpdateHandler)))
+	mux.HandleFunc("GET /admin/posadas/itinerary", app.Require(handlers.PermView, handlers.NightItineraryHandler))
+	mux.HandleFunc("POST /admin/posadas/stops/store", app.Require(handlers.PermManage, handlers.Audit("create", "route_stops", "", handlers.RouteStopStoreHandler)))
+	mux.HandleFunc("POST /admin/posadas/stops/delete", app.Require(handlers.PermManage, handlers.Audit("delete", "route_stops", "id", handlers.RouteStopDeleteHandler)))
+	mux.HandleFunc("POST /admin/posadas/stops/move", app.Require(handlers.PermManage, handlers.Audit("move", "route_stops", "id", handlers.RouteStopMoveHandler)))
+
+	// Group Management Routes (Protected)
+	mux.HandleFunc("GET /admin/groups", app.Require(handlers.PermView, handlers.GroupListHandler))
+	mux.HandleFunc("GET /admin/groups/create", app.Require(handlers.PermManage, handlers.GroupCreateHandler))
+	mux.HandleFunc("POST /admin/groups/store", app.Require(handlers.PermManage, handlers.Audit("create", "participant_groups", "", handlers.GroupStoreHandler)))
+	mux.HandleFunc("GET /admin/groups/edit", app.Require(handlers.PermManage, handlers.GroupEditHandler))
+	mux.HandleFunc("POST /admin/groups/update", app.Require(handlers.PermManage, handlers.Audit("update", "participant_groups", "id", handlers.GroupUpdateHandler)))
+	mux.HandleFunc("POST /admin/groups/delete", app.Require(handlers.PermManage, handlers.Audit("delete", "participant_groups", "id", handlers.GroupDeleteHandler)))
+	mux.HandleFunc("GET /admin/groups/members", app.Require(handlers.PermView, handlers.GroupMembersHandler))
+	mux.HandleFunc("POST /admin/groups/members/store", app.Require(handlers.PermManage, handlers.Audit("create", "group_members", "", handlers.GroupMemberStoreHandler)))
+	mux.HandleFunc("POST /admin/groups/members/delete", app.Require(handlers.PermManage, handlers.Audit("delete", "group_members", "id", handlers.GroupMemberDeleteHandler)))
+	mux.HandleFunc("POST /admin/groups/members/toggle-leader", app.Require(handlers.PermManage, handlers.Audit("toggle_leader", "group_members", "id", handlers.GroupMemberToggleLeaderHandler)))
+
+	// Casting Routes (Protected)
+	mux.HandleFunc("GET /admin/casting", app.Require(handlers.PermView, handlers.CastingListHandler))
+	mux.HandleFunc("GET /admin/casting/create", app.Require(handlers.PermManage, handlers.CastRoleCreateHandler))
+	mux.HandleFunc("POST /admin/casting/store", app.Require(handlers.PermManage, handlers.Audit("create", "cast_roles", "", handlers.CastRoleStoreHandler)))
+	mux.HandleFunc("GET /admin/casting/edit", app.Require(handlers.PermManage, handlers.CastRoleEditHandler))
+	mux.HandleFunc("POST /admin/casting/update", app.Require(handlers.PermManage, handlers.Audit("update", "cast_roles", "id", handlers.CastRoleUpdateHandler)))
+	mux.HandleFunc("POST /admin/casting/delete", app.Require(handlers.PermManage, handlers.Audit("delete", "cast_roles", "id", handlers.CastRoleDeleteHandler)))
+	mux.HandleFunc("GET /admin/casting/role", app.Require(handlers.PermView, handlers.CastRoleHandler))
+	mux.HandleFunc("POST /admin/casting/assign", app.Require(handlers.PermManage, handlers.Audit("create", "cast_assignments", "", handlers.CastAssignmentStoreHandler)))
+	mux.HandleFunc("POST /admin/casting/unassign", app.Require(handlers.PermManage, handlers.Audit("delete", "cast_assignments", "id", handlers.CastAssignmentDeleteHandler)))
+
+	// Participant Routes (Protected)
+	mux.HandleFunc("GET /admin/participants/view", app.Require(handlers.PermView, app.ParticipantProfileHandler))
+
+	// Volunteer Routes (Protected)
+	mux.HandleFunc("GET /admin/volunteers", app.Require(handlers.PermView, handlers.VolunteerListHandler))
+	mux.HandleFunc("GET /admin/volunteers/create", app.Require(handlers.PermManage, handlers.VolunteerCreateHandler))
+	mux.HandleFunc("POST /admin/volunteers/store", app.Require(handlers.PermManage, handlers.Audit("create", "volunteers", "", handlers.VolunteerStoreHandler)))
+	mux.HandleFunc("GET /admin/volunteers/edit", app.Require(handlers.PermManage, handlers.VolunteerEditHandler))
+	mux.HandleFunc("POST /admin/volunteers/update", app.Require(handlers.PermManage, handlers.Audit("update", "volunteers", "id", handlers.VolunteerUpdateHandler)))
+	mux.HandleFunc("POST /admin/volunteers/delete", app.Require(handlers.PermManage, handlers.Audit("delete", "volunteers", "id", handlers.VolunteerDeleteHandler)))
+	mux.HandleFunc("GET /admin/events/staffing", app.Require(handlers.PermView, handlers.EventStaffingHandler))
+	mux.HandleFunc("POST /admin/events/staffing/slots/store", app.Require(handlers.PermManage, handlers.Audit("create", "shift_slots", "", handlers.ShiftSlotStoreHandler)))
+	mux.HandleFunc("POST /admin/events/staffing/slots/delete", app.Require(handlers.PermManage, handlers.Audit("delete", "shift_slots", "id", handlers.ShiftSlotDeleteHandler)))
+	mux.HandleFunc("POST /admin/events/staffing/signups/store", app.Require(handlers.PermManage, handlers.Audit("create", "shift_signups", "", handlers.ShiftSignupStoreHandler)))
+	mux.HandleFunc("POST /admin/events/staffing/signups/delete", app.Require(handlers.PermManage, handlers.Audit("delete", "shift_signups", "id", handlers.ShiftSignupDeleteHandler)))
+
+	srv := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           handlers.RequestLogger(handlers.SecurityHeaders(handlers.CSRFMiddleware(handlers.RecordRoute(mux)))),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       cfg.ReadTimeout.Duration,
+		WriteTimeout:      cfg.WriteTimeout.Duration,
+		IdleTimeout:       cfg.IdleTimeout.Duration,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(logHandler, slog.LevelWarn),
+	}
+	if srv.ReadHeaderTimeout > srv.ReadTimeout {
+		srv.ReadHeaderTimeout = srv.ReadTimeout
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("server starting", "addr", cfg.ListenAddr, "env", cfg.Env, "tls", cfg.TLS())
+		if cfg.TLS() {
+			serveErr <- srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			serveErr <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("shutting down, waiting for requests in progress", "timeout", cfg.ShutdownTimeout.Duration)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("shutting down the server", "err", err)
+	}
+	if backupsStopped != nil {
+		<-backupsStopped
+	}
+	if err := database.DB.Close(); err != nil {
+		slog.Error("closing the database", "err", err)
+	}
+	slog.Info("server stopped")
+}
+
+// setupLogging sends the log and slog output through a handler of the
+// configured format and level, which adds the request ID to what is logged
+// while serving a request
+func setupLogging(cfg *config.Config) slog.Handler {
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.LogLevel))
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	if cfg.LogFormat == "json" {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		h = slog.NewTextHandler(os.Stderr, opts)
+	}
+	h = handlers.LogHandler(h)
+	slog.SetDefault(slog.New(h))
+	return h
+}
@@ -0,0 +1,146 @@
+// LineChart draws the dashboard's line charts on a canvas. It takes the
+// part of the Chart.js configuration the dashboard uses (labels, datasets
+// with label, data, borderColor and backgroundColor, a title and a y axis
+// title) so the page has no third-party script to load.
+(function () {
+    'use strict';
+
+    const padding = { top: 56, right: 16, bottom: 36, left: 56 };
+    const font = '12px system-ui, -apple-system, "Segoe UI", Roboto, sans-serif';
+
+    function LineChart(ctx, config) {
+        this.ctx = ctx;
+        this.canvas = ctx.canvas;
+        this.data = config.data;
+        this.options = config.options || {};
+        this.aspect = this.canvas.width / this.canvas.height || 2;
+
+        const chart = this;
+        window.addEventListener('resize', function () { chart.update(); });
+        this.update();
+    }
+
+    // niceStep returns a round step that splits max into about ticks parts
+    function niceStep(max, ticks) {
+        const raw = max / ticks;
+        const magnitude = Math.pow(10, Math.floor(Math.log10(raw)));
+        const steps = [1, 2, 5, 10];
+        for (let i = 0; i < steps.length; i++) {
+            if (raw <= steps[i] * magnitude) {
+                return Math.max(1, steps[i] * magnitude);
+            }
+        }
+        return 10 * magnitude;
+    }
+
+    LineChart.prototype.update = function () {
+        const canvas = this.canvas;
+        const ctx = this.ctx;
+        const ratio = window.devicePixelRatio || 1;
+        const width = canvas.parentElement ? canvas.parentElement.clientWidth : canvas.width;
+        const height = Math.round(width / this.aspect);
+        canvas.style.width = width + 'px';
+        canvas.style.height = height + 'px';
+        canvas.width = width * ratio;
+        canvas.height = height * ratio;
+        ctx.setTransform(ratio, 0, 0, ratio, 0, 0);
+        ctx.clearRect(0, 0, width, height);
+        ctx.font = font;
+
+        const labels = this.data.labels || [];
+        const datasets = this.data.datasets || [];
+        const plugins = this.options.plugins || {};
+        const yTitle = ((this.options.scales || {}).y || {}).title;
+
+        // Title and legend
+        ctx.fillStyle = '#333';
+        ctx.textAlign = 'center';
+        ctx.textBaseline = 'top';
+        if (plugins.title && plugins.title.display) {
+            ctx.font = 'bold 14px system-ui, -apple-system, "Segoe UI", Roboto, sans-serif';
+            ctx.fillText(plugins.title.text, width / 2, 4);
+            ctx.font = font;
+        }
+        let legendX = width / 2 - datasets.length * 50;
+        datasets.forEach(function (dataset) {
+            ctx.fillStyle = dataset.borderColor;
+            ctx.fillRect(legendX, 28, 24, 10);
+            ctx.fillStyle = '#333';
+            ctx.textAlign = 'left';
+            ctx.fillText(dataset.label, legendX + 30, 27);
+            legendX += 100;
+        });
+
+        // Axes
+        const plotWidth = width - padding.left - padding.right;
+        const plotHeight = height - padding.top - padding.bottom;
+        let max = 0;
+        datasets.forEach(function (dataset) {
+            dataset.data.forEach(function (value) { max = Math.max(max, value); });
+        });
+        const step = niceStep(max || 1, 5);
+        const top = Math.max(step, Math.ceil(max / step) * step);
+        const y = function (value) { return padding.top + plotHeight - value / top * plotHeight; };
+        const x = function (i) {
+            return labels.length > 1 ? padding.left + i * plotWidth / (labels.length - 1) : padding.left + plotWidth / 2;
+        };
+
+        ctx.strokeStyle = '#e5e5e5';
+        ctx.lineWidth = 1;
+        ctx.textAlign = 'right';
+        ctx.textBaseline = 'middle';
+        for (let value = 0; value <= top; value += step) {
+            ctx.beginPath();
+            ctx.moveTo(padding.left, y(value));
+            ctx.lineTo(padding.left + plotWidth, y(value));
+            ctx.stroke();
+            ctx.fillStyle = '#666';
+            ctx.fillText(String(value), padding.left - 6, y(value));
+        }
+        ctx.textAlign = 'center';
+        ctx.textBaseline = 'top';
+        labels.forEach(function (label, i) {
+            ctx.fillText(label, x(i), padding.top + plotHeight + 8);
+        });
+        if (yTitle && yTitle.display) {
+            ctx.save();
+            ctx.translate(12, padding.top + plotHeight / 2);
+            ctx.rotate(-Math.PI / 2);
+            ctx.fillText(yTitle.text, 0, -6);
+            ctx.restore();
+        }
+
+        // Lines, with the area under them filled
+        datasets.forEach(function (dataset) {
+            if (dataset.data.length === 0) {
+                return;
+            }
+            ctx.beginPath();
+            dataset.data.forEach(function (value, i) {
+                if (i === 0) {
+                    ctx.moveTo(x(i), y(value));
+                } else {
+                    ctx.lineTo(x(i), y(value));
+                }
+            });
+            ctx.strokeStyle = dataset.borderColor;
+            ctx.lineWidth = 2;
+            ctx.stroke();
+
+            ctx.lineTo(x(dataset.data.length - 1), y(0));
+            ctx.lineTo(x(0), y(0));
+            ctx.closePath();
+            ctx.fillStyle = dataset.backgroundColor;
+            ctx.fill();
+
+            ctx.fillStyle = dataset.borderColor;
+            dataset.data.forEach(function (value, i) {
+                ctx.beginPath();
+                ctx.arc(x(i), y(value), 3, 0, 2 * Math.PI);
+                ctx.fill();
+            });
+        });
+    };
+
+    window.LineChart = LineChart;
+})();
@@ -0,0 +1,668 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"posadas-sistema/models"
+)
+
+// Memory is an in-memory fake of the stores, for tests. Groups and their
+// members are edited outside these stores, so tests add them with AddGroup
+// and GroupMembers.
+type Memory struct {
+	mu            sync.Mutex
+	registrations map[int]models.Registration
+	events        map[int]models.Event
+	attendance    map[[2]int]models.Attendance // keyed by event and registration
+	groups        map[int]models.Group
+	eventGroups   map[int][]int // group IDs by event ID
+	users         map[int]models.User
+	sessions      map[string]models.Session
+	revoked       map[string]bool // session IDs
+	loginAttempts []models.LoginAttempt
+	lastID        int
+
+	// GroupMembers maps a group ID to the registration IDs of its members
+	GroupMembers map[int][]int
+}
+
+// NewMemory returns an empty in-memory fake
+func NewMemory() *Memory {
+	return &Memory{
+		registrations: map[int]models.Registration{},
+		events:        map[int]models.Event{},
+		attendance:    map[[2]int]models.Attendance{},
+		groups:        map[int]models.Group{},
+		eventGroups:   map[int][]int{},
+		users:         map[int]models.User{},
+		sessions:      map[string]models.Session{},
+		revoked:       map[string]bool{},
+		GroupMembers:  map[int][]int{},
+	}
+}
+
+// Stores returns the stores backed by m
+func (m *Memory) Stores() Stores {
+	return Stores{
+		Registrations: memoryRegistrations{m},
+		Events:        memoryEvents{m},
+		Attendance:    memoryAttendance{m},
+		Groups:        memoryGroups{m},
+		Users:         memoryUsers{m},
+		Sessions:      memorySessions{m},
+		LoginAttempts: memoryLoginAttempts{m},
+	}
+}
+
+// nextID returns a new ID; IDs are unique across all the fake's tables
+func (m *Memory) nextID() int {
+	m.lastID++
+	return m.lastID
+}
+
+// ===== REGISTRATIONS =====
+
+type memoryRegistrations struct{ *Memory }
+
+func (m memoryRegistrations) List() ([]models.Registration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var registrations []models.Registration
+	for _, reg := range m.registrations {
+		registrations = append(registrations, reg)
+	}
+	sort.Slice(registrations, func(i, j int) bool {
+		if !registrations[i].CreatedAt.Equal(registrations[j].CreatedAt) {
+			return registrations[i].CreatedAt.After(registrations[j].CreatedAt)
+		}
+		return registrations[i].ID > registrations[j].ID
+	})
+	return registrations, nil
+}
+
+func (m memoryRegistrations) ListByName(groupID int) ([]models.Registration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var registrations []models.Registration
+	if groupID > 0 {
+		for _, id := range m.GroupMembers[groupID] {
+			if reg, ok := m.registrations[id]; ok {
+				registrations = append(registrations, reg)
+			}
+		}
+	} else {
+		for _, reg := range m.registrations {
+			registrations = append(registrations, reg)
+		}
+	}
+	sort.Slice(registrations, func(i, j int) bool { return registrations[i].Name < registrations[j].Name })
+	return registrations, nil
+}
+
+func (m memoryRegistrations) Get(id int) (models.Registration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reg, ok := m.registrations[id]
+	if !ok {
+		return models.Registration{}, ErrNotFound
+	}
+	return reg, nil
+}
+
+func (m memoryRegistrations) Create(reg *models.Registration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reg.ID = m.nextID()
+	reg.CreatedAt = time.Now()
+	m.registrations[reg.ID] = *reg
+	return nil
+}
+
+// ===== EVENTS =====
+
+type memoryEvents struct{ *Memory }
+
+func (m memoryEvents) List() ([]models.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []models.Event
+	for _, event := range m.events {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Date.After(events[j].Date) })
+	return events, nil
+}
+
+func (m memoryEvents) Get(id int) (models.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event, ok := m.events[id]
+	if !ok {
+		return models.Event{}, ErrNotFound
+	}
+	return event, nil
+}
+
+func (m memoryEvents) Create(event *models.Event, groupIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.ID = m.nextID()
+	event.CreatedAt = time.Now()
+	m.events[event.ID] = *event
+	m.eventGroups[event.ID] = append([]int(nil), groupIDs...)
+	return nil
+}
+
+func (m memoryEvents) Update(event models.Event, groupIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.events[event.ID]
+	if !ok {
+		return nil
+	}
+	event.CreatedAt = old.CreatedAt
+	m.events[event.ID] = event
+	m.eventGroups[event.ID] = append([]int(nil), groupIDs...)
+	return nil
+}
+
+func (m memoryEvents) Delete(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.events, id)
+	// Like ON DELETE CASCADE
+	for key := range m.attendance {
+		if key[0] == id {
+			delete(m.attendance, key)
+		}
+	}
+	delete(m.eventGroups, id)
+	return nil
+}
+
+func (m memoryEvents) CountByType() (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := map[string]int{}
+	for _, event := range m.events {
+		counts[event.Type]++
+	}
+	return counts, nil
+}
+
+// ===== ATTENDANCE =====
+
+type memoryAttendance struct{ *Memory }
+
+func (m memoryAttendance) ForEvent(eventID int) (map[int]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	marks := map[int]bool{}
+	for key, mark := range m.attendance {
+		if key[0] == eventID {
+			marks[key[1]] = mark.Present
+		}
+	}
+	return marks, nil
+}
+
+func (m memoryAttendance) Replace(eventID int, marks []models.Attendance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, mark := range marks {
+		mark.ID = m.nextID()
+		mark.EventID = eventID
+		mark.MarkedAt = time.Now()
+		m.attendance[[2]int{eventID, mark.RegistrationID}] = mark
+	}
+	return nil
+}
+
+func (m memoryAttendance) CountPresent() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, mark := range m.attendance {
+		if mark.Present {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m memoryAttendance) Monthly() ([]MonthlyAttendance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Like the SQL LEFT JOIN, an event without marks still counts as one row
+	byKey := map[[2]string]*MonthlyAttendance{}
+	for _, event := range m.events {
+		key := [2]string{event.Date.Format("2006-01"), event.Type}
+		month, ok := byKey[key]
+		if !ok {
+			month = &MonthlyAttendance{Month: key[0], Type: key[1]}
+			byKey[key] = month
+		}
+		marked := 0
+		for k, mark := range m.attendance {
+			if k[0] != event.ID {
+				continue
+			}
+			marked++
+			if mark.Present {
+				month.Attendances++
+			}
+		}
+		if marked == 0 {
+			marked = 1
+		}
+		month.Total += marked
+	}
+
+	var months []MonthlyAttendance
+	for _, month := range byKey {
+		months = append(months, *month)
+	}
+	sort.Slice(months, func(i, j int) bool {
+		if months[i].Month != months[j].Month {
+			return months[i].Month < months[j].Month
+		}
+		return months[i].Type < months[j].Type
+	})
+	return months, nil
+}
+
+// ===== GROUPS =====
+
+type memoryGroups struct{ *Memory }
+
+// AddGroup saves group and sets its ID
+func (m *Memory) AddGroup(group *models.Group) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group.ID = m.nextID()
+	group.CreatedAt = time.Now()
+	m.groups[group.ID] = *group
+}
+
+func (m memoryGroups) List() ([]models.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var groups []models.Group
+	for _, group := range m.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Year != groups[j].Year {
+			return groups[i].Year > groups[j].Year
+		}
+		return groups[i].Name < groups[j].Name
+	})
+	return groups, nil
+}
+
+func (m memoryGroups) ForEvent(eventID int) (map[int]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	selected := map[int]bool{}
+	for _, groupID := range m.eventGroups[eventID] {
+		selected[groupID] = true
+	}
+	return selected, nil
+}
+
+func (m memoryGroups) Attendance() ([]GroupAttendance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var groups []GroupAttendance
+	for _, group := range m.groups {
+		members := map[int]bool{}
+		for _, id := range m.GroupMembers[group.ID] {
+			members[id] = true
+		}
+		summary := GroupAttendance{Name: group.Name, Year: group.Year, Members: len(members)}
+		for eventID, groupIDs := range m.eventGroups {
+			event, ok := m.events[eventID]
+			if !ok || event.Date.Year() != group.Year || !slices.Contains(groupIDs, group.ID) {
+				continue
+			}
+			for id := range members {
+				if mark, ok := m.attendance[[2]int{eventID, id}]; ok {
+					summary.Marked++
+					if mark.Present {
+						summary.Attendances++
+					}
+				}
+			}
+		}
+		groups = append(groups, summary)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Year != groups[j].Year {
+			return groups[i].Year > groups[j].Year
+		}
+		return groups[i].Name < groups[j].Name
+	})
+	return groups, nil
+}
+
+// ===== USERS =====
+
+// errDuplicateUsername mirrors the UNIQUE constraint of users.username
+var errDuplicateUsername = errors.New("UNIQUE constraint failed: users.username")
+
+type memoryUsers struct{ *Memory }
+
+// withoutPassword returns user as the SQL store would read it back
+func withoutPassword(user models.User) models.User {
+	user.Password = ""
+	return user
+}
+
+// leavesNoSuperadmin reports whether replacing user id with after, or
+// deleting it if after is nil, takes away the last active superadmin
+func (m *Memory) leavesNoSuperadmin(id int, after *models.User) bool {
+	isSuperadmin := func(user models.User) bool { return user.Role == superadminRole && user.IsActive }
+	before, left := 0, 0
+	for _, user := range m.users {
+		if isSuperadmin(user) {
+			before++
+		}
+		if user.ID == id {
+			if after != nil && isSuperadmin(*after) {
+				left++
+			}
+		} else if isSuperadmin(user) {
+			left++
+		}
+	}
+	return before > 0 && left == 0
+}
+
+func (m memoryUsers) List() ([]models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var users []models.User
+	for _, user := range m.users {
+		users = append(users, withoutPassword(user))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (m memoryUsers) Get(id int) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	return withoutPassword(user), nil
+}
+
+func (m memoryUsers) Create(user *models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Usernames are unique, like the column
+	for _, existing := range m.users {
+		if existing.Username == user.Username {
+			return errDuplicateUsername
+		}
+	}
+	user.ID = m.nextID()
+	m.users[user.ID] = *user
+	return nil
+}
+
+func (m memoryUsers) Update(user models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.users[user.ID]
+	if !ok {
+		return nil
+	}
+	old.Username = user.Username
+	old.Email = user.Email
+	old.Role = user.Role
+	old.MustChangePassword = user.MustChangePassword
+	if user.Password != "" {
+		old.Password = user.Password
+	}
+	if m.leavesNoSuperadmin(user.ID, &old) {
+		return ErrLastSuperadmin
+	}
+	m.users[user.ID] = old
+	return nil
+}
+
+func (m memoryUsers) SetActive(id int, active bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user, ok := m.users[id]; ok {
+		user.IsActive = active
+		if m.leavesNoSuperadmin(id, &user) {
+			return ErrLastSuperadmin
+		}
+		m.users[id] = user
+	}
+	return nil
+}
+
+func (m memoryUsers) FindByUsername(username string) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (m memoryUsers) PasswordHash(id int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return user.Password, nil
+}
+
+func (m memoryUsers) SetPassword(id int, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user, ok := m.users[id]; ok {
+		user.Password = hash
+		user.MustChangePassword = false
+		m.users[id] = user
+	}
+	return nil
+}
+
+func (m memoryUsers) RequirePasswordChange(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user, ok := m.users[id]; ok {
+		user.MustChangePassword = true
+		m.users[id] = user
+	}
+	return nil
+}
+
+// DisableTOTP only clears the flag; the secret and recovery codes aren't
+// kept in memory
+func (m memoryUsers) DisableTOTP(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user, ok := m.users[id]; ok {
+		user.TOTPEnabled = false
+		m.users[id] = user
+	}
+	return nil
+}
+
+func (m memoryUsers) Delete(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.leavesNoSuperadmin(id, nil) {
+		return ErrLastSuperadmin
+	}
+	delete(m.users, id)
+	// Like ON DELETE CASCADE
+	for sessionID, session := range m.sessions {
+		if session.UserID == id {
+			delete(m.sessions, sessionID)
+		}
+	}
+	return nil
+}
+
+// ===== SESSIONS =====
+
+type memorySessions struct{ *Memory }
+
+func (m memorySessions) Create(session models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, old := range m.sessions {
+		if old.ExpiresAt.Before(now) {
+			delete(m.sessions, id)
+		}
+	}
+	m.sessions[session.ID] = session
+	return nil
+}
+
+func (m memorySessions) Lookup(id string) (SessionState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return SessionState{}, ErrNotFound
+	}
+	user, ok := m.users[session.UserID]
+	if !ok {
+		return SessionState{}, ErrNotFound
+	}
+	return SessionState{Session: session, Revoked: m.revoked[id], User: withoutPassword(user)}, nil
+}
+
+func (m memorySessions) Touch(id, ip string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, ok := m.sessions[id]; ok {
+		session.LastSeenAt = at
+		session.IP = ip
+		m.sessions[id] = session
+	}
+	return nil
+}
+
+func (m memorySessions) Revoke(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sessions[id]; ok {
+		m.revoked[id] = true
+	}
+	return nil
+}
+
+func (m memorySessions) Active(userID int) ([]models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var sessions []models.Session
+	for id, session := range m.sessions {
+		if session.UserID == userID && !m.revoked[id] && session.ExpiresAt.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions, nil
+}
+
+func (m memorySessions) RevokeUser(userID int, keepID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, session := range m.sessions {
+		if session.UserID == userID && id != keepID {
+			m.revoked[id] = true
+		}
+	}
+	return nil
+}
+
+// ===== LOGIN ATTEMPTS =====
+
+type memoryLoginAttempts struct{ *Memory }
+
+func (m memoryLoginAttempts) Record(attempt models.LoginAttempt, keep time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	kept := m.loginAttempts[:0]
+	for _, old := range m.loginAttempts {
+		if !old.CreatedAt.Before(now.Add(-keep)) {
+			kept = append(kept, old)
+		}
+	}
+	attempt.ID = m.nextID()
+	attempt.CreatedAt = now
+	m.loginAttempts = append(kept, attempt)
+	return nil
+}
+
+func (m memoryLoginAttempts) List(username string, limit int) ([]models.LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Newest first; IDs grow with time, like created_at and id in SQL
+	var attempts []models.LoginAttempt
+	for i := len(m.loginAttempts) - 1; i >= 0 && len(attempts) < limit; i-- {
+		if username == "" || m.loginAttempts[i].Username == username {
+			attempts = append(attempts, m.loginAttempts[i])
+		}
+	}
+	return attempts, nil
+}
@@ -0,0 +1,623 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"posadas-sistema/database"
+	"posadas-sistema/models"
+)
+
+// eventDateLayout is how event dates are kept in the date column
+const eventDateLayout = "2006-01-02"
+
+// NewSQL returns the stores backed by db
+func NewSQL(db *sql.DB) Stores {
+	return Stores{
+		Registrations: &sqlRegistrations{db},
+		Events:        &sqlEvents{db},
+		Attendance:    &sqlAttendance{db},
+		Groups:        &sqlGroups{db},
+		Users:         &sqlUsers{db},
+		Sessions:      &sqlSessions{db},
+		LoginAttempts: &sqlLoginAttempts{db},
+	}
+}
+
+// notFound turns sql.ErrNoRows into ErrNotFound
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ===== REGISTRATIONS =====
+
+type sqlRegistrations struct {
+	db *sql.DB
+}
+
+const registrationColumns = "id, name, age, dni, COALESCE(guardian_name, ''), COALESCE(guardian_contact, ''), year, created_at"
+
+func scanRegistration(row interface{ Scan(...interface{}) error }) (models.Registration, error) {
+	var reg models.Registration
+	err := row.Scan(&reg.ID, &reg.Name, &reg.Age, &reg.DNI, &reg.GuardianName, &reg.GuardianContact, &reg.Year, &reg.CreatedAt)
+	return reg, err
+}
+
+func (s *sqlRegistrations) query(query string, args ...interface{}) ([]models.Registration, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var registrations []models.Registration
+	for rows.Next() {
+		reg, err := scanRegistration(rows)
+		if err != nil {
+			return nil, err
+		}
+		registrations = append(registrations, reg)
+	}
+	return registrations, rows.Err()
+}
+
+func (s *sqlRegistrations) List() ([]models.Registration, error) {
+	return s.query("SELECT " + registrationColumns + " FROM registrations ORDER BY created_at DESC")
+}
+
+func (s *sqlRegistrations) ListByName(groupID int) ([]models.Registration, error) {
+	if groupID > 0 {
+		return s.query(`SELECT r.id, r.name, r.age, r.dni, COALESCE(r.guardian_name, ''), COALESCE(r.guardian_contact, ''), r.year, r.created_at
+			FROM registrations r
+			JOIN group_members m ON m.registration_id = r.id
+			WHERE m.group_id = ? ORDER BY r.name`, groupID)
+	}
+	return s.query("SELECT " + registrationColumns + " FROM registrations ORDER BY name")
+}
+
+func (s *sqlRegistrations) Get(id int) (models.Registration, error) {
+	reg, err := scanRegistration(s.db.QueryRow("SELECT "+registrationColumns+" FROM registrations WHERE id = ?", id))
+	return reg, notFound(err)
+}
+
+func (s *sqlRegistrations) Create(reg *models.Registration) error {
+	id, err := database.InsertID(s.db, "INSERT INTO registrations (name, age, dni, guardian_name, guardian_contact, year) VALUES (?, ?, ?, ?, ?, ?)",
+		reg.Name, reg.Age, reg.DNI, reg.GuardianName, reg.GuardianContact, reg.Year)
+	reg.ID = int(id)
+	return err
+}
+
+// ===== EVENTS =====
+
+type sqlEvents struct {
+	db *sql.DB
+}
+
+const eventColumns = "id, name, type, date, time, location, COALESCE(description, ''), created_at"
+
+func scanEvent(row interface{ Scan(...interface{}) error }) (models.Event, error) {
+	var event models.Event
+	err := row.Scan(&event.ID, &event.Name, &event.Type, &event.Date, &event.Time, &event.Location, &event.Description, &event.CreatedAt)
+	return event, err
+}
+
+func (s *sqlEvents) List() ([]models.Event, error) {
+	rows, err := s.db.Query("SELECT " + eventColumns + " FROM events ORDER BY date DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.Event
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (s *sqlEvents) Get(id int) (models.Event, error) {
+	event, err := scanEvent(s.db.QueryRow("SELECT "+eventColumns+" FROM events WHERE id = ?", id))
+	return event, notFound(err)
+}
+
+func (s *sqlEvents) Create(event *models.Event, groupIDs []int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := database.InsertID(tx, "INSERT INTO events (name, type, date, time, location, description) VALUES (?, ?, ?, ?, ?, ?)",
+		event.Name, event.Type, event.Date.Format(eventDateLayout), event.Time, event.Location, event.Description)
+	if err != nil {
+		return err
+	}
+	if err := setEventGroups(tx, int(id), groupIDs); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	event.ID = int(id)
+	return nil
+}
+
+func (s *sqlEvents) Update(event models.Event, groupIDs []int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE events SET name = ?, type = ?, date = ?, time = ?, location = ?, description = ? WHERE id = ?",
+		event.Name, event.Type, event.Date.Format(eventDateLayout), event.Time, event.Location, event.Description, event.ID)
+	if err != nil {
+		return err
+	}
+	if err := setEventGroups(tx, event.ID, groupIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setEventGroups replaces the groups an event is targeted to
+func setEventGroups(tx *sql.Tx, eventID int, groupIDs []int) error {
+	if _, err := tx.Exec("DELETE FROM event_groups WHERE event_id = ?", eventID); err != nil {
+		return err
+	}
+	for _, groupID := range groupIDs {
+		if _, err := tx.Exec("INSERT INTO event_groups (event_id, group_id) VALUES (?, ?)", eventID, groupID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlEvents) Delete(id int) error {
+	_, err := s.db.Exec("DELETE FROM events WHERE id = ?", id)
+	return err
+}
+
+func (s *sqlEvents) CountByType() (map[string]int, error) {
+	rows, err := s.db.Query("SELECT type, COUNT(*) FROM events GROUP BY type")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var eventType string
+		var count int
+		if err := rows.Scan(&eventType, &count); err != nil {
+			return nil, err
+		}
+		counts[eventType] = count
+	}
+	return counts, rows.Err()
+}
+
+// ===== ATTENDANCE =====
+
+type sqlAttendance struct {
+	db *sql.DB
+}
+
+func (s *sqlAttendance) ForEvent(eventID int) (map[int]bool, error) {
+	rows, err := s.db.Query("SELECT registration_id, present FROM attendance WHERE event_id = ?", eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	marks := map[int]bool{}
+	for rows.Next() {
+		var registrationID int
+		var present bool
+		if err := rows.Scan(&registrationID, &present); err != nil {
+			return nil, err
+		}
+		marks[registrationID] = present
+	}
+	return marks, rows.Err()
+}
+
+func (s *sqlAttendance) Replace(eventID int, marks []models.Attendance) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, mark := range marks {
+		_, err := tx.Exec("DELETE FROM attendance WHERE event_id = ? AND registration_id = ?", eventID, mark.RegistrationID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO attendance (event_id, registration_id, present, notes) VALUES (?, ?, ?, ?)",
+			eventID, mark.RegistrationID, mark.Present, mark.Notes)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlAttendance) CountPresent() (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM attendance WHERE present = TRUE").Scan(&count)
+	return count, err
+}
+
+func (s *sqlAttendance) Monthly() ([]MonthlyAttendance, error) {
+	rows, err := s.db.Query(`
+		SELECT
+			` + database.YearMonth("e.date") + ` as month,
+			e.type,
+			COUNT(CASE WHEN a.present = TRUE THEN 1 END) as attendances,
+			COUNT(*) as total_registrations
+		FROM events e
+		LEFT JOIN attendance a ON e.id = a.event_id
+		GROUP BY ` + database.YearMonth("e.date") + `, e.type
+		ORDER BY month`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var months []MonthlyAttendance
+	for rows.Next() {
+		var m MonthlyAttendance
+		if err := rows.Scan(&m.Month, &m.Type, &m.Attendances, &m.Total); err != nil {
+			return nil, err
+		}
+		months = append(months, m)
+	}
+	return months, rows.Err()
+}
+
+// ===== GROUPS =====
+
+type sqlGroups struct {
+	db *sql.DB
+}
+
+func (s *sqlGroups) List() ([]models.Group, error) {
+	rows, err := s.db.Query("SELECT id, name, year, description, created_at FROM participant_groups ORDER BY year DESC, name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []models.Group
+	for rows.Next() {
+		var group models.Group
+		if err := rows.Scan(&group.ID, &group.Name, &group.Year, &group.Description, &group.CreatedAt); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+func (s *sqlGroups) ForEvent(eventID int) (map[int]bool, error) {
+	rows, err := s.db.Query("SELECT group_id FROM event_groups WHERE event_id = ?", eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	selected := map[int]bool{}
+	for rows.Next() {
+		var groupID int
+		if err := rows.Scan(&groupID); err != nil {
+			return nil, err
+		}
+		selected[groupID] = true
+	}
+	return selected, rows.Err()
+}
+
+// Attendance only counts the events of the group's own season, so a group
+// reused from one year to the next starts from zero
+func (s *sqlGroups) Attendance() ([]GroupAttendance, error) {
+	rows, err := s.db.Query(`
+		SELECT
+			g.name,
+			g.year,
+			COUNT(DISTINCT m.registration_id) as members,
+			COUNT(CASE WHEN a.present = TRUE THEN 1 END) as attendances,
+			COUNT(a.id) as marked
+		FROM participant_groups g
+		LEFT JOIN group_members m ON m.group_id = g.id
+		LEFT JOIN event_groups eg ON eg.group_id = g.id
+		LEFT JOIN events e ON e.id = eg.event_id AND ` + database.Year("e.date") + ` = CAST(g.year AS TEXT)
+		LEFT JOIN attendance a ON a.event_id = e.id AND a.registration_id = m.registration_id
+		GROUP BY g.id
+		ORDER BY g.year DESC, g.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []GroupAttendance
+	for rows.Next() {
+		var group GroupAttendance
+		if err := rows.Scan(&group.Name, &group.Year, &group.Members, &group.Attendances, &group.Marked); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// ===== USERS =====
+
+type sqlUsers struct {
+	db *sql.DB
+}
+
+// superadminRole is the role the user store keeps at least one active
+// account of
+const superadminRole = "superadmin"
+
+const userColumns = "id, username, email, is_active, role, must_change_password, totp_enabled"
+
+func scanUser(row interface{ Scan(...interface{}) error }) (models.User, error) {
+	var user models.User
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.IsActive, &user.Role, &user.MustChangePassword, &user.TOTPEnabled)
+	return user, err
+}
+
+func (s *sqlUsers) List() ([]models.User, error) {
+	rows, err := s.db.Query("SELECT " + userColumns + " FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s *sqlUsers) Get(id int) (models.User, error) {
+	user, err := scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	return user, notFound(err)
+}
+
+func (s *sqlUsers) Create(user *models.User) error {
+	id, err := database.InsertID(s.db, "INSERT INTO users (username, password, email, is_active, role, must_change_password) VALUES (?, ?, ?, ?, ?, ?)",
+		user.Username, user.Password, user.Email, user.IsActive, user.Role, user.MustChangePassword)
+	user.ID = int(id)
+	return err
+}
+
+func (s *sqlUsers) Update(user models.User) error {
+	return s.keepingSuperadmin(func(tx *sql.Tx) error {
+		if user.Password != "" {
+			_, err := tx.Exec("UPDATE users SET username = ?, password = ?, email = ?, role = ?, must_change_password = ? WHERE id = ?",
+				user.Username, user.Password, user.Email, user.Role, user.MustChangePassword, user.ID)
+			return err
+		}
+		_, err := tx.Exec("UPDATE users SET username = ?, email = ?, role = ?, must_change_password = ? WHERE id = ?",
+			user.Username, user.Email, user.Role, user.MustChangePassword, user.ID)
+		return err
+	})
+}
+
+func (s *sqlUsers) SetActive(id int, active bool) error {
+	return s.keepingSuperadmin(func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE users SET is_active = ? WHERE id = ?", active, id)
+		return err
+	})
+}
+
+func (s *sqlUsers) FindByUsername(username string) (models.User, error) {
+	var user models.User
+	err := s.db.QueryRow("SELECT "+userColumns+", password FROM users WHERE username = ?", username).
+		Scan(&user.ID, &user.Username, &user.Email, &user.IsActive, &user.Role, &user.MustChangePassword, &user.TOTPEnabled, &user.Password)
+	return user, notFound(err)
+}
+
+func (s *sqlUsers) PasswordHash(id int) (string, error) {
+	var hash string
+	err := s.db.QueryRow("SELECT password FROM users WHERE id = ?", id).Scan(&hash)
+	return hash, notFound(err)
+}
+
+func (s *sqlUsers) SetPassword(id int, hash string) error {
+	_, err := s.db.Exec("UPDATE users SET password = ?, must_change_password = FALSE WHERE id = ?", hash, id)
+	return err
+}
+
+func (s *sqlUsers) RequirePasswordChange(id int) error {
+	_, err := s.db.Exec("UPDATE users SET must_change_password = TRUE WHERE id = ?", id)
+	return err
+}
+
+func (s *sqlUsers) DisableTOTP(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_enabled = FALSE, totp_secret = '', totp_last_counter = 0 WHERE id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete relies on ON DELETE CASCADE for the sessions, recovery codes and
+// reset links
+func (s *sqlUsers) Delete(id int) error {
+	return s.keepingSuperadmin(func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM users WHERE id = ?", id)
+		return err
+	})
+}
+
+// keepingSuperadmin runs change in a transaction and rolls it back with
+// ErrLastSuperadmin if it leaves no active superadmin. The active
+// superadmins are locked first, so two changes can't each take away one of
+// the last two.
+func (s *sqlUsers) keepingSuperadmin(change func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id FROM users WHERE role = ? AND is_active = TRUE"+database.ForUpdate(), superadminRole)
+	if err != nil {
+		return err
+	}
+	before := 0
+	for rows.Next() {
+		before++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := change(tx); err != nil {
+		return err
+	}
+
+	var after int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE role = ? AND is_active = TRUE", superadminRole).Scan(&after); err != nil {
+		return err
+	}
+	if before > 0 && after == 0 {
+		return ErrLastSuperadmin
+	}
+	return tx.Commit()
+}
+
+// ===== SESSIONS =====
+
+// sqlSessions stores times in UTC so they compare correctly as text
+type sqlSessions struct {
+	db *sql.DB
+}
+
+func (s *sqlSessions) Create(session models.Session) error {
+	if _, err := s.db.Exec("DELETE FROM sessions WHERE expires_at < ?", time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("INSERT INTO sessions (id, user_id, ip, user_agent, created_at, last_seen_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		session.ID, session.UserID, session.IP, session.UserAgent, session.CreatedAt.UTC(), session.LastSeenAt.UTC(), session.ExpiresAt.UTC())
+	return err
+}
+
+func (s *sqlSessions) Lookup(id string) (SessionState, error) {
+	var state SessionState
+	var revokedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT s.id, s.user_id, s.ip, COALESCE(s.user_agent, ''), s.created_at, s.last_seen_at, s.expires_at, s.revoked_at,
+			u.id, u.username, u.is_active, u.role, u.email, u.must_change_password, u.totp_enabled
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.id = ?`, id).
+		Scan(&state.ID, &state.UserID, &state.IP, &state.UserAgent, &state.CreatedAt, &state.LastSeenAt, &state.ExpiresAt, &revokedAt,
+			&state.User.ID, &state.User.Username, &state.User.IsActive, &state.User.Role, &state.User.Email, &state.User.MustChangePassword, &state.User.TOTPEnabled)
+	state.Revoked = revokedAt.Valid
+	return state, notFound(err)
+}
+
+func (s *sqlSessions) Touch(id, ip string, at time.Time) error {
+	_, err := s.db.Exec("UPDATE sessions SET last_seen_at = ?, ip = ? WHERE id = ?", at.UTC(), ip, id)
+	return err
+}
+
+func (s *sqlSessions) Revoke(id string) error {
+	_, err := s.db.Exec("UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now().UTC(), id)
+	return err
+}
+
+func (s *sqlSessions) Active(userID int) ([]models.Session, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, ip, COALESCE(user_agent, ''), created_at, last_seen_at, expires_at FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY last_seen_at DESC`, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(&session.ID, &session.UserID, &session.IP, &session.UserAgent, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *sqlSessions) RevokeUser(userID int, keepID string) error {
+	_, err := s.db.Exec("UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id != ? AND revoked_at IS NULL",
+		time.Now().UTC(), userID, keepID)
+	return err
+}
+
+// ===== LOGIN ATTEMPTS =====
+
+type sqlLoginAttempts struct {
+	db *sql.DB
+}
+
+func (s *sqlLoginAttempts) Record(attempt models.LoginAttempt, keep time.Duration) error {
+	now := time.Now().UTC()
+	if _, err := s.db.Exec("DELETE FROM login_attempts WHERE created_at < ?", now.Add(-keep)); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("INSERT INTO login_attempts (username, ip, user_agent, reason, created_at) VALUES (?, ?, ?, ?, ?)",
+		attempt.Username, attempt.IP, attempt.UserAgent, attempt.Reason, now)
+	return err
+}
+
+func (s *sqlLoginAttempts) List(username string, limit int) ([]models.LoginAttempt, error) {
+	query := "SELECT id, username, ip, COALESCE(user_agent, ''), reason, created_at FROM login_attempts"
+	var args []interface{}
+	if username != "" {
+		query += " WHERE username = ?"
+		args = append(args, username)
+	}
+	query += " ORDER BY created_at DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempt
+	for rows.Next() {
+		var attempt models.LoginAttempt
+		if err := rows.Scan(&attempt.ID, &attempt.Username, &attempt.IP, &attempt.UserAgent, &attempt.Reason, &attempt.CreatedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
@@ -0,0 +1,169 @@
+// Package store hides the SQL behind the registrations, events, attendance,
+// groups, users, sessions and login attempts. Handlers get a Stores value instead of querying database.DB, so
+// they can run against the SQL implementation (SQLite or PostgreSQL) and the
+// in-memory one in tests.
+package store
+
+import (
+	"errors"
+	"time"
+
+	"posadas-sistema/models"
+)
+
+// ErrNotFound is returned when the requested row doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ErrLastSuperadmin is returned by a user change that would leave no active
+// superadmin account
+var ErrLastSuperadmin = errors.New("no active superadmin would be left")
+
+// RegistrationStore keeps the children registered for the posadas
+type RegistrationStore interface {
+	// List returns every registration, newest first
+	List() ([]models.Registration, error)
+	// ListByName returns the registrations ordered by name, only the
+	// members of a group if groupID is not 0
+	ListByName(groupID int) ([]models.Registration, error)
+	Get(id int) (models.Registration, error)
+	// Create saves reg and sets its ID
+	Create(reg *models.Registration) error
+}
+
+// EventStore keeps the rehearsals (ensayos) and outings (salidas)
+type EventStore interface {
+	// List returns every event, latest date first
+	List() ([]models.Event, error)
+	Get(id int) (models.Event, error)
+	// Create saves event, targeted to groupIDs, and sets its ID
+	Create(event *models.Event, groupIDs []int) error
+	// Update saves event and replaces the groups it is targeted to
+	Update(event models.Event, groupIDs []int) error
+	Delete(id int) error
+	// CountByType returns how many events there are of each type
+	CountByType() (map[string]int, error)
+}
+
+// MonthlyAttendance sums up the attendance marked for the events of one
+// type in one month
+type MonthlyAttendance struct {
+	Month       string // "2006-01"
+	Type        string
+	Attendances int // marked present
+	Total       int // rows of the month, marked or not
+}
+
+// AttendanceStore keeps who attended each event
+type AttendanceStore interface {
+	// ForEvent returns whether each registration with attendance marked
+	// for the event was present
+	ForEvent(eventID int) (map[int]bool, error)
+	// Replace stores the given marks of an event, replacing any previous
+	// mark of the same registrations and leaving the others alone
+	Replace(eventID int, marks []models.Attendance) error
+	// CountPresent returns how many times anybody was marked present
+	CountPresent() (int, error)
+	Monthly() ([]MonthlyAttendance, error)
+}
+
+// UserStore keeps the admin accounts. Update, SetActive and Delete refuse
+// with ErrLastSuperadmin to remove the last active superadmin, checked
+// atomically with the change.
+type UserStore interface {
+	// List returns every account without its password
+	List() ([]models.User, error)
+	// Get returns an account without its password
+	Get(id int) (models.User, error)
+	// Create saves user, whose Password is already hashed, and sets its ID
+	Create(user *models.User) error
+	// Update saves the username, email, role and must-change flag of user,
+	// and its password if Password (already hashed) is not empty
+	Update(user models.User) error
+	SetActive(id int, active bool) error
+	// FindByUsername returns the account called username with its password
+	// hash, for checking a login
+	FindByUsername(username string) (models.User, error)
+	// PasswordHash returns the password hash of an account, which Get
+	// leaves out
+	PasswordHash(id int) (string, error)
+	// SetPassword saves a new password hash and clears the must-change flag
+	SetPassword(id int, hash string) error
+	// RequirePasswordChange makes the account change its password on the
+	// next login
+	RequirePasswordChange(id int) error
+	// DisableTOTP turns 2FA off for an account and forgets its secret and
+	// recovery codes
+	DisableTOTP(id int) error
+	// Delete removes an account together with its sessions, recovery
+	// codes and password reset links
+	Delete(id int) error
+}
+
+// GroupAttendance sums up how often the members of a group were marked at
+// the events of its season targeted to it
+type GroupAttendance struct {
+	Name        string
+	Year        int
+	Members     int
+	Attendances int // marked present
+	Marked      int // marked, present or not
+}
+
+// GroupStore keeps the participant groups (coro, peregrinos, músicos, ...)
+// and the events targeted to them
+type GroupStore interface {
+	// List returns every group, newest season first
+	List() ([]models.Group, error)
+	// ForEvent returns the IDs of the groups an event is targeted to, which
+	// EventStore saves with the event
+	ForEvent(eventID int) (map[int]bool, error)
+	// Attendance sums up every group, newest season first
+	Attendance() ([]GroupAttendance, error)
+}
+
+// SessionState is a session together with the account it belongs to, as
+// checked on every authenticated request
+type SessionState struct {
+	models.Session
+	Revoked bool
+	User    models.User // without the password
+}
+
+// SessionStore keeps the login sessions of the admin accounts
+type SessionStore interface {
+	// Create saves a new session and deletes the expired ones
+	Create(session models.Session) error
+	// Lookup returns a session, revoked and expired ones included, and the
+	// current state of its user
+	Lookup(id string) (SessionState, error)
+	// Touch records that a session was used at a time from ip
+	Touch(id, ip string, at time.Time) error
+	// Revoke ends one session
+	Revoke(id string) error
+	// Active returns the sessions of a user that are neither revoked nor
+	// expired, most recently used first
+	Active(userID int) ([]models.Session, error)
+	// RevokeUser ends every session of a user except keepID, which may be
+	// "" to end them all
+	RevokeUser(userID int, keepID string) error
+}
+
+// LoginAttemptStore keeps the failed logins for the admins to review
+type LoginAttemptStore interface {
+	// Record stores an attempt and forgets those older than keep
+	Record(attempt models.LoginAttempt, keep time.Duration) error
+	// List returns the latest limit attempts, newest first, only those
+	// of username if it is not ""
+	List(username string, limit int) ([]models.LoginAttempt, error)
+}
+
+// Stores groups the stores the handlers use
+type Stores struct {
+	Registrations RegistrationStore
+	Events        EventStore
+	Attendance    AttendanceStore
+	Groups        GroupStore
+	Users         UserStore
+	Sessions      SessionStore
+	LoginAttempts LoginAttemptStore
+}
@@ -0,0 +1,720 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"posadas-sistema/database"
+	"posadas-sistema/models"
+)
+
+// testDSNEnv names the environment variable with a PostgreSQL connection
+// string to also run the tests there. The tests drop every table of that
+// database, so it must be a throwaway one.
+const testDSNEnv = "POSADAS_TEST_DB_URL"
+
+// testStores are stores under test, with a way to add the groups and
+// members that the stores themselves don't write
+type testStores struct {
+	Stores
+	addGroup  func(name string, year int) int
+	addMember func(groupID, registrationID int)
+}
+
+// eachStore runs test against store.Memory, SQLite and, if testDSNEnv is
+// set, PostgreSQL, each one empty
+func eachStore(t *testing.T, test func(t *testing.T, s testStores)) {
+	t.Run("memory", func(t *testing.T) {
+		mem := NewMemory()
+		test(t, testStores{
+			Stores: mem.Stores(),
+			addGroup: func(name string, year int) int {
+				group := models.Group{Name: name, Year: year}
+				mem.AddGroup(&group)
+				return group.ID
+			},
+			addMember: func(groupID, registrationID int) {
+				mem.GroupMembers[groupID] = append(mem.GroupMembers[groupID], registrationID)
+			},
+		})
+	})
+
+	for _, driver := range []string{database.SQLite, database.Postgres} {
+		t.Run(driver, func(t *testing.T) {
+			openSQL(t, driver)
+			test(t, testStores{
+				Stores: NewSQL(database.DB),
+				addGroup: func(name string, year int) int {
+					id, err := database.InsertID(database.DB, "INSERT INTO participant_groups (name, year, description) VALUES (?, ?, '')", name, year)
+					if err != nil {
+						t.Fatal(err)
+					}
+					return int(id)
+				},
+				addMember: func(groupID, registrationID int) {
+					_, err := database.DB.Exec("INSERT INTO group_members (group_id, registration_id) VALUES (?, ?)", groupID, registrationID)
+					if err != nil {
+						t.Fatal(err)
+					}
+				},
+			})
+		})
+	}
+}
+
+// openSQL opens a migrated, empty database of driver as database.DB for
+// the length of t, or skips PostgreSQL if testDSNEnv isn't set
+func openSQL(t *testing.T, driver string) {
+	t.Helper()
+	source := filepath.Join(t.TempDir(), "test.db")
+	if driver == database.Postgres {
+		source = os.Getenv(testDSNEnv)
+		if source == "" {
+			t.Skip(testDSNEnv + " is not set")
+		}
+	}
+
+	if err := database.Open(driver, source); err != nil {
+		t.Fatal(err)
+	}
+	dropAll := func() {
+		migrations, err := database.Migrations()
+		if err == nil {
+			_, err = database.Rollback(len(migrations), true)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		if database.IsPostgres() {
+			dropAll()
+		}
+		database.DB.Close()
+		database.Driver = database.SQLite
+	})
+	if database.IsPostgres() {
+		dropAll()
+	}
+	if err := database.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// date returns midnight UTC of a day of December
+func date(year, day int) time.Time {
+	return time.Date(year, time.December, day, 0, 0, 0, 0, time.UTC)
+}
+
+// addRegistrations creates a registration per name and returns their IDs
+func addRegistrations(t *testing.T, s testStores, names ...string) []int {
+	t.Helper()
+	var ids []int
+	for _, name := range names {
+		reg := models.Registration{Name: name, Age: 9, DNI: "dni-" + name, Year: 2025}
+		if err := s.Registrations.Create(&reg); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, reg.ID)
+	}
+	return ids
+}
+
+// addEvent creates an event of eventType on day, targeted to groupIDs, and
+// returns its ID
+func addEvent(t *testing.T, s testStores, eventType string, day time.Time, groupIDs ...int) int {
+	t.Helper()
+	event := models.Event{Name: "Posada", Type: eventType, Date: day, Time: "19:00", Location: "Centro"}
+	if err := s.Events.Create(&event, groupIDs); err != nil {
+		t.Fatal(err)
+	}
+	return event.ID
+}
+
+// targetEvent saves an event unchanged but targeted to groupIDs
+func targetEvent(t *testing.T, s testStores, eventID int, groupIDs ...int) {
+	t.Helper()
+	event, err := s.Events.Get(eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Events.Update(event, groupIDs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEventWithGroupsIsAllOrNothing(t *testing.T) {
+	openSQL(t, database.SQLite)
+	s := NewSQL(database.DB)
+	missingGroup := 999
+
+	event := models.Event{Name: "Posada", Type: "salida", Date: time.Date(2025, 12, 16, 0, 0, 0, 0, time.UTC)}
+	if err := s.Events.Create(&event, []int{missingGroup}); err == nil {
+		t.Fatal("event created with a group that doesn't exist")
+	}
+	if events, _ := s.Events.List(); len(events) != 0 {
+		t.Errorf("event saved without its groups: %+v", events)
+	}
+
+	if err := s.Events.Create(&event, nil); err != nil {
+		t.Fatal(err)
+	}
+	event.Name = "Posada final"
+	if err := s.Events.Update(event, []int{missingGroup}); err == nil {
+		t.Fatal("event updated with a group that doesn't exist")
+	}
+	if saved, _ := s.Events.Get(event.ID); saved.Name != "Posada" {
+		t.Errorf("update saved without its groups: %+v", saved)
+	}
+}
+
+func TestRegistrationStore(t *testing.T) {
+	eachStore(t, func(t *testing.T, s testStores) {
+		ids := addRegistrations(t, s, "Carmen", "Ana", "Beto")
+
+		reg, err := s.Registrations.Get(ids[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reg.Name != "Ana" || reg.DNI != "dni-Ana" || reg.Age != 9 || reg.Year != 2025 {
+			t.Errorf("got %+v", reg)
+		}
+		if _, err := s.Registrations.Get(-1); err != ErrNotFound {
+			t.Errorf("missing registration: got %v, want ErrNotFound", err)
+		}
+
+		all, err := s.Registrations.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 3 {
+			t.Errorf("List: got %d, want 3", len(all))
+		}
+
+		byName, err := s.Registrations.ListByName(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names := registrationNames(byName); names != "Ana,Beto,Carmen" {
+			t.Errorf("ListByName: got %s", names)
+		}
+
+		group := s.addGroup("Coro", 2025)
+		s.addMember(group, ids[0])
+		s.addMember(group, ids[2])
+		members, err := s.Registrations.ListByName(group)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names := registrationNames(members); names != "Beto,Carmen" {
+			t.Errorf("ListByName(group): got %s", names)
+		}
+	})
+}
+
+func registrationNames(registrations []models.Registration) string {
+	names := ""
+	for i, reg := range registrations {
+		if i > 0 {
+			names += ","
+		}
+		names += reg.Name
+	}
+	return names
+}
+
+func TestEventStore(t *testing.T) {
+	eachStore(t, func(t *testing.T, s testStores) {
+		first := addEvent(t, s, "ensayo", date(2025, 10))
+		second := addEvent(t, s, "salida", date(2025, 16))
+		addEvent(t, s, "ensayo", date(2025, 12))
+
+		event, err := s.Events.Get(second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != "salida" || event.Date.Format("2006-01-02") != "2025-12-16" || event.Location != "Centro" {
+			t.Errorf("got %+v", event)
+		}
+
+		event.Name = "Posada final"
+		event.Date = date(2025, 23)
+		if err := s.Events.Update(event, nil); err != nil {
+			t.Fatal(err)
+		}
+		if event, err = s.Events.Get(second); err != nil {
+			t.Fatal(err)
+		}
+		if event.Name != "Posada final" || event.Date.Format("2006-01-02") != "2025-12-23" {
+			t.Errorf("after update: got %+v", event)
+		}
+
+		events, err := s.Events.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var days []int
+		for _, event := range events {
+			days = append(days, event.Date.Day())
+		}
+		if len(days) != 3 || days[0] != 23 || days[1] != 12 || days[2] != 10 {
+			t.Errorf("List: got days %v, want [23 12 10]", days)
+		}
+
+		counts, err := s.Events.CountByType()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counts["ensayo"] != 2 || counts["salida"] != 1 {
+			t.Errorf("CountByType: got %v", counts)
+		}
+
+		// Deleting an event takes its attendance and groups with it
+		reg := addRegistrations(t, s, "Ana")[0]
+		if err := s.Attendance.Replace(first, []models.Attendance{{RegistrationID: reg, Present: true}}); err != nil {
+			t.Fatal(err)
+		}
+		group := s.addGroup("Coro", 2025)
+		targetEvent(t, s, first, group)
+		if err := s.Events.Delete(first); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Events.Get(first); err != ErrNotFound {
+			t.Errorf("deleted event: got %v, want ErrNotFound", err)
+		}
+		if marks, _ := s.Attendance.ForEvent(first); len(marks) != 0 {
+			t.Errorf("attendance of the deleted event left: %v", marks)
+		}
+		if groups, _ := s.Groups.ForEvent(first); len(groups) != 0 {
+			t.Errorf("groups of the deleted event left: %v", groups)
+		}
+	})
+}
+
+func TestAttendanceStore(t *testing.T) {
+	eachStore(t, func(t *testing.T, s testStores) {
+		ids := addRegistrations(t, s, "Ana", "Beto", "Carmen")
+		november := addEvent(t, s, "ensayo", date(2025, 1).AddDate(0, -1, 0))
+		december := addEvent(t, s, "salida", date(2025, 16))
+		addEvent(t, s, "salida", date(2025, 17)) // nobody marked
+
+		err := s.Attendance.Replace(december, []models.Attendance{
+			{RegistrationID: ids[0], Present: true},
+			{RegistrationID: ids[1], Present: false},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Marking again replaces only the given registrations
+		err = s.Attendance.Replace(december, []models.Attendance{
+			{RegistrationID: ids[1], Present: true},
+			{RegistrationID: ids[2], Present: false},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Attendance.Replace(november, []models.Attendance{{RegistrationID: ids[0], Present: true}}); err != nil {
+			t.Fatal(err)
+		}
+
+		marks, err := s.Attendance.ForEvent(december)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(marks) != 3 || !marks[ids[0]] || !marks[ids[1]] || marks[ids[2]] {
+			t.Errorf("ForEvent: got %v", marks)
+		}
+
+		present, err := s.Attendance.CountPresent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if present != 3 {
+			t.Errorf("CountPresent: got %d, want 3", present)
+		}
+
+		months, err := s.Attendance.Monthly()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []MonthlyAttendance{
+			{Month: "2025-11", Type: "ensayo", Attendances: 1, Total: 1},
+			{Month: "2025-12", Type: "salida", Attendances: 2, Total: 4},
+		}
+		if len(months) != len(want) {
+			t.Fatalf("Monthly: got %+v, want %+v", months, want)
+		}
+		for i := range want {
+			if months[i] != want[i] {
+				t.Errorf("Monthly[%d]: got %+v, want %+v", i, months[i], want[i])
+			}
+		}
+	})
+}
+
+func TestGroupStore(t *testing.T) {
+	eachStore(t, func(t *testing.T, s testStores) {
+		old := s.addGroup("Coro", 2024)
+		coro := s.addGroup("Coro", 2025)
+		musicos := s.addGroup("Músicos", 2025)
+
+		groups, err := s.Groups.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var order []int
+		for _, group := range groups {
+			order = append(order, group.ID)
+		}
+		if len(order) != 3 || order[0] != coro || order[1] != musicos || order[2] != old {
+			t.Errorf("List: got %v, want [%d %d %d]", order, coro, musicos, old)
+		}
+
+		ids := addRegistrations(t, s, "Ana", "Beto", "Carmen")
+		s.addMember(coro, ids[0])
+		s.addMember(coro, ids[1])
+		s.addMember(old, ids[2])
+
+		targeted := addEvent(t, s, "salida", date(2025, 16), musicos)
+		other := addEvent(t, s, "salida", date(2025, 17))
+		// A 2025 group targeted by a 2024 event doesn't count it
+		lastYear := addEvent(t, s, "salida", date(2024, 16), coro, old)
+		// Updating the event replaces its groups
+		targetEvent(t, s, targeted, coro)
+		selected, err := s.Groups.ForEvent(targeted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(selected) != 1 || !selected[coro] {
+			t.Errorf("ForEvent: got %v, want only %d", selected, coro)
+		}
+
+		for _, eventID := range []int{targeted, other, lastYear} {
+			err := s.Attendance.Replace(eventID, []models.Attendance{
+				{RegistrationID: ids[0], Present: true},
+				{RegistrationID: ids[1], Present: false},
+				{RegistrationID: ids[2], Present: true},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		summary, err := s.Groups.Attendance()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []GroupAttendance{
+			{Name: "Coro", Year: 2025, Members: 2, Attendances: 1, Marked: 2},
+			{Name: "Músicos", Year: 2025},
+			{Name: "Coro", Year: 2024, Members: 1, Attendances: 1, Marked: 1},
+		}
+		if len(summary) != len(want) {
+			t.Fatalf("Attendance: got %+v, want %+v", summary, want)
+		}
+		for i := range want {
+			if summary[i] != want[i] {
+				t.Errorf("Attendance[%d]: got %+v, want %+v", i, summary[i], want[i])
+			}
+		}
+	})
+}
+
+func TestUserStore(t *testing.T) {
+	eachStore(t, func(t *testing.T, s testStores) {
+		user := models.User{Username: "ana", Password: "hash-1", Email: "ana@example.com", IsActive: true, Role: "coordinator"}
+		if err := s.Users.Create(&user); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Users.Create(&models.User{Username: "ana", Password: "x", IsActive: true, Role: "viewer"}); err == nil {
+			t.Error("duplicate username created")
+		}
+
+		got, err := s.Users.Get(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Username != "ana" || got.Email != "ana@example.com" || !got.IsActive || got.Role != "coordinator" || got.Password != "" {
+			t.Errorf("Get: got %+v", got)
+		}
+		if _, err := s.Users.Get(-1); err != ErrNotFound {
+			t.Errorf("missing user: got %v, want ErrNotFound", err)
+		}
+
+		found, err := s.Users.FindByUsername("ana")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.ID != user.ID || found.Password != "hash-1" {
+			t.Errorf("FindByUsername: got %+v", found)
+		}
+		if _, err := s.Users.FindByUsername("nadie"); err != ErrNotFound {
+			t.Errorf("FindByUsername of nobody: got %v, want ErrNotFound", err)
+		}
+
+		// Updating without a password keeps the old one
+		got.Role = "viewer"
+		if err := s.Users.Update(got); err != nil {
+			t.Fatal(err)
+		}
+		if hash, err := s.Users.PasswordHash(user.ID); err != nil || hash != "hash-1" {
+			t.Errorf("PasswordHash after update: got %q, %v", hash, err)
+		}
+
+		if err := s.Users.RequirePasswordChange(user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := s.Users.Get(user.ID); !got.MustChangePassword || got.Role != "viewer" {
+			t.Errorf("after RequirePasswordChange: got %+v", got)
+		}
+		if err := s.Users.SetPassword(user.ID, "hash-2"); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := s.Users.Get(user.ID); got.MustChangePassword {
+			t.Error("SetPassword didn't clear the must-change flag")
+		}
+		if hash, _ := s.Users.PasswordHash(user.ID); hash != "hash-2" {
+			t.Errorf("PasswordHash after SetPassword: got %q", hash)
+		}
+
+		if err := s.Users.SetActive(user.ID, false); err != nil {
+			t.Fatal(err)
+		}
+		users, err := s.Users.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 1 || users[0].IsActive || users[0].Password != "" {
+			t.Errorf("List: got %+v", users)
+		}
+
+		// Deleting an account ends its sessions
+		now := time.Now()
+		if err := s.Sessions.Create(models.Session{ID: "s1", UserID: user.ID, CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Users.Delete(user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Users.Get(user.ID); err != ErrNotFound {
+			t.Errorf("deleted user: got %v, want ErrNotFound", err)
+		}
+		if sessions, _ := s.Sessions.Active(user.ID); len(sessions) != 0 {
+			t.Errorf("sessions of the deleted user left: %+v", sessions)
+		}
+	})
+}
+
+func TestUserStoreKeepsASuperadmin(t *testing.T) {
+	eachStore(t, func(t *testing.T, s testStores) {
+		root := models.User{Username: "root", Password: "x", IsActive: true, Role: "superadmin"}
+		ana := models.User{Username: "ana", Password: "x", IsActive: true, Role: "superadmin"}
+		for _, user := range []*models.User{&root, &ana} {
+			if err := s.Users.Create(user); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// One of two superadmins can go
+		ana.Role = "viewer"
+		if err := s.Users.Update(ana); err != nil {
+			t.Fatal(err)
+		}
+
+		root.Role = "coordinator"
+		if err := s.Users.Update(root); err != ErrLastSuperadmin {
+			t.Errorf("demoting the last one: got %v, want ErrLastSuperadmin", err)
+		}
+		if err := s.Users.SetActive(root.ID, false); err != ErrLastSuperadmin {
+			t.Errorf("deactivating the last one: got %v, want ErrLastSuperadmin", err)
+		}
+		if err := s.Users.Delete(root.ID); err != ErrLastSuperadmin {
+			t.Errorf("deleting the last one: got %v, want ErrLastSuperadmin", err)
+		}
+		got, err := s.Users.Get(root.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Role != "superadmin" || !got.IsActive {
+			t.Errorf("last superadmin changed anyway: %+v", got)
+		}
+
+		// Other accounts aren't held back
+		if err := s.Users.SetActive(ana.ID, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Users.Delete(ana.ID); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestSessionStore(t *testing.T) {
+	eachStore(t, func(t *testing.T, s testStores) {
+		user := models.User{Username: "ana", Password: "x", IsActive: true, Role: "viewer"}
+		if err := s.Users.Create(&user); err != nil {
+			t.Fatal(err)
+		}
+
+		now := time.Now()
+		sessions := []models.Session{
+			{ID: "old", LastSeenAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour)},
+			{ID: "recent", LastSeenAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)},
+			{ID: "expired", LastSeenAt: now.Add(-3 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+			{ID: "other", LastSeenAt: now, ExpiresAt: now.Add(time.Hour)},
+		}
+		for _, session := range sessions {
+			session.UserID = user.ID
+			session.IP = "192.0.2.1"
+			session.UserAgent = "test"
+			session.CreatedAt = session.LastSeenAt
+			if err := s.Sessions.Create(session); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		active := activeIDs(t, s, user.ID)
+		if active != "other,recent,old" {
+			t.Errorf("Active: got %s, want other,recent,old", active)
+		}
+
+		if err := s.Sessions.RevokeUser(user.ID, "recent"); err != nil {
+			t.Fatal(err)
+		}
+		if active := activeIDs(t, s, user.ID); active != "recent" {
+			t.Errorf("after revoking all but recent: got %s", active)
+		}
+		if err := s.Sessions.RevokeUser(user.ID, ""); err != nil {
+			t.Fatal(err)
+		}
+		if active := activeIDs(t, s, user.ID); active != "" {
+			t.Errorf("after revoking all: got %s", active)
+		}
+	})
+}
+
+func TestSessionStoreLookup(t *testing.T) {
+	eachStore(t, func(t *testing.T, s testStores) {
+		user := models.User{Username: "ana", Password: "x", IsActive: true, Role: "coordinator", MustChangePassword: true}
+		if err := s.Users.Create(&user); err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		session := models.Session{ID: "s1", UserID: user.ID, IP: "192.0.2.1", CreatedAt: now, LastSeenAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)}
+		if err := s.Sessions.Create(session); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := s.Sessions.Lookup("missing"); err != ErrNotFound {
+			t.Errorf("missing session: got %v, want ErrNotFound", err)
+		}
+		state, err := s.Sessions.Lookup("s1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.UserID != user.ID || state.Revoked || state.User.Role != "coordinator" || !state.User.IsActive || !state.User.MustChangePassword || state.User.Password != "" {
+			t.Errorf("Lookup: got %+v", state)
+		}
+
+		if err := s.Sessions.Touch("s1", "192.0.2.9", now); err != nil {
+			t.Fatal(err)
+		}
+		state, err = s.Sessions.Lookup("s1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.IP != "192.0.2.9" || state.LastSeenAt.Sub(now).Abs() > time.Second {
+			t.Errorf("after Touch: ip %s, last seen %v, want 192.0.2.9 at %v", state.IP, state.LastSeenAt, now)
+		}
+
+		// Role changes show up on the next lookup
+		user.Role = "viewer"
+		if err := s.Users.Update(user); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Sessions.Revoke("s1"); err != nil {
+			t.Fatal(err)
+		}
+		state, err = s.Sessions.Lookup("s1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !state.Revoked || state.User.Role != "viewer" {
+			t.Errorf("after Revoke: got %+v", state)
+		}
+		if active := activeIDs(t, s, user.ID); active != "" {
+			t.Errorf("revoked session still active: %s", active)
+		}
+	})
+}
+
+func activeIDs(t *testing.T, s testStores, userID int) string {
+	t.Helper()
+	sessions, err := s.Sessions.Active(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := ""
+	for i, session := range sessions {
+		if i > 0 {
+			ids += ","
+		}
+		ids += session.ID
+	}
+	return ids
+}
+
+func TestLoginAttemptStore(t *testing.T) {
+	eachStore(t, func(t *testing.T, s testStores) {
+		for _, username := range []string{"ana", "beto", "ana"} {
+			err := s.LoginAttempts.Record(models.LoginAttempt{Username: username, IP: "192.0.2.1", UserAgent: "test", Reason: "bad_password"}, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		all, err := s.LoginAttempts.List("", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 3 || all[0].Username != "ana" || all[1].Username != "beto" || all[2].Username != "ana" {
+			t.Fatalf("List: got %+v", all)
+		}
+		if all[0].ID <= all[1].ID || all[1].ID <= all[2].ID {
+			t.Errorf("List isn't newest first: ids %d, %d, %d", all[0].ID, all[1].ID, all[2].ID)
+		}
+		if all[0].IP != "192.0.2.1" || all[0].UserAgent != "test" || all[0].Reason != "bad_password" || all[0].CreatedAt.IsZero() {
+			t.Errorf("List: got %+v", all[0])
+		}
+
+		ana, err := s.LoginAttempts.List("ana", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ana) != 2 {
+			t.Errorf("List(ana): got %d, want 2", len(ana))
+		}
+		latest, err := s.LoginAttempts.List("", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(latest) != 1 || latest[0].ID != all[0].ID {
+			t.Errorf("List with limit 1: got %+v", latest)
+		}
+
+		// Recording forgets the attempts older than keep
+		time.Sleep(10 * time.Millisecond)
+		if err := s.LoginAttempts.Record(models.LoginAttempt{Username: "carla", IP: "192.0.2.2", Reason: "bad_password"}, 5*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		kept, err := s.LoginAttempts.List("", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(kept) != 1 || kept[0].Username != "carla" {
+			t.Errorf("List after pruning: got %+v", kept)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+// Command fetch-assets downloads the pinned front-end libraries into
+// static/vendor, so the pages work without internet access. Run it once
+// with internet access and commit the files; after that only to add or
+// upgrade a library:
+//
+//	go generate   (from the repository root)
+//
+// Files that already exist are kept. Every file is checked against its
+// pinned Subresource Integrity hash.
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// asset is a file to download and where it goes under static/vendor
+type asset struct {
+	url       string
+	dest      string
+	integrity string // SRI hash the download must match
+}
+
+const (
+	bootstrap   = "https://cdn.jsdelivr.net/npm/bootstrap@5.3.3/dist/"
+	fontAwesome = "https://cdnjs.cloudflare.com/ajax/libs/font-awesome/6.5.2/"
+)
+
+func assets() []asset {
+	return []asset{
+		{bootstrap + "css/bootstrap.min.css", "bootstrap/bootstrap.min.css", "sha384-QWTKZyjpPEjISv5WaRU9OFeRpok6YctnYmDr5pNlyT2bRjXh0JMhjY6hW+ALEwIH"},
+		{bootstrap + "js/bootstrap.bundle.min.js", "bootstrap/bootstrap.bundle.min.js", "sha384-YvpcrYf0tY3lHB60NNkmXc5s9fDVZLESaAA55NDzOxhy9GkcIdslK1eN7N6jIeHz"},
+		{fontAwesome + "css/all.min.css", "fontawesome/css/all.min.css", "sha512-SnH5WK+bZxgPHs44uWIX+LLJAJ9/2PkPKZ5QiAj6Ta86w+fsb2TkcmfRyVX3pBnMFcV7oQPJkl9QevSCWr3W6A=="},
+		// all.min.css loads its fonts from ../webfonts
+		{fontAwesome + "webfonts/fa-brands-400.woff2", "fontawesome/webfonts/fa-brands-400.woff2", "sha384-g9K2lOo3AlgPKqilrKrMHEJ9QxxMlz1kyDL9Jgx9tVwiW6j3MJBRjDwy9nl1eDt7"},
+		{fontAwesome + "webfonts/fa-brands-400.ttf", "fontawesome/webfonts/fa-brands-400.ttf", "sha384-Va08PQeqJnKHAFQoOuKQppm7z5q4ZC5j9gPuSVE7etEF7HOPaxHvir0EVH1mGZVs"},
+		{fontAwesome + "webfonts/fa-regular-400.woff2", "fontawesome/webfonts/fa-regular-400.woff2", "sha384-uS2tPp3jQsPaWT2H9k+vm/UAvAN+Zy++zJtuk3XOcUtIB/6Yhul0OChBDKuJ55so"},
+		{fontAwesome + "webfonts/fa-regular-400.ttf", "fontawesome/webfonts/fa-regular-400.ttf", "sha384-VInX0LHJTcny5YH+F+GtU9hDkBVMyvlMQW7KumylJol/t2WQSaHx1pLN/FLtr5XK"},
+		{fontAwesome + "webfonts/fa-solid-900.woff2", "fontawesome/webfonts/fa-solid-900.woff2", "sha384-1M58liUxGNxZ0Q9r/9CCQmJ3SooL9WeOsNpMSxuFZOH8EgpybBzFvdR5+0krLY4X"},
+		{fontAwesome + "webfonts/fa-solid-900.ttf", "fontawesome/webfonts/fa-solid-900.ttf", "sha384-sZfqBaTye14K2lZAVSimR9AGdboFgIWjO7nyfkS+rJFsilWEhPy1SUI/PQCuFYbb"},
+		{fontAwesome + "webfonts/fa-v4compatibility.woff2", "fontawesome/webfonts/fa-v4compatibility.woff2", "sha384-amwoqyL5mRgqtGWvWPyTEljU1t+pdfuBr7Dic1Z48RhvEWqmhi5bbVdqAEJpMRpC"},
+		{fontAwesome + "webfonts/fa-v4compatibility.ttf", "fontawesome/webfonts/fa-v4compatibility.ttf", "sha384-IlM7R1Rn237uqF2FshKrvfc/llXadTOkJVKyeOCV9OOPq26sKL2LjC1Nq2fKMTZE"},
+	}
+}
+
+func main() {
+	dir := flag.String("dir", "static/vendor", "directory to write the files to")
+	force := flag.Bool("force", false, "download files that already exist again")
+	flag.Parse()
+
+	client := &http.Client{Timeout: time.Minute}
+	for _, a := range assets() {
+		dest := filepath.Join(*dir, filepath.FromSlash(a.dest))
+		if _, err := os.Stat(dest); err == nil && !*force {
+			continue
+		}
+		if err := fetch(client, a, dest); err != nil {
+			log.Fatalf("%s: %v", a.url, err)
+		}
+		fmt.Println("fetched", dest)
+	}
+}
+
+// fetch downloads a into dest, checking its integrity hash if it has one
+func fetch(client *http.Client, a asset, dest string) error {
+	resp, err := client.Get(a.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := checkIntegrity(content, a.integrity); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(dest, content, 0o644)
+}
+
+// checkIntegrity compares content with an SRI hash such as "sha384-..."
+func checkIntegrity(content []byte, integrity string) error {
+	algorithm, want, _ := strings.Cut(integrity, "-")
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha384":
+		h = sha512.New384()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("unknown integrity algorithm %q", algorithm)
+	}
+	h.Write(content)
+	if got := base64.StdEncoding.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("integrity mismatch: got %s-%s", algorithm, got)
+	}
+	return nil
+}